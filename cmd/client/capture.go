@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/capture"
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// handshakeCapture records the handshake of every test if -capture is set
+var handshakeCapture *capture.Writer
+
+// decodeCapture prints each packet in a capture written with -capture (or read from stdin if no
+// file is given) as a line with its time, offset from the first packet, direction and fields
+func decodeCapture(args []string) {
+	in := io.Reader(os.Stdin)
+	if len(args) > 0 {
+		file, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open capture file")
+		}
+		defer file.Close()
+		in = file
+	}
+
+	r, err := capture.NewReader(in)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to read capture")
+	}
+
+	var first time.Time
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to read capture")
+		}
+		if first.IsZero() {
+			first = rec.Time
+		}
+
+		fmt.Printf("%s +%-12s %s %s\n", rec.Time.UTC().Format(time.RFC3339Nano), rec.Time.Sub(first), rec.Direction, describePacket(rec.Data))
+	}
+}
+
+// describePacket renders a captured packet's type and fields, falling back to its header and raw
+// bytes when it can't be parsed
+func describePacket(data []byte) string {
+	pkt, err := packets.Unmarshal(data)
+	if err == nil {
+		header, _ := protocol.UnmarshalHeader(data)
+		return fmt.Sprintf("%-9s %s", packets.PacketTypeToString(header.Type), strings.TrimPrefix(fmt.Sprintf("%+v", pkt), "&"))
+	}
+
+	header, errHeader := protocol.UnmarshalHeader(data)
+	if errHeader != nil {
+		return fmt.Sprintf("INVALID   %d bytes (%v): %x", len(data), errHeader, data)
+	}
+	return fmt.Sprintf("%-9s %d bytes (%v): %x", packets.PacketTypeToString(header.Type), len(data), err, data)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/goodieshq/goflo/internal/client"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/goodieshq/goflo/internal/report"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// runCompare runs the test over TCP and then over UDP with the same parameters, and logs the two
+// side by side. TCP retransmits what the path drops, so loss shows in its throughput and
+// retransmissions (where the kernel reports them), while UDP shows it as data that never arrived.
+// Jitter is not compared: datagrams carry no send time to measure it from.
+func runCompare(ctx context.Context, cli *client.ClientTCP, runOpts client.RunOpts, writer *report.Writer) error {
+	policy := client.RetryPolicy{
+		Retries: flagRetries,
+		Backoff: flagBackoff,
+	}
+
+	// check both tests up front, so one the UDP transport can't run doesn't follow a full TCP test
+	transports := []packets.FloTransport{packets.TransportTCP, packets.TransportUDP}
+	tests := make([]client.RunOpts, len(transports))
+	for i, transport := range transports {
+		tests[i] = runOpts
+		tests[i].Transport = utils.Ptr(transport)
+		if err := tests[i].Validate(); err != nil {
+			return fmt.Errorf("cannot compare over %s: %w", packets.TransportToString(transport), err)
+		}
+	}
+
+	reports := make([]*report.Report, len(transports))
+	for i, transport := range transports {
+		log.Info().Str("transport", packets.TransportToString(transport)).Msg("Starting comparison test")
+		rpt, err := client.RunWithRetry(ctx, cli, tests[i], policy)
+		if err != nil {
+			return fmt.Errorf("failed %s test: %w", packets.TransportToString(transport), err)
+		}
+		reports[i] = rpt
+
+		if writer != nil {
+			err = writer.Write(rpt)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to write report")
+			}
+		}
+	}
+
+	tcp, udp := reports[0], reports[1]
+	evt := log.Info().Str("direction", tcp.Direction).
+		Str("tcp_bitrate", displayBitrate(tcp)).
+		Str("udp_bitrate", displayBitrate(udp))
+	if tcpBps := tcp.AvgSentBps() + tcp.AvgRcvdBps(); tcpBps > 0 {
+		evt = evt.Str("udp_vs_tcp", utils.DisplayPercent((udp.AvgSentBps()+udp.AvgRcvdBps())/tcpBps))
+	}
+	if tcp.TCP != nil && tcp.TCP.SegmentsOut > 0 {
+		evt = evt.Str("tcp_retrans_rate", utils.DisplayPercent(tcp.TCP.RetransmitRate()))
+	}
+	if loss, ok := tcp.DataLoss(); ok {
+		evt = evt.Str("tcp_loss", utils.DisplayPercent(loss))
+	}
+	if loss, ok := udp.DataLoss(); ok {
+		evt = evt.Str("udp_loss", utils.DisplayPercent(loss))
+	} else {
+		evt = evt.Str("udp_loss", "unknown (no result from the server)")
+	}
+	evt.Msg("Transport comparison")
+	return nil
+}
+
+// displayBitrate formats the measured bitrate of a one-way test, whichever way it flowed
+func displayBitrate(rpt *report.Report) string {
+	return utils.DisplayBitsPerTime(rpt.BytesSent+rpt.BytesRcvd, rpt.Duration)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/goodieshq/goflo/internal/report"
+	"github.com/rs/zerolog/log"
+)
+
+// decode prints each MessagePack report read from the file (or stdin if none) as a JSON line, for
+// inspecting the output of -output-format msgpack
+func decode(args []string) {
+	in := io.Reader(os.Stdin)
+	if len(args) > 0 {
+		file, err := os.Open(args[0])
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to open report file")
+		}
+		defer file.Close()
+		in = file
+	}
+
+	data, err := io.ReadAll(in)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to read reports")
+	}
+
+	for len(data) > 0 {
+		var v any
+		v, data, err = report.DecodeMsgpack(data)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to decode report")
+		}
+
+		line, err := json.Marshal(v)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to encode report as JSON")
+		}
+		fmt.Println(string(line))
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/client"
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/report"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/rs/zerolog"
+)
+
+// nagiosStatus is a monitoring plugin's state, which is also its exit code
+type nagiosStatus int
+
+const (
+	nagiosOK       nagiosStatus = 0
+	nagiosWarning  nagiosStatus = 1
+	nagiosCritical nagiosStatus = 2
+	nagiosUnknown  nagiosStatus = 3
+)
+
+func (s nagiosStatus) String() string {
+	switch s {
+	case nagiosOK:
+		return "OK"
+	case nagiosWarning:
+		return "WARNING"
+	case nagiosCritical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// nagiosCheck accumulates the worst status, the problems behind it and the perfdata of a check
+type nagiosCheck struct {
+	status   nagiosStatus
+	problems []string
+	perf     []string
+}
+
+func (c *nagiosCheck) raise(status nagiosStatus, problem string) {
+	c.status = max(c.status, status)
+	c.problems = append(c.problems, problem)
+}
+
+// threshold formats an optional perfdata threshold, left empty when unset
+func threshold[T comparable](v T, format func(T) string) string {
+	var zero T
+	if v == zero {
+		return ""
+	}
+	return format(v)
+}
+
+// perfdata parsers don't all accept exponents, so values are printed in full
+func formatUint(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatSeconds(d time.Duration) string {
+	return formatFloat(d.Seconds())
+}
+
+// bitrate checks a direction's average bitrate against the lower bounds
+func (c *nagiosCheck) bitrate(name string, bps float64) {
+	display := utils.DisplayBitsPerTime(uint64(bps/8), time.Second)
+	switch {
+	case *flagCritBitrate > 0 && bps < float64(*flagCritBitrate):
+		c.raise(nagiosCritical, fmt.Sprintf("%s %s below %s", name, display, utils.DisplayBitsPerTime(*flagCritBitrate/8, time.Second)))
+	case *flagWarnBitrate > 0 && bps < float64(*flagWarnBitrate):
+		c.raise(nagiosWarning, fmt.Sprintf("%s %s below %s", name, display, utils.DisplayBitsPerTime(*flagWarnBitrate/8, time.Second)))
+	}
+	c.perf = append(c.perf, fmt.Sprintf("%s=%.0f;%s;%s;0;", name, bps,
+		threshold(*flagWarnBitrate, formatUint), threshold(*flagCritBitrate, formatUint)))
+}
+
+// loss checks the retransmission rate, as a percentage, against the upper bounds
+func (c *nagiosCheck) loss(pct float64) {
+	switch {
+	case *flagCritLoss > 0 && pct > *flagCritLoss:
+		c.raise(nagiosCritical, fmt.Sprintf("retransmissions %.2f%% above %.2f%%", pct, *flagCritLoss))
+	case *flagWarnLoss > 0 && pct > *flagWarnLoss:
+		c.raise(nagiosWarning, fmt.Sprintf("retransmissions %.2f%% above %.2f%%", pct, *flagWarnLoss))
+	}
+	c.perf = append(c.perf, fmt.Sprintf("retrans=%.3f%%;%s;%s;0;100", pct,
+		threshold(*flagWarnLoss, formatFloat), threshold(*flagCritLoss, formatFloat)))
+}
+
+// latency checks the connect time, one round trip to the server, against the upper bounds
+func (c *nagiosCheck) latency(d time.Duration) {
+	switch {
+	case *flagCritLatency > 0 && d > *flagCritLatency:
+		c.raise(nagiosCritical, fmt.Sprintf("connect %s above %s", utils.DisplayTime(d), utils.DisplayTime(*flagCritLatency)))
+	case *flagWarnLatency > 0 && d > *flagWarnLatency:
+		c.raise(nagiosWarning, fmt.Sprintf("connect %s above %s", utils.DisplayTime(d), utils.DisplayTime(*flagWarnLatency)))
+	}
+	c.perf = append(c.perf, fmt.Sprintf("connect=%ss;%s;%s;0;", formatSeconds(d),
+		threshold(*flagWarnLatency, formatSeconds), threshold(*flagCritLatency, formatSeconds)))
+}
+
+// evaluateNagios checks a completed test against the thresholds. Retransmissions are only known
+// when the client sends, on platforms that expose them.
+func evaluateNagios(rpt *report.Report) *nagiosCheck {
+	check := &nagiosCheck{}
+
+	direction, _ := protocol.ParseDirection(rpt.Direction)
+	if direction != protocol.DirectionDownload {
+		check.bitrate("sent", rpt.AvgSentBps())
+	}
+	if direction != protocol.DirectionUpload {
+		check.bitrate("rcvd", rpt.AvgRcvdBps())
+	}
+	if rpt.TCP != nil && rpt.TCP.SegmentsOut > 0 {
+		check.loss(rpt.TCP.RetransmitRate() * 100)
+	}
+	check.latency(rpt.Connect)
+
+	return check
+}
+
+// nagios runs a single test and prints a monitoring plugin status line with perfdata, returning
+// the plugin exit code. A test that fails to run is UNKNOWN, as nothing was measured.
+func nagios(ctx context.Context, cli *client.ClientTCP, runOpts client.RunOpts) int {
+	// the status line is the plugin's only output
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	rpt, err := runScheduled(ctx, cli, runOpts, time.Now())
+	if err != nil {
+		fmt.Printf("GOFLO %s - test failed: %v\n", nagiosUnknown, err)
+		return int(nagiosUnknown)
+	}
+
+	check := evaluateNagios(rpt)
+
+	summary := strings.Join(check.problems, ", ")
+	if check.status == nagiosOK {
+		var rates []string
+		if rpt.BytesSent > 0 {
+			rates = append(rates, "sent "+utils.DisplayBitsPerTime(rpt.BytesSent, rpt.Duration))
+		}
+		if rpt.BytesRcvd > 0 {
+			rates = append(rates, "received "+utils.DisplayBitsPerTime(rpt.BytesRcvd, rpt.Duration))
+		}
+		summary = strings.Join(rates, ", ")
+	}
+
+	fmt.Printf("GOFLO %s - %s|%s\n", check.status, summary, strings.Join(check.perf, " "))
+	return int(check.status)
+}
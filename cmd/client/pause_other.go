@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import (
+	"context"
+
+	"github.com/goodieshq/goflo/internal/protocol/transfer"
+	"github.com/rs/zerolog/log"
+)
+
+// watchPauseSignal is unavailable without SIGUSR1, so the test runs without pauses
+func watchPauseSignal(ctx context.Context, pauser *transfer.Pauser) {
+	log.Warn().Msg("Pausing with a signal is not supported on this platform")
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/goodieshq/goflo/internal/protocol/transfer"
+	"github.com/rs/zerolog/log"
+)
+
+// watchPauseSignal toggles the pauser on each SIGUSR1 until the context is done
+func watchPauseSignal(ctx context.Context, pauser *transfer.Pauser) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	log.Info().Int("pid", os.Getpid()).Msg("Send SIGUSR1 to pause or resume the test")
+
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sig:
+				if pauser.Toggle() {
+					log.Info().Msg("Test paused")
+				} else {
+					log.Info().Msg("Test resumed")
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// fatalHook runs a function before log.Fatal exits, which skips deferred calls
+type fatalHook func()
+
+func (h fatalHook) Run(_ *zerolog.Event, level zerolog.Level, _ string) {
+	if level == zerolog.FatalLevel {
+		h()
+	}
+}
+
+// startProfiles starts the CPU profile requested with -cpuprofile and returns a function that stops
+// it and writes the heap profile requested with -memprofile. The function may be called more than
+// once, and is also called by log.Fatal, so the profiles are written however the client exits.
+func startProfiles() func() {
+	if *flagCPUProf == "" && *flagMemProf == "" {
+		return func() {}
+	}
+
+	var cpu *os.File
+	if *flagCPUProf != "" {
+		f, err := os.Create(*flagCPUProf)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create CPU profile")
+		}
+		err = pprof.StartCPUProfile(f)
+		if err != nil {
+			f.Close()
+			log.Fatal().Err(err).Msg("Failed to start CPU profile")
+		}
+		cpu = f
+	}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			if cpu != nil {
+				pprof.StopCPUProfile()
+				if err := cpu.Close(); err != nil {
+					log.Error().Err(err).Msg("Failed to write CPU profile")
+				}
+			}
+			if *flagMemProf != "" {
+				writeHeapProfile(*flagMemProf)
+			}
+		})
+	}
+	log.Logger = log.Logger.Hook(fatalHook(stop))
+	return stop
+}
+
+// writeHeapProfile writes the heap profile to the file, after a collection so it reflects live data
+func writeHeapProfile(path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create memory profile")
+		return
+	}
+	defer f.Close()
+
+	runtime.GC()
+	err = pprof.WriteHeapProfile(f)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to write memory profile")
+	}
+}
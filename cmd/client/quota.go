@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/client"
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/goodieshq/goflo/internal/report"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+var errNoProgress = errors.New("test transferred no data")
+
+// runQuota runs tests back to back until their measured bytes, in both directions, add up to the
+// quota, then logs the time it took. Where a test can take a byte target, it is stopped at the
+// remaining quota so the last one doesn't overshoot; other tests run their full duration, so the
+// total may exceed the quota by up to one test's worth of data.
+func runQuota(ctx context.Context, cli *client.ClientTCP, runOpts client.RunOpts, quota uint64, writer *report.Writer) error {
+	policy := client.RetryPolicy{
+		Retries: flagRetries,
+		Backoff: flagBackoff,
+	}
+	capped := quotaCapped(runOpts)
+
+	var total uint64
+	var measured time.Duration
+	var tests int
+	start := time.Now()
+	for total < quota {
+		if capped {
+			runOpts.Bytes = utils.Ptr(quota - total)
+		}
+
+		rpt, err := client.RunWithRetry(ctx, cli, runOpts, policy)
+		if err != nil {
+			return fmt.Errorf("failed test %d with %s of %s transferred: %w", tests+1, utils.DisplayBytes(total), utils.DisplayBytes(quota), err)
+		}
+		tests++
+
+		if writer != nil {
+			err = writer.Write(rpt)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to write report")
+			}
+		}
+
+		// the server applies a download's target to the bytes it sent, which this side measures
+		// a little apart from, so its count tells whether the quota was reached
+		moved := rpt.BytesSent + rpt.BytesRcvd
+		if capped && rpt.Remote != nil && runOpts.GetDirection() == protocol.DirectionDownload {
+			moved = rpt.Remote.BytesSent
+		}
+		if moved == 0 {
+			return fmt.Errorf("failed to make progress toward the quota: %w", errNoProgress)
+		}
+		total += moved
+		measured += rpt.Duration
+
+		log.Info().Int("test", tests).
+			Str("transferred", utils.DisplayBytes(total)).
+			Str("remaining", utils.DisplayBytes(quota-min(total, quota))).
+			Msg("Quota progress")
+	}
+
+	// the wall-clock rate includes connection setup, warmup and the gaps between tests, while the
+	// measured rate covers only the measured windows
+	elapsed := time.Since(start)
+	log.Info().Str("quota", utils.DisplayBytes(quota)).
+		Str("transferred", utils.DisplayBytes(total)).
+		Int("tests", tests).
+		Str("time_to_quota", utils.DisplayTime(elapsed)).
+		Str("avg_rate", utils.DisplayBitsPerTime(total, elapsed)).
+		Str("avg_measured", utils.DisplayBitsPerTime(total, measured)).
+		Msg("Quota reached")
+	return nil
+}
+
+// quotaCapped reports whether the tests can be stopped at the remaining quota with a byte target.
+// The sender applies it, the client when uploading and the server when downloading, so bidi tests
+// and the combinations the client rejects a byte target for run their full duration.
+func quotaCapped(runOpts client.RunOpts) bool {
+	switch {
+	case runOpts.GetDirection() == protocol.DirectionBidi:
+		return false
+	case runOpts.ExplicitEnd || runOpts.GetStreams() > 1:
+		return false
+	case runOpts.GetTransport() == packets.TransportUDP && runOpts.GetDirection() == protocol.DirectionDownload:
+		return false
+	default:
+		return true
+	}
+}
@@ -2,32 +2,113 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/goodieshq/goflo/internal/capture"
 	"github.com/goodieshq/goflo/internal/server"
+	"github.com/goodieshq/goflo/internal/tlsconf"
+	"github.com/goodieshq/goflo/internal/utils"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
-func init() {
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}).Level(zerolog.DebugLevel)
-}
+var (
+	flagLogLevel     = flag.String("log-level", "debug", "minimum log level (trace, debug, info, warn, error)")
+	flagSummaryLevel = flag.String("summary-level", "info", "log level of per-test completion summaries")
+	flagCoordinator  = flag.String("coordinator", "", "URL to POST live per-interval stats to as JSON (best-effort)")
+	flagMaxTests     = flag.Uint("max-tests", 2, "maximum concurrent tests (0 is unlimited, for load-testing the server)")
+	flagCPUs         = flag.String("cpus", "", "pin transfer loops to these CPUs, e.g. \"2,3\" or \"2-5\" (Linux only)")
+	flagProxyProto   = flag.Bool("proxy-protocol", false, "require a PROXY protocol v1/v2 header on each connection, as sent by a load balancer")
+	flagMaxBitrate   = flag.Uint64("max-bitrate", 0, "cap the combined send rate of all tests in bits per second, shared fairly between them (0 is unlimited)")
+	flagCapture      = flag.String("capture", "", "record every test's handshake packets to this file (decode with the client's decode-capture)")
+	flagAdmin        = flag.String("admin", "", "serve the unauthenticated session list/cancel API on this address, e.g. \"localhost:8080\" (empty disables)")
+	flagTLSCert      = flag.String("tls-cert", "", "PEM certificate to serve TLS with, required of every client (empty disables TLS)")
+	flagTLSKey       = flag.String("tls-key", "", "PEM private key for -tls-cert")
+	flagTLSClientCA  = flag.String("tls-client-ca", "", "PEM bundle of CAs client certificates must chain to, enabling mutual TLS")
+	flagShutGrace    = flag.Duration("shutdown-grace", 0, "on SIGINT or SIGTERM, let running tests finish their current report interval and log their summaries, waiting at most this long (0 stops at once)")
+)
 
 func main() {
+	flag.Parse()
+
+	logLevel, err := zerolog.ParseLevel(*flagLogLevel)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid log level")
+	}
+	summaryLevel, err := zerolog.ParseLevel(*flagSummaryLevel)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid summary level")
+	}
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}).Level(logLevel)
+
+	err = utils.CheckEntropy()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Entropy self-test failed")
+	}
+
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	var cpus []int
+	if *flagCPUs != "" {
+		cpus, err = utils.ParseCPUList(*flagCPUs)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid CPU list")
+		}
+	}
+
+	var handshakeCapture *capture.Writer
+	if *flagCapture != "" {
+		handshakeCapture, err = capture.Create(*flagCapture)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to start handshake capture")
+		}
+		defer handshakeCapture.Close()
+	}
+
+	var tlsConfig *tls.Config
+	if *flagTLSCert != "" || *flagTLSKey != "" || *flagTLSClientCA != "" {
+		tlsConfig, err = tlsconf.NewServerConfig(tlsconf.ServerOpts{
+			CertFile:     *flagTLSCert,
+			KeyFile:      *flagTLSKey,
+			ClientCAFile: *flagTLSClientCA,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid TLS configuration")
+		}
+	}
+
+	maxTests := uint32(*flagMaxTests)
+	if maxTests == 0 {
+		maxTests = server.Unlimited
+	}
+
 	port := uint16(1234)
 	srv := server.NewServerTCP(server.ServerOpts{
 		Host:               "",
 		Port:               port,
 		PSK:                []byte("Test1234"),
 		Timeout:            time.Second * 3,
-		MaxConcurrentTests: 2,
+		MaxConcurrentTests: maxTests,
+		StallTimeout:       5 * time.Second,
+		MaxHelloAge:        30 * time.Second,
+		ClockSkew:          5 * time.Second,
+		SummaryLevel:       &summaryLevel,
+		CoordinatorURL:     *flagCoordinator,
+		ProxyProtocol:      *flagProxyProto,
+		CPUs:               cpus,
+		MaxBitrate:         *flagMaxBitrate,
+		Capture:            handshakeCapture,
+		TLSConfig:          tlsConfig,
+		ShutdownGrace:      *flagShutGrace,
 	})
 
 	var wg sync.WaitGroup
@@ -37,12 +118,28 @@ func main() {
 		log.Info().Uint16("port", port).Msg("Starting GoFlo server")
 		err := srv.Run(ctx)
 		if err != nil {
-			panic(err)
-		} else {
-			log.Info().Msg("GoFlo server stopped")
+			log.Fatal().Err(err).Msg("GoFlo server failed")
 		}
+		log.Info().Msg("GoFlo server stopped")
 	}()
 
+	if *flagAdmin != "" {
+		admin := &http.Server{Addr: *flagAdmin, Handler: srv.AdminHandler()}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Info().Str("address", *flagAdmin).Msg("Starting admin API")
+			err := admin.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error().Err(err).Msg("Admin API failed")
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			_ = admin.Close()
+		}()
+	}
+
 	<-ctx.Done()
 	wg.Wait()
 }
@@ -0,0 +1,176 @@
+// Package capture records the packets an endpoint exchanges during a FLO handshake to a file, for
+// debugging interoperability between implementations.
+//
+// A capture file starts with the 7 byte signature "FLOCAP" followed by the format version (1),
+// then holds one record per packet, all integers little-endian like the protocol itself:
+//
+//	Offset | Size | Field
+//	-------+------+-------------------------------------------
+//	0      | 1    | Direction (1 sent, 2 received)
+//	1      | 8    | Time the packet was sent or received, as Unix nanoseconds
+//	9      | 4    | Length of the packet in bytes
+//	13     | n    | Packet bytes, exactly as on the wire
+//
+// Records are self-contained, so captures from several connections may share a file; each
+// packet's session ID tells them apart.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrInvalidCapture = errors.New("invalid capture file")
+
+const (
+	signature  = "FLOCAP"
+	version    = 1
+	recordSize = 1 + 8 + 4 // direction, time and length preceding each packet
+
+	// MaxPacketSize bounds the packets a reader accepts, far above any FLO packet, so a corrupt
+	// length can't cause a huge allocation
+	MaxPacketSize = 64 << 10
+)
+
+// Direction is whether a captured packet was sent or received by the capturing endpoint
+type Direction uint8
+
+const (
+	Sent     Direction = 1
+	Received Direction = 2
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Sent:
+		return "sent"
+	case Received:
+		return "rcvd"
+	default:
+		return "unknown"
+	}
+}
+
+// Record is a single captured packet
+type Record struct {
+	Direction Direction
+	Time      time.Time
+	Data      []byte
+}
+
+// Writer appends records to a capture. It is safe for concurrent use, so one writer may be shared
+// by every connection an endpoint handles.
+type Writer struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error // first write error, after which records are dropped
+}
+
+// NewWriter starts a capture on w by writing the file signature
+func NewWriter(w io.Writer) (*Writer, error) {
+	_, err := w.Write(append([]byte(signature), version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write capture signature: %w", err)
+	}
+	return &Writer{w: w}, nil
+}
+
+// Create starts a capture in a new file, truncating any existing one
+func Create(path string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+	c, err := NewWriter(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the underlying writer if it is closable, such as a file from Create
+func (c *Writer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Record appends a packet to the capture, timestamped now. Once a write has failed, further
+// records are dropped and the error is returned again.
+func (c *Writer) Record(dir Direction, data []byte) error {
+	buf := make([]byte, recordSize, recordSize+len(data))
+	buf[0] = byte(dir)
+	binary.LittleEndian.PutUint64(buf[1:9], uint64(time.Now().UnixNano()))
+	binary.LittleEndian.PutUint32(buf[9:13], uint32(len(data)))
+	buf = append(buf, data...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+
+	// a record is written in one call so a failure can't leave a partial one mid-file
+	_, err := c.w.Write(buf)
+	if err != nil {
+		c.err = fmt.Errorf("failed to write capture record: %w", err)
+	}
+	return c.err
+}
+
+// Reader reads the records of a capture
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader checks the capture's signature and version and returns a reader for its records
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	var head [len(signature) + 1]byte
+	_, err := io.ReadFull(br, head[:])
+	if err != nil || string(head[:len(signature)]) != signature {
+		return nil, fmt.Errorf("%w: missing signature", ErrInvalidCapture)
+	}
+	if head[len(signature)] != version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidCapture, head[len(signature)])
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF once the capture ends cleanly
+func (c *Reader) Next() (*Record, error) {
+	var head [recordSize]byte
+	_, err := io.ReadFull(c.r, head[:])
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: truncated record", ErrInvalidCapture)
+	}
+
+	size := binary.LittleEndian.Uint32(head[9:13])
+	if size > MaxPacketSize {
+		return nil, fmt.Errorf("%w: record of %d bytes exceeds %d", ErrInvalidCapture, size, MaxPacketSize)
+	}
+
+	rec := &Record{
+		Direction: Direction(head[0]),
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(head[1:9]))),
+		Data:      make([]byte, size),
+	}
+	_, err = io.ReadFull(c.r, rec.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: truncated record", ErrInvalidCapture)
+	}
+	return rec, nil
+}
@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/goodieshq/goflo/internal/protocol/transfer"
+	"github.com/goodieshq/goflo/internal/report"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	blackHoleFloor     = 256     // smallest chunk size tried, below any real path MTU
+	blackHolePrecision = 16      // stop narrowing once the bounds are this close
+	blackHoleRate      = 1000000 // TCP trial send rate in bits per second, low enough that writes aren't coalesced
+)
+
+// ErrPathDown is returned by ProbeBlackHole when even the smallest chunks don't get through, so
+// the path is failing for reasons other than packet size
+var ErrPathDown = errors.New("no chunk size got through")
+
+// BlackHoleResult holds the outcome of an MTU black hole search
+type BlackHoleResult struct {
+	Detected bool   // smaller chunks got through where the test's chunk size did not
+	Largest  uint32 // largest chunk size that got through
+	Smallest uint32 // smallest chunk size that did not, if Detected
+	Trials   int    // number of trial transfers run
+}
+
+// Delivered returns the measured bytes that reached the receiving end of a test: the server's
+// count for uploads when it reported one, otherwise the client's
+func Delivered(rpt *report.Report) uint64 {
+	if rpt.Direction == protocol.DirectionToString(protocol.DirectionDownload) {
+		return rpt.BytesRcvd
+	}
+	if rpt.Remote != nil {
+		return rpt.Remote.BytesRcvd
+	}
+	return rpt.BytesSent
+}
+
+// Stalled reports whether a completed test got none of its data through, which is how a path
+// silently dropping packets of the test's size shows up
+func Stalled(rpt *report.Report) bool {
+	return rpt != nil && Delivered(rpt) == 0
+}
+
+// trialBlackHole runs a short test at the given chunk size and reports whether its data got through
+func (c *ClientTCP) trialBlackHole(ctx context.Context, transport packets.FloTransport, direction protocol.FloDir, chunkSize uint32) bool {
+	runOpts := RunOpts{
+		Transport:     utils.Ptr(transport),
+		Direction:     utils.Ptr(direction),
+		Duration:      utils.Ptr(chunkProbeDuration),
+		Warmup:        utils.Ptr(chunkProbeWarmup),
+		ChunkSize:     utils.Ptr(chunkSize),
+		Sinks:         []transfer.StatsSink{quietSink{}},
+		NoChunkAdvice: true,
+	}
+	// TCP coalesces queued writes into full segments, so only a sender that never queues controls
+	// the segment size with its chunk size
+	if transport == packets.TransportTCP {
+		runOpts.Rate = utils.Ptr(uint64(blackHoleRate))
+	}
+
+	rpt, err := c.Run(ctx, runOpts)
+	passed := err == nil && !Stalled(rpt)
+	log.Debug().Err(err).Uint32("chunk_size", chunkSize).Bool("passed", passed).Msg("Black hole trial")
+	return passed
+}
+
+// ProbeBlackHole looks for a path MTU black hole, where packets above some size are dropped
+// without the ICMP error that would let the sender adapt, after a test at chunkSize stalled. It
+// retries with halving chunk sizes until one gets through, then narrows down the size where
+// packets start to vanish. Each UDP chunk is one datagram; TCP trials are slow uploads, so each
+// chunk up to the MSS leaves as its own segment. A black hole is only reported if the test's own
+// chunk size fails again while a smaller one succeeds.
+func (c *ClientTCP) ProbeBlackHole(ctx context.Context, transport packets.FloTransport, direction protocol.FloDir, chunkSize uint32) (*BlackHoleResult, error) {
+	if transport == packets.TransportTCP {
+		direction = protocol.DirectionUpload
+	}
+	result := &BlackHoleResult{}
+	trial := func(size uint32) bool {
+		result.Trials++
+		return c.trialBlackHole(ctx, transport, direction, size)
+	}
+
+	if trial(chunkSize) {
+		result.Largest = chunkSize
+		return result, nil
+	}
+
+	var lo uint32
+	hi := chunkSize
+	for size := chunkSize / 2; size >= blackHoleFloor; size /= 2 {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if trial(size) {
+			lo = size
+			break
+		}
+		hi = size
+	}
+	if lo == 0 {
+		return nil, fmt.Errorf("%w down to %d bytes, the path is failing regardless of packet size", ErrPathDown, hi)
+	}
+
+	for hi-lo > blackHolePrecision {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		mid := lo + (hi-lo)/2
+		if trial(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	result.Detected = true
+	result.Largest = lo
+	result.Smallest = hi
+	return result, nil
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"math/bits"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	adviceMinDuration   = 1 * time.Second // shorter tests are too noisy to advise on
+	adviceMaxOpsPerSec  = 100_000         // above this, per-chunk syscall overhead likely caps throughput
+	adviceMinOpsPerSec  = 100             // below this, each chunk occupies the connection for over 10ms
+	adviceLargeChunk    = 1 << 20         // chunk sizes from which a low operation rate is worth flagging
+	adviceTargetHigh    = 10_000          // operations per second aimed for when recommending a larger chunk
+	adviceTargetLow     = 1_000           // operations per second aimed for when recommending a smaller chunk
+	adviceSmallestLarge = 64 * 1024       // never recommend shrinking a large chunk below this
+)
+
+// adviseChunkSize logs a chunk size recommendation when the test suggests the chosen size held it
+// back, based on the write and read operations counted in the busier direction. A very high rate
+// means throughput was likely bound by per-operation CPU overhead rather than the network, while a
+// very low rate with an enormous chunk means each write held the connection for a long time
+// without the throughput needing it, adding latency to pacing and interval stats.
+func adviseChunkSize(chunkSize uint32, stats *protocol.Stats, duration time.Duration, limited bool) {
+	if duration < adviceMinDuration || chunkSize == 0 {
+		return
+	}
+
+	bytesPerSec := float64(max(stats.GetBytesSent(), stats.GetBytesRcvd())) / duration.Seconds()
+	opsPerSec := float64(max(stats.GetWrites(), stats.GetReads())) / duration.Seconds()
+
+	switch {
+	case opsPerSec > adviceMaxOpsPerSec:
+		recommended := clampChunkSize(bytesPerSec/adviceTargetHigh, uint32(packets.MinChunkSize))
+		if recommended <= chunkSize {
+			return
+		}
+		log.Info().
+			Str("chunk_size", utils.DisplayBytes(uint64(chunkSize))).
+			Str("recommended", utils.DisplayBytes(uint64(recommended))).
+			Int("ops_per_sec", int(opsPerSec)).
+			Msg("Throughput may be limited by per-chunk overhead, consider a larger chunk size")
+	case !limited && chunkSize >= adviceLargeChunk && opsPerSec < adviceMinOpsPerSec:
+		recommended := clampChunkSize(bytesPerSec/adviceTargetLow, adviceSmallestLarge)
+		if recommended >= chunkSize {
+			return
+		}
+		log.Warn().
+			Str("chunk_size", utils.DisplayBytes(uint64(chunkSize))).
+			Str("recommended", utils.DisplayBytes(uint64(recommended))).
+			Int("writes_per_sec", int(opsPerSec)).
+			Msg("Chunk size is larger than the throughput needs and adds latency, consider a smaller chunk size")
+	}
+}
+
+// clampChunkSize rounds size up to a power of two within [floor, packets.MaxChunkSize]
+func clampChunkSize(size float64, floor uint32) uint32 {
+	if size >= packets.MaxChunkSize {
+		return packets.MaxChunkSize
+	}
+	rounded := uint32(1) << bits.Len32(max(uint32(size), 1)-1)
+	if rounded > packets.MaxChunkSize {
+		return packets.MaxChunkSize
+	}
+	return max(rounded, floor)
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/transfer"
+	"github.com/goodieshq/goflo/internal/report"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	chunkProbeDuration  = 1 * time.Second        // length of each trial transfer (the shortest the server accepts)
+	chunkProbeWarmup    = 250 * time.Millisecond // slow-start allowance before each trial is measured
+	chunkProbePrecision = 1024                   // stop searching once the bounds are this close
+	chunkProbeTolerance = 0.5                    // a trial below this fraction of the best throughput counts as degraded
+)
+
+// ChunkProbeResult holds the outcome of a chunk size search
+type ChunkProbeResult struct {
+	ChunkSize uint32  // largest chunk size that transferred without degrading
+	Bps       float64 // upload throughput measured at that chunk size
+	Trials    int     // number of trial transfers run
+}
+
+// quietSink discards interval stats so trial transfers don't flood the log
+type quietSink struct{}
+
+func (quietSink) Interval(protocol.StatsDiff) {}
+func (quietSink) Final(*report.Report)        {}
+
+// trialChunkSize runs a short upload at the given chunk size and returns its throughput
+func (c *ClientTCP) trialChunkSize(ctx context.Context, chunkSize uint32) (float64, error) {
+	rpt, err := c.Run(ctx, RunOpts{
+		Direction:     utils.Ptr(protocol.DirectionUpload),
+		Duration:      utils.Ptr(chunkProbeDuration),
+		Warmup:        utils.Ptr(chunkProbeWarmup),
+		ChunkSize:     utils.Ptr(chunkSize),
+		Sinks:         []transfer.StatsSink{quietSink{}},
+		NoChunkAdvice: true, // the probe makes its own recommendation
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rpt.AvgSentBps(), nil
+}
+
+// ProbeChunkSize binary-searches [minSize, maxSize] for the largest upload chunk size that still
+// transfers well, running a short test at each candidate. A candidate fails if its test errors or
+// its throughput falls below half of the best seen, which is how a path that fragments or stalls
+// on large writes shows up. This is a diagnostic: each trial is a full handshake and transfer.
+func (c *ClientTCP) ProbeChunkSize(ctx context.Context, minSize, maxSize uint32) (*ChunkProbeResult, error) {
+	if minSize == 0 || minSize > maxSize {
+		return nil, fmt.Errorf("invalid chunk size range %d-%d", minSize, maxSize)
+	}
+
+	// the smallest size establishes the baseline and must succeed for the search to mean anything
+	best, err := c.trialChunkSize(ctx, minSize)
+	if err != nil {
+		return nil, fmt.Errorf("baseline trial at %d bytes failed: %w", minSize, err)
+	}
+	result := &ChunkProbeResult{ChunkSize: minSize, Bps: best, Trials: 1}
+	log.Debug().Uint32("chunk_size", minSize).Str("rate", utils.DisplayBitsPerTime(uint64(best/8), time.Second)).Msg("Chunk size trial")
+
+	lo, hi := minSize, maxSize
+	for hi-lo > chunkProbePrecision {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		mid := lo + (hi-lo)/2
+		bps, err := c.trialChunkSize(ctx, mid)
+		result.Trials++
+
+		if err != nil || bps < best*chunkProbeTolerance {
+			log.Debug().Err(err).Uint32("chunk_size", mid).Str("rate", utils.DisplayBitsPerTime(uint64(bps/8), time.Second)).Msg("Chunk size trial degraded")
+			hi = mid
+			continue
+		}
+
+		log.Debug().Uint32("chunk_size", mid).Str("rate", utils.DisplayBitsPerTime(uint64(bps/8), time.Second)).Msg("Chunk size trial")
+		lo = mid
+		if bps > best {
+			best = bps
+		}
+		result.ChunkSize = mid
+		result.Bps = bps
+	}
+
+	return result, nil
+}
@@ -1,9 +1,15 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/goodieshq/goflo/internal/protocol/transfer"
+	"github.com/goodieshq/goflo/internal/report"
 	"github.com/goodieshq/goflo/internal/utils"
 )
 
@@ -12,19 +18,113 @@ const (
 	DEFAULT_WARMUP     = 1 * time.Second
 	DEFAULT_CHUNK_SIZE = 1024
 	DEFAULT_DIRECTION  = protocol.DirectionBidi
+	DEFAULT_PRIME      = 0
+	DEFAULT_BYTES      = 0 // no byte target
+	DEFAULT_RATE       = 0 // unlimited
+	DEFAULT_TRANSPORT  = packets.TransportTCP
+	DEFAULT_STREAMS    = 1
 )
 
+// AddressFamily restricts which IP family the client uses to reach the server
+type AddressFamily uint8
+
+const (
+	FamilyAny  AddressFamily = 0 // use whichever family the dialer picks (Happy Eyeballs)
+	FamilyIPv4 AddressFamily = 4
+	FamilyIPv6 AddressFamily = 6
+)
+
+var ErrNoAddressInFamily = errors.New("host has no address in the requested family")
+
+// ParseFamily parses "4", "6" or "any" (or an empty string) into an address family
+func ParseFamily(s string) (AddressFamily, error) {
+	switch s {
+	case "", "any":
+		return FamilyAny, nil
+	case "4":
+		return FamilyIPv4, nil
+	case "6":
+		return FamilyIPv6, nil
+	default:
+		return FamilyAny, fmt.Errorf("invalid address family %q (expected 4, 6 or any)", s)
+	}
+}
+
+// ErrServerBusy is returned by Run when the server has no free test slots
+var ErrServerBusy = errors.New("server is busy")
+
+// BusyError describes a busy rejection using the slot counts the server advertised
+type BusyError struct {
+	SlotsTotal uint32 // concurrent tests the server allows
+	SlotsFree  uint32 // slots free when the server replied (non-zero if one was released meanwhile)
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("%s: %d of %d test slots free", ErrServerBusy, e.SlotsFree, e.SlotsTotal)
+}
+
+func (e *BusyError) Unwrap() error {
+	return ErrServerBusy
+}
+
 type RunOpts struct {
 	Direction *protocol.FloDir
 	Duration  *time.Duration
 	Warmup    *time.Duration
 	ChunkSize *uint32
+	Prime     *uint64 // bytes to transfer before measuring, replacing the timed warmup
+	Bytes     *uint64 // stop after this many measured bytes (upload or download, duration becomes a limit)
+	Rate      *uint64 // cap each sending side's rate in bits per second, the server's through the hello
+	ChunkMin  *uint32 // if non-zero, each write is a random size from this up to ChunkSize (client sending only)
+	Streams   *uint   // parallel TCP connections sharing the test's session, each paced to Rate (1 if nil)
+
+	// Transport carries the data phase, TCP by default. UDP runs upload or download tests over
+	// datagrams on a port the server opens for the test, with the handshake connection kept open as
+	// the control channel; it can't tunnel through a proxy or use the in-band features below.
+	Transport *packets.FloTransport
+
+	// ExplicitEnd completes the test with End/EndAck packets instead of a TCP half-close, for paths
+	// whose middleboxes mishandle half-closed connections. It cannot be combined with Bytes, as the
+	// server relies on the half-close to detect the end of a fixed-byte upload.
+	ExplicitEnd bool
+
+	// Pauser, if set, lets the test be paused and resumed through it. Each pause and resume is sent
+	// to the server so it pauses too, and paused time is excluded from the measured duration.
+	Pauser *transfer.Pauser
+
+	// local options which are not sent to the server
+	WriteMode     transfer.WriteMode   // how chunks are written during the data phase
+	Sinks         []transfer.StatsSink // receive interval stats and the final report (console logging if empty)
+	NoChunkAdvice bool                 // skip the chunk size recommendation logged after the test
+	AdaptWarmup   bool                 // begin measuring once throughput stabilizes, with Warmup as a cap
+	Label         string               // free-form annotation carried into the summary and report
+	CPUs          []int                // pin the transfer loops to these CPUs (Linux only, unpinned if empty)
+	ShutdownGrace time.Duration        // on cancellation, finish the current report interval, for at most this long
+	StreamIdle    time.Duration        // over parallel streams, drop a stream that moves no data for this long (0 disables)
+	Pings         uint                 // round trips to time on the control connection before the transfer (none if zero)
+
+	// Until, if set, ends the test at this wall-clock time instead of after Duration, so tests
+	// started by several clients stop together. The measured duration is what remains after the
+	// timed warmup when the test starts, which assumes the hosts' clocks are synchronized.
+	Until time.Time
 }
 
 func (r RunOpts) GetDuration() time.Duration {
 	return utils.DefaultIfNil(r.Duration, DEFAULT_DURATION)
 }
 
+// durationUntil returns the measured duration that ends a test starting now at Until
+func (r RunOpts) durationUntil() (time.Duration, error) {
+	if !r.Until.After(time.Now()) {
+		return 0, fmt.Errorf("%w: end time %s has passed", protocol.ErrInvalidDuration, r.Until.Format(time.RFC3339))
+	}
+	remaining := time.Until(r.Until) - r.GetWarmup()
+	if remaining <= 0 {
+		return 0, fmt.Errorf("%w: end time %s leaves no time after the warmup", protocol.ErrInvalidDuration, r.Until.Format(time.RFC3339))
+	}
+	return remaining.Truncate(time.Millisecond), nil
+}
+
 func (r RunOpts) GetWarmup() time.Duration {
 	return utils.DefaultIfNil(r.Warmup, DEFAULT_WARMUP)
 }
@@ -37,6 +137,42 @@ func (r RunOpts) GetDirection() protocol.FloDir {
 	return utils.DefaultIfNil(r.Direction, DEFAULT_DIRECTION)
 }
 
+func (r RunOpts) GetPrime() uint64 {
+	return utils.DefaultIfNil(r.Prime, DEFAULT_PRIME)
+}
+
+func (r RunOpts) GetBytes() uint64 {
+	return utils.DefaultIfNil(r.Bytes, DEFAULT_BYTES)
+}
+
+func (r RunOpts) GetRate() uint64 {
+	return utils.DefaultIfNil(r.Rate, DEFAULT_RATE)
+}
+
+func (r RunOpts) GetTransport() packets.FloTransport {
+	return utils.DefaultIfNil(r.Transport, DEFAULT_TRANSPORT)
+}
+
+func (r RunOpts) GetStreams() uint {
+	return utils.DefaultIfNil(r.Streams, DEFAULT_STREAMS)
+}
+
+// Validate checks the options against the bounds the server enforces on a Hello, so unreasonable
+// values are rejected before connecting rather than wrapping when converted to milliseconds
+func (r RunOpts) GetChunkMin() uint32 {
+	return utils.DefaultIfNil(r.ChunkMin, 0)
+}
+
+func (r RunOpts) Validate() error {
+	if r.GetDuration() < 0 {
+		return fmt.Errorf("%w: negative duration %s", protocol.ErrInvalidDuration, r.GetDuration())
+	}
+	if r.GetWarmup() < 0 {
+		return fmt.Errorf("%w: negative warmup %s", protocol.ErrInvalidWarmup, r.GetWarmup())
+	}
+	return NewTestConfig(r).Validate()
+}
+
 type Client interface {
-	Run(opts RunOpts) error
+	Run(ctx context.Context, opts RunOpts) (*report.Report, error)
 }
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/goodieshq/goflo/internal/utils"
+)
+
+// TestConfig is the fully resolved set of test parameters sent in the Hello, saved to reproduce a test exactly
+type TestConfig struct {
+	Direction  string `json:"direction"`
+	ChunkSize  uint32 `json:"chunk_size"`
+	DurationMS uint64 `json:"duration_ms"`
+	WarmupMS   uint64 `json:"warmup_ms"`
+	PrimeBytes uint64 `json:"prime_bytes"`
+	Bytes      uint64 `json:"bytes,omitempty"`
+	RateBps    uint64 `json:"rate_bps,omitempty"`
+	ChunkMin   uint32 `json:"chunk_min,omitempty"`
+	Transport  string `json:"transport,omitempty"` // tcp if empty, as in configurations saved before UDP support
+	Streams    uint   `json:"streams,omitempty"`   // one if zero, as in configurations saved before parallel streams
+}
+
+// NewTestConfig resolves the run options, applying defaults for any unset values
+func NewTestConfig(opts RunOpts) TestConfig {
+	return TestConfig{
+		Direction:  protocol.DirectionToString(opts.GetDirection()),
+		ChunkSize:  opts.GetChunkSize(),
+		DurationMS: uint64(opts.GetDuration().Milliseconds()),
+		WarmupMS:   uint64(opts.GetWarmup().Milliseconds()),
+		PrimeBytes: opts.GetPrime(),
+		Bytes:      opts.GetBytes(),
+		RateBps:    opts.GetRate(),
+		ChunkMin:   opts.GetChunkMin(),
+		Transport:  packets.TransportToString(opts.GetTransport()),
+		Streams:    opts.GetStreams(),
+	}
+}
+
+// Validate checks the configuration against the bounds the server enforces on a Hello
+func (t TestConfig) Validate() error {
+	direction, err := protocol.ParseDirection(t.Direction)
+	if err != nil {
+		return err
+	}
+	transport, err := t.transport()
+	if err != nil {
+		return err
+	}
+	if err := packets.ValidateTransportDirection(transport, direction); err != nil {
+		return fmt.Errorf("%w: %s over %s", err, t.Direction, packets.TransportToString(transport))
+	}
+	if t.ChunkSize < packets.MinChunkSize || t.ChunkSize > packets.MaxChunkSize {
+		return fmt.Errorf("%w: %d", protocol.ErrInvalidChunkSize, t.ChunkSize)
+	}
+	if err := packets.ValidateTransportChunkSize(transport, t.ChunkSize); err != nil {
+		return err
+	}
+	if t.ChunkMin != 0 && (t.ChunkMin < packets.MinChunkSize || t.ChunkMin > t.ChunkSize) {
+		return fmt.Errorf("%w: minimum %d is outside %d-%d", protocol.ErrInvalidChunkSize, t.ChunkMin, packets.MinChunkSize, t.ChunkSize)
+	}
+	if t.streams() > packets.MaxStreams {
+		return fmt.Errorf("%w: %d exceeds %d", protocol.ErrInvalidStreams, t.streams(), packets.MaxStreams)
+	}
+	if err := packets.ValidateStreams(transport, uint8(t.streams()), 0); err != nil {
+		return err
+	}
+	return packets.ValidateTiming(t.DurationMS, t.WarmupMS)
+}
+
+// RunOpts converts the configuration back into run options
+func (t TestConfig) RunOpts() (RunOpts, error) {
+	if err := t.Validate(); err != nil {
+		return RunOpts{}, err
+	}
+
+	direction, _ := protocol.ParseDirection(t.Direction)
+	transport, _ := t.transport()
+	return RunOpts{
+		Direction: utils.Ptr(direction),
+		Duration:  utils.Ptr(time.Duration(t.DurationMS) * time.Millisecond),
+		Warmup:    utils.Ptr(time.Duration(t.WarmupMS) * time.Millisecond),
+		ChunkSize: utils.Ptr(t.ChunkSize),
+		Prime:     utils.Ptr(t.PrimeBytes),
+		Bytes:     utils.Ptr(t.Bytes),
+		Rate:      utils.Ptr(t.RateBps),
+		ChunkMin:  utils.Ptr(t.ChunkMin),
+		Transport: utils.Ptr(transport),
+		Streams:   utils.Ptr(t.streams()),
+	}, nil
+}
+
+// transport parses the configuration's transport, which defaults to TCP
+func (t TestConfig) transport() (packets.FloTransport, error) {
+	if t.Transport == "" {
+		return DEFAULT_TRANSPORT, nil
+	}
+	return packets.ParseTransport(t.Transport)
+}
+
+// streams returns the configuration's stream count, which defaults to one
+func (t TestConfig) streams() uint {
+	return max(t.Streams, DEFAULT_STREAMS)
+}
+
+// SaveTestConfig writes the configuration to a JSON file
+func SaveTestConfig(path string, cfg TestConfig) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal test config: %w", err)
+	}
+
+	err = os.WriteFile(path, append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write test config: %w", err)
+	}
+
+	return nil
+}
+
+// LoadTestConfig reads and validates a configuration previously written by SaveTestConfig
+func LoadTestConfig(path string) (TestConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return TestConfig{}, fmt.Errorf("failed to read test config: %w", err)
+	}
+
+	var cfg TestConfig
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return TestConfig{}, fmt.Errorf("failed to parse test config: %w", err)
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return TestConfig{}, fmt.Errorf("invalid test config: %w", err)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package client
+
+// fdLimit returns a conservative descriptor budget where the limit cannot be queried
+func fdLimit() int {
+	return defaultFDLimit
+}
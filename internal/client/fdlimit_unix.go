@@ -0,0 +1,14 @@
+//go:build unix
+
+package client
+
+import "syscall"
+
+// fdLimit returns the soft limit on open file descriptors for this process
+func fdLimit() int {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return defaultFDLimit
+	}
+	return int(min(rlim.Cur, 1<<20))
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol/transfer"
+)
+
+// loadTick is how often the load generator decides whether to start more tests
+const loadTick = 10 * time.Millisecond
+
+// defaultFDLimit is assumed when the file descriptor limit cannot be determined
+const defaultFDLimit = 1024
+
+// loadReservedFDs leaves file descriptors free for the process itself when deriving the in-flight cap
+const loadReservedFDs = 32
+
+// LoadOpts configures a load generation run against a single server
+type LoadOpts struct {
+	Rate        float64       // tests started per second once ramped up
+	Duration    time.Duration // how long to keep starting tests
+	Ramp        time.Duration // time taken to ramp linearly from zero to Rate (0 starts at full rate)
+	MaxInFlight int           // concurrent tests allowed (0 derives it from the file descriptor limit)
+	Test        RunOpts       // options for each individual test
+}
+
+// LoadResult summarizes a load generation run from the client's perspective
+type LoadResult struct {
+	Started   int             // tests started
+	Succeeded int             // tests the server accepted and completed
+	Busy      int             // tests rejected because the server had no free slots
+	Failed    int             // tests that failed for any other reason
+	Skipped   int             // tests not started because MaxInFlight were already running
+	Latencies []time.Duration // connect plus handshake time of each accepted test, sorted
+}
+
+// RejectionRate returns the fraction of started tests the server turned away as busy
+func (r *LoadResult) RejectionRate() float64 {
+	if r.Started == 0 {
+		return 0
+	}
+	return float64(r.Busy) / float64(r.Started)
+}
+
+// Percentile returns the latency below which the given fraction (0-1) of accepted tests fall
+func (r *LoadResult) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	idx := int(p * float64(len(r.Latencies)-1))
+	return r.Latencies[max(0, min(idx, len(r.Latencies)-1))]
+}
+
+// Load starts many short, independent tests against the server at a steadily ramping rate to
+// stress its accept, authentication and slot handling. Unlike parallel streams, every test is a
+// separate connection and handshake.
+func (c *ClientTCP) Load(ctx context.Context, opts LoadOpts) (*LoadResult, error) {
+	if opts.Rate <= 0 {
+		return nil, fmt.Errorf("invalid load rate: %v tests per second", opts.Rate)
+	}
+	if err := opts.Test.Validate(); err != nil {
+		return nil, err
+	}
+
+	maxInFlight := opts.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = fdLimit() - loadReservedFDs
+		if maxInFlight <= 0 {
+			return nil, fmt.Errorf("file descriptor limit too low for load generation")
+		}
+	}
+
+	// per-test throughput is not interesting here, so keep it out of the log
+	test := opts.Test
+	test.Sinks = []transfer.StatsSink{quietSink{}}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		result   LoadResult
+		inFlight int
+	)
+
+	tick := time.NewTicker(loadTick)
+	defer tick.Stop()
+
+	start := time.Now()
+	last := start
+	var credit float64
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case now := <-tick.C:
+			elapsed := now.Sub(start)
+			if elapsed >= opts.Duration {
+				break loop
+			}
+
+			rate := opts.Rate
+			if opts.Ramp > 0 && elapsed < opts.Ramp {
+				rate *= elapsed.Seconds() / opts.Ramp.Seconds()
+			}
+			credit += rate * now.Sub(last).Seconds()
+			last = now
+
+			for ; credit >= 1; credit-- {
+				mu.Lock()
+				if inFlight >= maxInFlight {
+					result.Skipped++
+					mu.Unlock()
+					continue
+				}
+				inFlight++
+				result.Started++
+				mu.Unlock()
+
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rpt, err := c.Run(ctx, test)
+
+					mu.Lock()
+					defer mu.Unlock()
+					inFlight--
+					switch {
+					case err == nil:
+						result.Succeeded++
+						result.Latencies = append(result.Latencies, rpt.Connect+rpt.Handshake)
+					case errors.Is(err, ErrServerBusy):
+						result.Busy++
+					default:
+						result.Failed++
+					}
+				}()
+			}
+		}
+	}
+
+	wg.Wait()
+	slices.Sort(result.Latencies)
+	return &result, nil
+}
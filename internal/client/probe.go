@@ -0,0 +1,131 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol/handshake"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// ProbeResult holds the latency measurements from probing a single server
+type ProbeResult struct {
+	Address string        // server host:port
+	Connect time.Duration // time spent establishing the TCP connection
+	RTT     time.Duration // time between sending the Hello and receiving the server's first response
+	Err     error         // non-nil if the server could not be probed
+}
+
+// Probe connects to the server and measures the connection time and handshake round trip without running a test
+func (c *ClientTCP) Probe(ctx context.Context) ProbeResult {
+	result := ProbeResult{Address: c.Address()}
+
+	t := time.Now()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to connect to server: %w", err)
+		return result
+	}
+	defer conn.Close()
+	result.Connect = time.Since(t)
+
+	// a server the test couldn't secure is as unusable as one that can't be reached, and its
+	// hello must be sent the way the test's would
+	conn, err = c.startTLS(ctx, conn)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	sessionId, err := utils.NewULID()
+	if err != nil {
+		result.Err = fmt.Errorf("failed to generate session ID: %w", err)
+		return result
+	}
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	pktHello, err := c.newHelloV1(DEFAULT_TRANSPORT, sessionId, DEFAULT_DIRECTION, DEFAULT_CHUNK_SIZE, DEFAULT_DURATION, DEFAULT_WARMUP, DEFAULT_PRIME, DEFAULT_BYTES, DEFAULT_RATE, 0)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	stream := handshake.NewConnStream(conn, r, w)
+
+	t = time.Now()
+	_, err = handshake.Send(stream, c.timeout, pktHello)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to send hello packet: %w", err)
+		return result
+	}
+
+	// any response (challenge or ack) completes the round trip
+	_, _, err = handshake.RecvHeader(stream, c.timeout)
+	if err != nil {
+		result.Err = fmt.Errorf("failed to receive packet header: %w", err)
+		return result
+	}
+	result.RTT = time.Since(t)
+
+	return result
+}
+
+// SelectFastest probes each client and returns the reachable one with the lowest handshake round trip
+func SelectFastest(ctx context.Context, clients []*ClientTCP) (*ClientTCP, []ProbeResult, error) {
+	results := make([]ProbeResult, 0, len(clients))
+	var best *ClientTCP
+	var bestResult ProbeResult
+
+	for _, cli := range clients {
+		if ctx.Err() != nil {
+			return nil, results, ctx.Err()
+		}
+
+		result := cli.Probe(ctx)
+		results = append(results, result)
+		if result.Err != nil {
+			log.Warn().Err(result.Err).Str("server", result.Address).Msg("Skipping unreachable server")
+			continue
+		}
+
+		log.Info().
+			Str("server", result.Address).
+			Str("connect", utils.DisplayTime(result.Connect)).
+			Str("rtt", utils.DisplayTime(result.RTT)).
+			Msg("Probed server")
+
+		if best == nil || result.RTT < bestResult.RTT {
+			best = cli
+			bestResult = result
+		}
+	}
+
+	if best == nil {
+		return nil, results, errors.New("no reachable servers")
+	}
+
+	log.Info().
+		Str("server", bestResult.Address).
+		Str("rtt", utils.DisplayTime(bestResult.RTT)).
+		Int("reachable", countReachable(results)).
+		Int("probed", len(results)).
+		Msg("Selected server with the lowest handshake round trip")
+
+	return best, results, nil
+}
+
+func countReachable(results []ProbeResult) int {
+	n := 0
+	for _, result := range results {
+		if result.Err == nil {
+			n++
+		}
+	}
+	return n
+}
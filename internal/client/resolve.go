@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// Route describes the local address and interface the host would use to reach a server address
+type Route struct {
+	Remote    net.IP // resolved server address
+	Local     net.IP // local source address chosen by the routing table
+	Interface string // name of the interface owning the local address (empty if unknown)
+}
+
+// ResolveRoutes resolves the server host and reports the local address and interface chosen to
+// reach each of its addresses in the client's address family. Connecting a UDP socket makes the kernel pick a route without
+// sending any packets, so no test traffic is generated.
+func (c *ClientTCP) ResolveRoutes(ctx context.Context) ([]Route, error) {
+	addrs, err := c.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make([]Route, 0, len(addrs))
+	for _, addr := range addrs {
+		conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: addr.IP, Port: int(c.port), Zone: addr.Zone})
+		if err != nil {
+			return nil, fmt.Errorf("no route to %s: %w", addr.IP, err)
+		}
+		local := conn.LocalAddr().(*net.UDPAddr).IP
+		conn.Close()
+
+		routes = append(routes, Route{
+			Remote:    addr.IP,
+			Local:     local,
+			Interface: interfaceForIP(local),
+		})
+	}
+
+	return routes, nil
+}
+
+// interfaceForIP returns the name of the interface that owns the given address
+func interfaceForIP(ip net.IP) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface.Name
+			}
+		}
+	}
+
+	return ""
+}
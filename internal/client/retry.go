@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/report"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	DEFAULT_RETRIES       = 3
+	DEFAULT_RETRY_BACKOFF = 1 * time.Second
+	maxRetryBackoff       = 30 * time.Second
+
+	DEFAULT_CONNECT_RETRIES = 0 // fail on the first refused connection
+	DEFAULT_CONNECT_BACKOFF = 100 * time.Millisecond
+	maxConnectBackoff       = 1 * time.Second
+)
+
+// RetryPolicy controls how RunWithRetry retries transient failures. Backoff doubles after each
+// failed attempt, up to 30 seconds.
+type RetryPolicy struct {
+	Retries *int           // retries after the first attempt
+	Backoff *time.Duration // wait before the first retry
+}
+
+// ConnectRetry controls how a test retries its initial connection while the server refuses it,
+// e.g. because it is still starting. Backoff doubles after each refusal, up to one second. Unlike
+// RetryPolicy, only refused connections are retried: an unreachable network or unresolvable host
+// won't come good by the time a starting server is listening.
+type ConnectRetry struct {
+	Retries *int           // retries after the first refused connection
+	Backoff *time.Duration // wait before the first retry
+}
+
+// IsConnRefused reports whether a dial error means nothing was listening on the server port
+func IsConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// IsTransient reports whether a Run error is likely to clear up on its own, such as a timeout,
+// refused or reset connection, temporary DNS failure, or busy server. Everything else (bad
+// credentials, rejected parameters, invalid options) would fail the same way again.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, ErrServerBusy) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+
+	for _, errno := range []syscall.Errno{
+		syscall.ECONNREFUSED,
+		syscall.ECONNRESET,
+		syscall.ECONNABORTED,
+		syscall.EHOSTUNREACH,
+		syscall.ENETUNREACH,
+		syscall.ENETDOWN,
+		syscall.EPIPE,
+	} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// RunWithRetry runs a test, retrying transient failures with exponential backoff until the
+// retries are exhausted or the context ends. Permanent errors are returned immediately.
+func RunWithRetry(ctx context.Context, cli Client, opts RunOpts, policy RetryPolicy) (*report.Report, error) {
+	retries := utils.DefaultIfNil(policy.Retries, DEFAULT_RETRIES)
+	backoff := utils.DefaultIfNil(policy.Backoff, DEFAULT_RETRY_BACKOFF)
+
+	for attempt := 0; ; attempt++ {
+		rpt, err := cli.Run(ctx, opts)
+		if err == nil || attempt >= retries || !IsTransient(err) {
+			return rpt, err
+		}
+
+		// a slot freed up between the server's rejection and its reply, so don't wait for it
+		var busy *BusyError
+		if errors.As(err, &busy) && busy.SlotsFree > 0 {
+			log.Warn().Err(err).Int("attempt", attempt+1).Msg("Server busy but a slot was freed, retrying now")
+			continue
+		}
+
+		log.Warn().Err(err).Int("attempt", attempt+1).Str("backoff", backoff.String()).Msg("Transient test failure, retrying")
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
@@ -0,0 +1,327 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/transfer"
+	"github.com/goodieshq/goflo/internal/report"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/oklog/ulid/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// intervalSum is one reporting interval summed over the streams that reported it so far
+type intervalSum struct {
+	diff     protocol.StatsDiff
+	reported []bool // by stream
+}
+
+// streamSum adds up the intervals of a test's parallel streams, passing each to the test's sinks
+// once every running stream has reported it. The streams start measuring at nearly the same time
+// and report on the same interval, so their nth intervals cover nearly the same window.
+type streamSum struct {
+	mu       sync.Mutex
+	sinks    []transfer.StatsSink
+	interval time.Duration // the streams' report interval
+	done     []bool        // by stream, whether it completed and reports no more intervals
+	pending  []intervalSum // intervals not yet passed to the sinks, oldest first
+	emitted  int           // intervals passed to the sinks
+}
+
+func newStreamSum(sinks []transfer.StatsSink, interval time.Duration, streams int) *streamSum {
+	return &streamSum{sinks: sinks, interval: interval, done: make([]bool, streams)}
+}
+
+// slot returns which interval a stream's diff belongs to from how long the stream has measured by
+// its end. A stream whose last tick raced the end of its transfer reports the remainder as a
+// sliver of an interval, which is added to the interval before it.
+func (s *streamSum) slot(elapsed time.Duration) int {
+	return max(0, int((elapsed-s.interval/2)/s.interval))
+}
+
+// add sums a stream's diff into the nth interval
+func (s *streamSum) add(stream, n int, diff protocol.StatsDiff) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a stream ending early may fall behind the others, whose intervals pass without it
+	if n < s.emitted {
+		return
+	}
+	for len(s.pending) <= n-s.emitted {
+		s.pending = append(s.pending, intervalSum{reported: make([]bool, len(s.done))})
+	}
+	sum := &s.pending[n-s.emitted]
+	sum.diff.BytesSent += diff.BytesSent
+	sum.diff.BytesRcvd += diff.BytesRcvd
+	sum.diff.OverheadSent += diff.OverheadSent
+	sum.diff.OverheadRcvd += diff.OverheadRcvd
+	sum.diff.Duration = max(sum.diff.Duration, diff.Duration)
+	sum.reported[stream] = true
+	s.emit()
+}
+
+// finish stops waiting for a stream's intervals once it completes
+func (s *streamSum) finish(stream int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.done[stream] = true
+	s.emit()
+}
+
+// complete reports whether every stream still running has reported the interval
+func (s *streamSum) complete(sum *intervalSum) bool {
+	for i, reported := range sum.reported {
+		if !reported && !s.done[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// emit passes on the intervals every running stream has reported
+func (s *streamSum) emit() {
+	for len(s.pending) > 0 && s.complete(&s.pending[0]) {
+		for _, sink := range s.sinks {
+			sink.Interval(s.pending[0].diff)
+		}
+		s.pending = s.pending[1:]
+		s.emitted++
+	}
+}
+
+// final hands the test's combined report to the sinks
+func (s *streamSum) final(rpt *report.Report) {
+	for _, sink := range s.sinks {
+		sink.Final(rpt)
+	}
+}
+
+// streamSink feeds one stream's intervals into the test's sum, leaving the summary to runStreams.
+// With an idle limit, it calls drop once the stream has moved no data for that long.
+type streamSink struct {
+	sum     *streamSum
+	stream  int
+	elapsed time.Duration // measured so far
+	idle    time.Duration // how long the stream may move no data (0 disables)
+	idleFor time.Duration // how long it has moved none
+	drop    func()
+}
+
+func (s *streamSink) Interval(diff protocol.StatsDiff) {
+	s.elapsed += diff.Duration
+	s.sum.add(s.stream, s.sum.slot(s.elapsed), diff)
+
+	if s.idle <= 0 {
+		return
+	}
+	if diff.BytesSent+diff.BytesRcvd > 0 {
+		s.idleFor = 0
+		return
+	}
+	s.idleFor += diff.Duration
+	if s.idleFor >= s.idle {
+		s.drop()
+	}
+}
+
+func (s *streamSink) Final(rpt *report.Report) {}
+
+// runStreams performs a test over parallel connections sharing its session ID, each transferring
+// on its own as a single-stream test would. Their intervals are reported summed, and a failed
+// stream ends the others, failing the test. With RunOpts.StreamIdle, a stream that stalls is
+// dropped instead, and the test finishes on the others.
+func (c *ClientTCP) runStreams(ctx context.Context, runOpts RunOpts, psk []byte, keyNum int, sessionId ulid.ULID, streams uint8) (*report.Report, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	intervals := &transfer.IntervalRecorder{}
+	sinks := append([]transfer.StatsSink{intervals}, sinksOrConsole(runOpts.Sinks)...)
+	sum := newStreamSum(sinks, transfer.DefaultInterval(runOpts.GetDuration()), int(streams))
+	reports := make([]*report.Report, streams)
+	errs := make([]error, streams)
+	dropped := make([]atomic.Bool, streams)
+
+	var wg sync.WaitGroup
+	for i := range streams {
+		streamCtx, cancelStream := context.WithCancel(ctx)
+		drop := func() {
+			if dropped[i].CompareAndSwap(false, true) {
+				log.Warn().Str("session_id", sessionId.String()).Uint8("stream", i).
+					Str("idle", utils.DisplayTime(runOpts.StreamIdle)).
+					Msg("Dropping stalled stream, the test continues on the others")
+				cancelStream()
+			}
+		}
+
+		streamOpts := runOpts
+		streamOpts.Sinks = []transfer.StatsSink{&streamSink{sum: sum, stream: int(i), idle: runOpts.StreamIdle, drop: drop}}
+		streamOpts.NoChunkAdvice = true
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sum.finish(int(i))
+			defer cancelStream()
+
+			reports[i], errs[i] = c.runStream(streamCtx, streamOpts, psk, keyNum, sessionId, streams, i)
+			if errs[i] != nil && !dropped[i].Load() {
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	var droppedStreams []int
+	for i, err := range errs {
+		if dropped[i].Load() {
+			droppedStreams = append(droppedStreams, i)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("stream %d failed: %w", i, err)
+		}
+	}
+	if len(droppedStreams) == len(reports) {
+		return nil, fmt.Errorf("every stream stalled for %s", utils.DisplayTime(runOpts.StreamIdle))
+	}
+
+	// a stream dropped before it reported keeps its place among the streams with an empty report
+	for _, i := range droppedStreams {
+		if reports[i] == nil {
+			reports[i] = &report.Report{
+				SessionID: sessionId.String(),
+				Label:     runOpts.Label,
+				Server:    c.Address(),
+				Direction: protocol.DirectionToString(runOpts.GetDirection()),
+				ChunkSize: runOpts.GetChunkSize(),
+			}
+		}
+	}
+
+	rpt := sumReports(reports, droppedStreams)
+	rpt.Intervals = intervals.Intervals()
+	logStreamsSummary(rpt)
+	sum.final(rpt)
+	return rpt, nil
+}
+
+// sumReports combines the reports of a test's streams. Byte and operation counts are summed, and
+// times are the longest of any stream, so the combined bitrate is the bytes of all streams over
+// the longest measured duration. The dropped streams are listed but left out of the totals.
+func sumReports(reports []*report.Report, dropped []int) *report.Report {
+	kept := make([]*report.Report, 0, len(reports))
+	for i, r := range reports {
+		if !slices.Contains(dropped, i) {
+			kept = append(kept, r)
+		}
+	}
+
+	first := kept[0]
+	rpt := &report.Report{
+		SessionID: first.SessionID,
+		Label:     first.Label,
+		Server:    first.Server,
+		Direction: first.Direction,
+		ChunkSize: first.ChunkSize,
+		ChunkMin:  first.ChunkMin,
+		Start:     first.Start,
+		RTT:       first.RTT,
+		Ping:      reports[0].Ping, // timed by the first stream only
+		Streams:   reports,
+		Dropped:   dropped,
+	}
+
+	remote := &report.RemoteResult{}
+	for _, r := range kept {
+		if r.Start.Before(rpt.Start) {
+			rpt.Start = r.Start
+		}
+		rpt.Connect = max(rpt.Connect, r.Connect)
+		rpt.Handshake = max(rpt.Handshake, r.Handshake)
+		rpt.Warmup = max(rpt.Warmup, r.Warmup)
+		rpt.Duration = max(rpt.Duration, r.Duration)
+		rpt.BytesSent += r.BytesSent
+		rpt.BytesRcvd += r.BytesRcvd
+		rpt.BytesTail += r.BytesTail
+		rpt.Writes += r.Writes
+		rpt.Reads += r.Reads
+		rpt.Overhead += r.Overhead
+		rpt.FlushFailed = rpt.FlushFailed || r.FlushFailed
+
+		if r.TCP != nil {
+			if rpt.TCP == nil {
+				rpt.TCP = &report.TCPStats{}
+			}
+			rpt.TCP.SegmentsOut += r.TCP.SegmentsOut
+			rpt.TCP.Retransmits += r.TCP.Retransmits
+		}
+
+		// the server's view is only complete if it reported on every stream
+		if remote != nil && r.Remote != nil {
+			remote.BytesSent += r.Remote.BytesSent
+			remote.BytesRcvd += r.Remote.BytesRcvd
+			remote.Duration = max(remote.Duration, r.Remote.Duration)
+			remote.Warmup = max(remote.Warmup, r.Remote.Warmup)
+		} else {
+			remote = nil
+		}
+	}
+	rpt.Remote = remote
+	return rpt
+}
+
+// logStreamsSummary logs the totals of a test over parallel streams alongside each stream's
+func logStreamsSummary(rpt *report.Report) {
+	evt := log.Info().Str("session_id", rpt.SessionID)
+	if rpt.Label != "" {
+		evt = evt.Str("label", rpt.Label)
+	}
+	evt = evt.Int("streams", len(rpt.Streams)).
+		Str("duration", utils.DisplayTime(rpt.Duration))
+	if len(rpt.Dropped) > 0 {
+		evt = evt.Ints("dropped_streams", rpt.Dropped).
+			Str("note", "totals exclude the dropped streams")
+	}
+
+	streamTotals := func(bytes func(r *report.Report) uint64) (totals, avgs []string) {
+		for _, r := range rpt.Streams {
+			totals = append(totals, utils.DisplayBytes(bytes(r)))
+			avgs = append(avgs, utils.DisplayBitsPerTime(bytes(r), r.Duration))
+		}
+		return totals, avgs
+	}
+	if rpt.BytesSent > 0 {
+		totals, avgs := streamTotals(func(r *report.Report) uint64 { return r.BytesSent })
+		evt = evt.Str("total_sent", utils.DisplayBytes(rpt.BytesSent)).
+			Str("avg_sent", utils.DisplayBitsPerTime(rpt.BytesSent, rpt.Duration)).
+			Strs("stream_total_sent", totals).
+			Strs("stream_avg_sent", avgs)
+	}
+	if rpt.BytesRcvd > 0 {
+		totals, avgs := streamTotals(func(r *report.Report) uint64 { return r.BytesRcvd })
+		evt = evt.Str("total_rcvd", utils.DisplayBytes(rpt.BytesRcvd)).
+			Str("avg_rcvd", utils.DisplayBitsPerTime(rpt.BytesRcvd, rpt.Duration)).
+			Strs("stream_total_rcvd", totals).
+			Strs("stream_avg_rcvd", avgs)
+	}
+	if rpt.BytesTail > 0 {
+		evt = evt.Str("tail_rcvd", utils.DisplayBytes(rpt.BytesTail))
+	}
+	if rpt.TCP != nil && rpt.TCP.SegmentsOut > 0 {
+		evt = evt.Uint64("retransmits", rpt.TCP.Retransmits).
+			Str("retrans_rate", utils.DisplayPercent(rpt.TCP.RetransmitRate()))
+	}
+	if rpt.Remote != nil {
+		evt = evt.Str("server_sent", utils.DisplayBytes(rpt.Remote.BytesSent)).
+			Str("server_rcvd", utils.DisplayBytes(rpt.Remote.BytesRcvd))
+	}
+	evt.Msg("Parallel streams complete")
+}
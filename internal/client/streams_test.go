@@ -0,0 +1,55 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/report"
+)
+
+// A stream is dropped once its intervals have moved no data for the idle limit in a row, and any
+// data moved starts the count over.
+func TestStreamSinkIdle(t *testing.T) {
+	const interval = 250 * time.Millisecond
+	sum := newStreamSum(nil, interval, 1)
+	drops := 0
+	sink := &streamSink{sum: sum, idle: time.Second, drop: func() { drops++ }}
+
+	moved := protocol.StatsDiff{BytesRcvd: 1, Duration: interval}
+	idle := protocol.StatsDiff{Duration: interval}
+	for i, diff := range []protocol.StatsDiff{moved, idle, idle, idle, moved, idle, idle, idle} {
+		sink.Interval(diff)
+		if drops != 0 {
+			t.Fatalf("dropped after interval %d, before a second passed without data", i)
+		}
+	}
+	sink.Interval(idle)
+	if drops != 1 {
+		t.Errorf("dropped %d times after a second without data, want once", drops)
+	}
+}
+
+// The dropped streams stay listed in the combined report but are left out of its totals.
+func TestSumReportsDropped(t *testing.T) {
+	reports := []*report.Report{
+		{SessionID: "a", Duration: time.Second, BytesSent: 100, Remote: &report.RemoteResult{BytesRcvd: 100}},
+		{SessionID: "a", Duration: 3 * time.Second, BytesSent: 7},
+		{SessionID: "a", Duration: 2 * time.Second, BytesSent: 50, Remote: &report.RemoteResult{BytesRcvd: 50}},
+	}
+
+	rpt := sumReports(reports, []int{1})
+	if rpt.BytesSent != 150 {
+		t.Errorf("summed %d bytes sent, want 150 from the streams kept", rpt.BytesSent)
+	}
+	if rpt.Duration != 2*time.Second {
+		t.Errorf("duration %s, want the longest of the streams kept", rpt.Duration)
+	}
+	// the dropped stream reported nothing from the server, which doesn't void the others' result
+	if rpt.Remote == nil || rpt.Remote.BytesRcvd != 150 {
+		t.Errorf("remote result %+v, want 150 bytes received", rpt.Remote)
+	}
+	if len(rpt.Streams) != 3 || len(rpt.Dropped) != 1 || rpt.Dropped[0] != 1 {
+		t.Errorf("streams %d and dropped %v, want all 3 listed and stream 1 dropped", len(rpt.Streams), rpt.Dropped)
+	}
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+)
+
+// ParseTarget parses a server given as host:port or as a tcp://host:port or udp://host:port URL,
+// whose scheme selects the transport of the test's data phase. A bare host:port leaves the
+// transport to the caller and returns zero for it. IPv6 literals are bracketed as in a URL, e.g.
+// [::1]:1234. unix:// targets are recognized but rejected, as tests run over the network.
+func ParseTarget(target string) (packets.FloTransport, string, uint16, error) {
+	var transport packets.FloTransport
+	hostport := target
+
+	if scheme, rest, ok := strings.Cut(target, "://"); ok {
+		switch strings.ToLower(scheme) {
+		case "tcp":
+			transport = packets.TransportTCP
+		case "udp":
+			transport = packets.TransportUDP
+		case "unix":
+			return 0, "", 0, fmt.Errorf("invalid target %q: %w: unix sockets", target, protocol.ErrUnsupportedTransport)
+		default:
+			return 0, "", 0, fmt.Errorf("invalid target %q: scheme must be tcp, udp or unix", target)
+		}
+
+		u, err := url.Parse("//" + rest)
+		if err != nil {
+			return 0, "", 0, fmt.Errorf("invalid target %q: %w", target, err)
+		}
+		if u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+			return 0, "", 0, fmt.Errorf("invalid target %q: expected %s://host:port", target, scheme)
+		}
+		hostport = u.Host
+	}
+
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	if host == "" {
+		return 0, "", 0, fmt.Errorf("invalid target %q: missing host", target)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return 0, "", 0, fmt.Errorf("invalid target %q: port must be 1-65535", target)
+	}
+	return transport, host, uint16(port), nil
+}
@@ -3,13 +3,20 @@ package client
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"math"
 	"net"
+	"net/url"
 	"time"
 
+	"github.com/goodieshq/goflo/internal/capture"
 	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/handshake"
 	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
 	"github.com/goodieshq/goflo/internal/protocol/transfer"
+	"github.com/goodieshq/goflo/internal/report"
 	"github.com/goodieshq/goflo/internal/utils"
 	"github.com/oklog/ulid/v2"
 	"github.com/rs/zerolog/log"
@@ -21,6 +28,12 @@ type ClientTCP struct {
 	psk         []byte
 	authEnabled bool
 	timeout     time.Duration
+	family      AddressFamily
+	connRetry   ConnectRetry
+	capture     *capture.Writer
+	altPSKs     [][]byte // tried in order when the server rejects psk
+	proxy       *url.URL // HTTP CONNECT or SOCKS5 proxy to tunnel through (direct if nil)
+	tlsConfig   *tls.Config
 }
 
 func NewClientTCP(
@@ -28,6 +41,8 @@ func NewClientTCP(
 	port uint16,
 	psk []byte,
 	timeout *time.Duration,
+	family AddressFamily,
+	tlsConfig *tls.Config,
 ) *ClientTCP {
 	t := utils.DefaultIfNil(timeout, 3*time.Second)
 	return &ClientTCP{
@@ -36,199 +51,439 @@ func NewClientTCP(
 		psk:         psk,
 		authEnabled: len(psk) > 0,
 		timeout:     t,
+		family:      family,
+		tlsConfig:   tlsConfig,
 	}
 }
 
-// recvHeader reads and unmarshals a packet header from the connection
-func (c *ClientTCP) recvHeader(conn net.Conn, r *bufio.Reader) (*protocol.Header, []byte, error) {
-	conn.SetReadDeadline(time.Now().Add(c.timeout))
-
-	bufHeader, err := utils.ReadExact(r, protocol.HeaderSize)
+// recvResultV1 locates and unmarshals the Result packet trailing the data phase. Data bytes still
+// in flight when the transfer stopped precede it, so the stream is scanned for the Result's header
+// and session ID; the number of trailing data bytes skipped is returned alongside the packet.
+func (c *ClientTCP) recvResultV1(stream *handshake.ConnStream, sessionId ulid.ULID, size int) (*packets.PktResult, uint64, error) {
+	bufResult, discarded, err := handshake.Scan(stream, c.timeout, packets.ResultMarker(sessionId), size)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read packet header: %w", err)
+		return nil, discarded, fmt.Errorf("failed to read result packet: %w", err)
 	}
 
-	header, err := protocol.UnmarshalHeader(bufHeader)
+	pktResult, err := packets.UnmarshalResult(bufResult)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to unmarshal packet header: %w", err)
+		return nil, discarded, fmt.Errorf("failed to unmarshal result packet: %w", err)
 	}
+	return pktResult, discarded, nil
+}
 
-	return header, bufHeader, nil
+// newHelloV1 creates the Hello packet for a test
+func (c *ClientTCP) newHelloV1(transport packets.FloTransport, sessionId ulid.ULID, direction protocol.FloDir, chunkSize uint32, duration, warmup time.Duration, prime, bytesTarget, rate uint64, flags packets.FloFlags) (*packets.PktHello, error) {
+	pktHello, err := packets.NewHello(
+		transport,
+		sessionId,
+		c.security(),
+		direction,
+		chunkSize,
+		duration,
+		warmup,
+		prime,
+		bytesTarget,
+		rate,
+		flags,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hello packet: %w", err)
+	}
+	return pktHello, nil
 }
 
-// recvChallengeV1 reads and unmarshals a Challenge packet from the server
-func (c *ClientTCP) recvChallengeV1(conn net.Conn, r *bufio.Reader, bufHeader []byte) (*packets.PktChallenge, []byte, error) {
-	conn.SetReadDeadline(time.Now().Add(c.timeout))
+// Address returns the host:port of the server this client targets
+func (c *ClientTCP) Address() string {
+	return net.JoinHostPort(c.host, fmt.Sprintf("%d", c.port))
+}
 
-	bufChallenge, err := utils.ReadExact(r, packets.PktChallengeSize-protocol.HeaderSize)
+// lookup resolves the server host, keeping only addresses in the client's address family
+func (c *ClientTCP) lookup(ctx context.Context) ([]net.IPAddr, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, c.host)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read challenge packet: %w", err)
+		return nil, fmt.Errorf("failed to resolve server host: %w", err)
+	}
+	if c.family == FamilyAny {
+		return addrs, nil
 	}
-	bufChallenge = append(bufHeader, bufChallenge...)
 
-	pktChallenge, err := packets.UnmarshalChallenge(bufChallenge)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to unmarshal challenge packet: %w", err)
+	filtered := addrs[:0]
+	for _, addr := range addrs {
+		if (addr.IP.To4() != nil) == (c.family == FamilyIPv4) {
+			filtered = append(filtered, addr)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("%w: %s has no IPv%d address", ErrNoAddressInFamily, c.host, c.family)
 	}
+	return filtered, nil
+}
 
-	return pktChallenge, bufChallenge, nil
+// dial establishes the TCP connection to the server, over the client's address family if one is set
+func (c *ClientTCP) dial(ctx context.Context) (net.Conn, error) {
+	network := "tcp"
+	switch c.family {
+	case FamilyIPv4:
+		network = "tcp4"
+	case FamilyIPv6:
+		network = "tcp6"
+	}
+
+	// check up front so a missing family is reported clearly rather than as a dial error
+	address := c.Address()
+	if c.family != FamilyAny {
+		addrs, err := c.lookup(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// the proxy resolves names itself, so pass it an address in the family instead
+		if c.proxy != nil {
+			address = net.JoinHostPort(addrs[0].String(), fmt.Sprintf("%d", c.port))
+		}
+	}
+
+	dialer := net.Dialer{Timeout: c.timeout}
+	if c.proxy != nil {
+		return dialProxy(ctx, &dialer, c.proxy, address)
+	}
+	return dialer.DialContext(ctx, network, address)
 }
 
-// recvAckV1 reads and unmarshals an Ack packet from the server
-func (c *ClientTCP) recvAckV1(conn net.Conn, r *bufio.Reader, bufHeader []byte) (*packets.PktAck, []byte, error) {
-	conn.SetReadDeadline(time.Now().Add(c.timeout))
+// MaxPSKs bounds the keys a client tries, as each rejected attempt costs a connection and a
+// failed authentication in the server's logs
+const MaxPSKs = 4
 
-	bufAck, err := utils.ReadExact(r, packets.PktAckSize-protocol.HeaderSize)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read ack packet: %w", err)
+// SetAlternatePSKs sets keys to try in order when the server rejects the client's key, e.g. while a
+// pool of servers is part way through a key rotation. Together with the primary key at most
+// MaxPSKs are allowed.
+func (c *ClientTCP) SetAlternatePSKs(psks [][]byte) error {
+	if 1+len(psks) > MaxPSKs {
+		return fmt.Errorf("too many pre-shared keys: %d (at most %d)", 1+len(psks), MaxPSKs)
 	}
-	bufAck = append(bufHeader, bufAck...)
+	c.altPSKs = psks
+	return nil
+}
 
-	pktAck, err := packets.UnmarshalAck(bufAck)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to unmarshal ack packet: %w", err)
+// SetCapture records the packets of each test's handshake to the capture (none if nil)
+func (c *ClientTCP) SetCapture(w *capture.Writer) {
+	c.capture = w
+}
+
+// SetConnectRetry sets how tests retry their initial connection while the server refuses it
+func (c *ClientTCP) SetConnectRetry(retry ConnectRetry) {
+	c.connRetry = retry
+}
+
+// connect dials the server, retrying refused connections per the client's ConnectRetry. It returns
+// the time taken by the successful attempt, so waiting for the server to start isn't counted as
+// connection latency.
+func (c *ClientTCP) connect(ctx context.Context) (net.Conn, time.Duration, error) {
+	retries := utils.DefaultIfNil(c.connRetry.Retries, DEFAULT_CONNECT_RETRIES)
+	backoff := utils.DefaultIfNil(c.connRetry.Backoff, DEFAULT_CONNECT_BACKOFF)
+
+	for attempt := 0; ; attempt++ {
+		t := time.Now()
+		conn, err := c.dial(ctx)
+		if err == nil {
+			return conn, time.Since(t), nil
+		}
+		if attempt >= retries || !IsConnRefused(err) {
+			return nil, 0, err
+		}
+
+		log.Debug().Err(err).Int("attempt", attempt+1).Str("backoff", backoff.String()).Msg("Connection refused, retrying")
+
+		select {
+		case <-ctx.Done():
+			return nil, 0, err
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, maxConnectBackoff)
 	}
+}
 
-	return pktAck, bufAck, nil
+// limitedBy reports whether the rate cap or the network limited a paced transfer. The cap is
+// considered the limiter when writes were delayed for a meaningful share of the transfer.
+func limitedBy(limiter *transfer.Limiter, duration time.Duration) string {
+	if duration > 0 && limiter.Waited() >= duration/10 {
+		return "rate cap"
+	}
+	return "network"
 }
 
-// sendHelloV1 sends a Hello packet to the server and returns the raw bytes sent
-func (c *ClientTCP) sendHelloV1(conn net.Conn, w *bufio.Writer, sessionId ulid.ULID, direction protocol.FloDir, chunkSize uint32, duration, warmup time.Duration) (*packets.PktHello, []byte, error) {
-	conn.SetWriteDeadline(time.Now().Add(c.timeout))
+// reconcileTolerance is the relative bitrate difference between the two ends worth warning about
+const reconcileTolerance = 0.10
 
-	// Send Hello packet to server
-	pktHello, err := packets.NewHello(
-		packets.TransportTCP,
-		sessionId,
-		packets.SecurityNone,
-		direction,
-		chunkSize,
-		duration,
-		warmup,
-	)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create hello packet: %w", err)
+// reconcile compares each direction's bitrate as measured by the client and by the server. The two
+// ends measure over windows offset by the link latency, so small differences are expected; a large
+// one points at clock trouble or bytes lost between the measurement points.
+func reconcile(stats *protocol.Stats, duration time.Duration, pktResult *packets.PktResult) {
+	serverDuration := time.Duration(pktResult.DurationNS)
+
+	compare := func(name string, local uint64, localDuration time.Duration, remote uint64) {
+		if local == 0 && remote == 0 {
+			return
+		}
+		localBps := float64(local) * 8 / localDuration.Seconds()
+		remoteBps := float64(remote) * 8 / serverDuration.Seconds()
+
+		evt := log.Info()
+		if diff := math.Abs(localBps-remoteBps) / max(localBps, remoteBps); diff > reconcileTolerance {
+			evt = log.Warn()
+		}
+		evt.Str("direction", name).
+			Str("client", utils.DisplayBitsPerTime(local, localDuration)).
+			Str("server", utils.DisplayBitsPerTime(remote, serverDuration)).
+			Str("client_duration", utils.DisplayTime(localDuration)).
+			Str("server_duration", utils.DisplayTime(serverDuration)).
+			Msg("Bitrate reconciliation")
 	}
 
-	bufHello, err := packets.SendPacket(w, pktHello)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to send hello packet: %w", err)
+	if duration <= 0 || serverDuration <= 0 {
+		return
+	}
+	compare("upload", stats.GetBytesSent(), duration, pktResult.BytesReceived)
+	compare("download", stats.GetBytesRcvd(), duration, pktResult.BytesSent)
+}
+
+// reconcileWindow compares when and for how long each end measured. The server may start counting
+// at a different point, e.g. after its fixed warmup while the client's adaptive one ended early,
+// and bitrates over different windows of a ramping transfer won't agree. A shift of either edge
+// by more than the tolerance (relative to the client's duration) is warned about.
+func reconcileWindow(warmup, duration time.Duration, pktResult *packets.PktResult) {
+	serverWarmup := time.Duration(pktResult.WarmupNS)
+	serverDuration := time.Duration(pktResult.DurationNS)
+	if duration <= 0 || serverDuration <= 0 {
+		return
+	}
+
+	limit := time.Duration(float64(duration) * reconcileTolerance)
+	startShift := (serverWarmup - warmup).Abs()
+	endShift := (serverWarmup + serverDuration - warmup - duration).Abs()
+
+	evt := log.Debug()
+	if startShift > limit || endShift > limit {
+		evt = log.Warn()
+	}
+	evt.Str("client_warmup", utils.DisplayTime(warmup)).
+		Str("server_warmup", utils.DisplayTime(serverWarmup)).
+		Str("client_duration", utils.DisplayTime(duration)).
+		Str("server_duration", utils.DisplayTime(serverDuration)).
+		Msg("Measurement window reconciliation")
+}
+
+// sinksOrConsole returns the sinks, or console logging if there are none
+func sinksOrConsole(sinks []transfer.StatsSink) []transfer.StatsSink {
+	if len(sinks) == 0 {
+		return []transfer.StatsSink{transfer.ConsoleSink{}}
+	}
+	return sinks
+}
+
+// Run performs a test. If the server rejects the pre-shared key and alternates are set, the test is
+// retried on a new connection with each alternate in turn.
+func (c *ClientTCP) Run(ctx context.Context, runOpts RunOpts) (*report.Report, error) {
+	if len(c.altPSKs) == 0 {
+		return c.run(ctx, runOpts, c.psk, 0)
 	}
-	log.Debug().Msg("Hello packet sent")
 
-	return pktHello, bufHello, nil
+	keys := append([][]byte{c.psk}, c.altPSKs...)
+	for i := 0; ; i++ {
+		rpt, err := c.run(ctx, runOpts, keys[i], i+1)
+		if !errors.Is(err, protocol.ErrAuthFailed) || i == len(keys)-1 || ctx.Err() != nil {
+			return rpt, err
+		}
+		log.Warn().Int("psk", i+1).Msg("Server rejected the pre-shared key, trying the next")
+	}
 }
 
-// sendAnswerV1 sends an Answer packet to the server in response to a Challenge
-func (c *ClientTCP) sendAnswerV1(conn net.Conn, w *bufio.Writer, sessionId ulid.ULID, hash [32]byte) (*packets.PktAnswer, []byte, error) {
-	conn.SetWriteDeadline(time.Now().Add(c.timeout))
+// run performs a single test authenticating with psk. A non-zero keyNum is the key's position
+// among the client's keys, reported in the summary.
+func (c *ClientTCP) run(ctx context.Context, runOpts RunOpts, psk []byte, keyNum int) (*report.Report, error) {
+	// an end time is resolved when the test starts, so a retry only measures what remains
+	if !runOpts.Until.IsZero() {
+		duration, err := runOpts.durationUntil()
+		if err != nil {
+			return nil, err
+		}
+		runOpts.Duration = &duration
+	}
 
-	pktAnswer, err := packets.NewAnswer(sessionId, hash)
+	err := runOpts.Validate()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create answer packet: %w", err)
+		return nil, err
 	}
 
-	bufAnswer, err := packets.SendPacket(w, pktAnswer)
+	// byte targets are applied by the sender, the client when uploading and the server when downloading
+	if runOpts.GetBytes() > 0 && runOpts.GetDirection() == protocol.DirectionBidi {
+		return nil, fmt.Errorf("byte target requires the upload or download direction")
+	}
+	if runOpts.GetChunkMin() > 0 && runOpts.GetDirection() == protocol.DirectionDownload {
+		return nil, fmt.Errorf("random chunk sizes require the client to send (upload or bidi)")
+	}
+	if runOpts.GetBytes() > 0 && runOpts.ExplicitEnd {
+		return nil, fmt.Errorf("byte target requires the half-close completion")
+	}
+	if runOpts.Pings > packets.MaxPings {
+		return nil, fmt.Errorf("%w: %d pings exceeds %d", protocol.ErrInvalidPing, runOpts.Pings, packets.MaxPings)
+	}
+	if runOpts.AdaptWarmup && runOpts.GetPrime() > 0 {
+		return nil, fmt.Errorf("adaptive warmup cannot be combined with priming")
+	}
+	if runOpts.GetTransport() == packets.TransportUDP {
+		if c.proxy != nil {
+			return nil, fmt.Errorf("UDP tests cannot be tunneled through a proxy")
+		}
+		if runOpts.ExplicitEnd || runOpts.Pauser != nil {
+			return nil, fmt.Errorf("explicit completion and pausing require the TCP transport")
+		}
+		if c.tlsConfig != nil {
+			return nil, fmt.Errorf("UDP tests cannot be secured with TLS")
+		}
+		// datagrams carry no end of data, so the client can't tell when the server reached it
+		if runOpts.GetBytes() > 0 && runOpts.GetDirection() == protocol.DirectionDownload {
+			return nil, fmt.Errorf("UDP downloads cannot use a byte target")
+		}
+	}
+
+	streams := runOpts.GetStreams()
+	if streams > 1 && (runOpts.GetBytes() > 0 || runOpts.Pauser != nil) {
+		return nil, fmt.Errorf("parallel streams cannot be combined with a byte target or pausing")
+	}
+
+	// generate a ULID for this session, shared by all of its streams
+	sessionId, err := utils.NewULID()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to send answer packet: %w", err)
+		return nil, fmt.Errorf("failed to generate session ID: %w", err)
 	}
 
-	log.Debug().Msg("Answer packet sent")
-	return pktAnswer, bufAnswer, nil
+	if streams > 1 {
+		return c.runStreams(ctx, runOpts, psk, keyNum, sessionId, uint8(streams))
+	}
+	return c.runStream(ctx, runOpts, psk, keyNum, sessionId, 1, 0)
 }
 
-// RunOpts defines options for running the client
-func (c *ClientTCP) Run(ctx context.Context, runOpts RunOpts) error {
-	address := net.JoinHostPort(c.host, fmt.Sprintf("%d", c.port))
-	conn, err := net.Dial("tcp", address)
+// runStream performs a test, or one connection of a test over parallel streams
+func (c *ClientTCP) runStream(ctx context.Context, runOpts RunOpts, psk []byte, keyNum int, sessionId ulid.ULID, streams, index uint8) (*report.Report, error) {
+	conn, durationConnect, err := c.connect(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to connect to server: %w", err)
+		return nil, fmt.Errorf("failed to connect to server: %w", err)
 	}
 	defer conn.Close()
 
-	// generate a ULID for this session
-	sessionId, err := utils.NewULID()
+	// the TLS handshake counts toward the handshake time, and the buffered reader/writer and all
+	// that follows run over the secured connection
+	tHandshake := time.Now()
+	conn, err = c.startTLS(ctx, conn)
 	if err != nil {
-		return fmt.Errorf("failed to generate session ID: %w", err)
+		return nil, err
 	}
 
 	// set up buffered reader and writer
 	r := bufio.NewReader(conn)
 	w := bufio.NewWriter(conn)
 
-	// send hello packet to server
-	pktHello, bufHello, err := c.sendHelloV1(
-		conn,
-		w,
+	flags := packets.FlagResult | packets.FlagResultDuration | packets.FlagResultWindow
+	if runOpts.ExplicitEnd {
+		flags |= packets.FlagExplicitEnd
+	}
+	if runOpts.Pauser != nil {
+		flags |= packets.FlagPause
+	}
+	if len(psk) == 0 {
+		flags |= packets.FlagNoAuth
+	} else {
+		flags |= packets.FlagAnswerLength
+	}
+	// the streams share a path, so only the first times its round trips
+	pings := runOpts.Pings > 0 && index == 0
+	if pings {
+		flags |= packets.FlagPing
+	}
+
+	// create the hello packet for this test
+	pktHello, err := c.newHelloV1(
+		runOpts.GetTransport(),
 		sessionId,
 		runOpts.GetDirection(),
 		runOpts.GetChunkSize(),
 		runOpts.GetDuration(),
 		runOpts.GetWarmup(),
+		runOpts.GetPrime(),
+		runOpts.GetBytes(),
+		runOpts.GetRate(),
+		flags,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to send hello packet: %w", err)
+		return nil, err
 	}
-
-	// read the response header from the server
-	pktHeader, bufHeader, err := c.recvHeader(conn, r)
+	err = pktHello.SetStream(streams, index)
 	if err != nil {
-		return fmt.Errorf("failed to receive packet header: %w", err)
+		return nil, fmt.Errorf("failed to create hello packet: %w", err)
 	}
 
-	// Handle server response based on packet type
-	var pktAck *packets.PktAck
-
-	switch pktHeader.Type {
-	case packets.TypeChallenge:
-		// receive Challenge packet from server
-		pktChallenge, _, err := c.recvChallengeV1(conn, r, bufHeader)
-		if err != nil {
-			return fmt.Errorf("failed to receive challenge packet: %w", err)
-		}
-
-		// commpute auth hash from challenge and psk
-		hash := packets.ComputeAuthHash(bufHello, pktChallenge.NonceServer, c.psk)
-
-		// send Answer packet to server
-		_, _, err = c.sendAnswerV1(conn, w, sessionId, hash)
-		if err != nil {
-			return fmt.Errorf("failed to send answer packet: %w", err)
-		}
-
-		// receive Ack packet from server
-		pktHeader, bufHeader, err := c.recvHeader(conn, r)
-		if err != nil {
-			return fmt.Errorf("failed to receive packet header: %w", err)
-		}
-
-		if pktHeader.Type != packets.TypeAck {
-			return fmt.Errorf("expected Ack packet, got type: %d", pktHeader.Type)
-		}
-
-		pktAck, _, err = c.recvAckV1(conn, r, bufHeader)
-		if err != nil {
-			return fmt.Errorf("failed to receive ack packet: %w", err)
-		}
-
-	case packets.TypeAck:
-		pktAck, _, err = c.recvAckV1(conn, r, bufHeader)
-		if err != nil {
-			return fmt.Errorf("failed to receive ack packet: %w", err)
-		}
-	default:
-		return fmt.Errorf("unexpected packet type: %d", pktHeader.Type)
+	// perform the handshake (authenticating if the server requires it)
+	stream := handshake.NewConnStream(conn, r, w)
+	stream.SetCapture(c.capture)
+	pktAck, rtt, err := handshake.Client(stream, pktHello, handshake.ClientConfig{
+		PSK:     psk,
+		Timeout: c.timeout,
+	})
+	if err != nil {
+		return nil, err
 	}
+	durationHandshake := time.Since(tHandshake)
 
 	switch pktAck.Code {
 	case packets.AckAuthFailed:
-		return fmt.Errorf("authentication failed: incorrect preshared key")
+		return nil, fmt.Errorf("%w: incorrect preshared key", protocol.ErrAuthFailed)
+	case packets.AckAuthRequired:
+		return nil, fmt.Errorf("%w: the server requires a pre-shared key but none is configured", protocol.ErrAuthRequired)
+	case packets.AckTLSRequired:
+		return nil, fmt.Errorf("server rejected hello: %w (no TLS configuration given)", protocol.ErrTLSRequired)
 	case packets.AckBusy:
-		return fmt.Errorf("server is busy: max concurrent tests reached")
+		return nil, &BusyError{SlotsTotal: pktAck.SlotsTotal, SlotsFree: pktAck.SlotsFree}
+	case packets.AckInvalidVersion:
+		return nil, fmt.Errorf("%w: server supports up to version %d", protocol.ErrUnsupportedVersion, pktAck.Header.Version)
+	case packets.AckInvalidHello:
+		return nil, fmt.Errorf("server rejected hello: malformed or out of range parameters")
+	case packets.AckIncompatible:
+		return nil, fmt.Errorf("server rejected hello: %w", protocol.ErrIncompatibleDirection)
+	case packets.AckBadTransport:
+		return nil, fmt.Errorf("server rejected hello: %w", protocol.ErrUnsupportedTransport)
+	case packets.AckBadSecurity:
+		return nil, fmt.Errorf("server rejected hello: %w", protocol.ErrUnsupportedSecurity)
+	case packets.AckBadDirection:
+		return nil, fmt.Errorf("server rejected hello: %w", protocol.ErrUnsupportedDirection)
+	case packets.AckBadSession:
+		return nil, fmt.Errorf("server rejected hello: %w (check the client clock)", protocol.ErrInvalidSessionID)
+	case packets.AckProtocolError:
+		return nil, fmt.Errorf("server rejected handshake: unexpected or malformed packet")
 	case packets.AckOK:
 		// proceed
 	default:
-		return fmt.Errorf("received unexpected ack code: %d", pktAck.Code)
+		return nil, fmt.Errorf("received unexpected ack code: %d", pktAck.Code)
+	}
+
+	var pingStats *report.PingStats
+	if pings {
+		if pktAck.Flags&packets.FlagPing != 0 {
+			rtts, err := handshake.PingClient(stream, c.timeout, sessionId, uint16(runOpts.Pings))
+			if err != nil {
+				return nil, fmt.Errorf("ping phase failed: %w", err)
+			}
+			pingStats = report.NewPingStats(rtts)
+			log.Info().
+				Int("pings", pingStats.Count).
+				Str("rtt_min", utils.DisplayTime(pingStats.Min)).
+				Str("rtt_avg", utils.DisplayTime(pingStats.Avg)).
+				Str("rtt_max", utils.DisplayTime(pingStats.Max)).
+				Msg("Timed round trips to server")
+		} else {
+			log.Warn().Msg("Server does not support pings, skipping the round trip baseline")
+		}
 	}
 
 	log.Info().Msg("Connected to server successfully, beginning throughput test")
@@ -237,47 +492,265 @@ func (c *ClientTCP) Run(ctx context.Context, runOpts RunOpts) error {
 	warmup := time.Duration(pktHello.WarmupMS) * time.Millisecond
 
 	var stats protocol.Stats
-	t := time.Now()
+	start := time.Now()
+
+	opts := transfer.OptionsFromTimeout(c.timeout)
+	opts.PrimeBytes = pktHello.PrimeBytes
+	opts.AdaptWarmup = runOpts.AdaptWarmup
+	opts.WriteMode = runOpts.WriteMode
+	// the server applies a download's byte target, finishing once it has sent the bytes
+	if pktHello.Direction == protocol.DirectionUpload {
+		opts.BytesTarget = pktHello.BytesTarget
+	} else {
+		opts.BytesPromised = pktHello.BytesTarget
+	}
+	// the intervals are also kept for the report
+	intervals := &transfer.IntervalRecorder{}
+	opts.Sinks = append([]transfer.StatsSink{intervals}, sinksOrConsole(runOpts.Sinks)...)
+	opts.CPUs = runOpts.CPUs
+	opts.ShutdownGrace = runOpts.ShutdownGrace
+
+	datagrams := pktHello.Transport == packets.TransportUDP
+
+	// servers that don't support explicit completion fall back to the half-close
+	explicitEnd := pktAck.Flags&packets.FlagExplicitEnd != 0
+	if runOpts.ExplicitEnd && !explicitEnd {
+		log.Warn().Msg("Server does not support explicit completion, falling back to half-close")
+	}
+	opts.NoHalfClose = explicitEnd
+	if explicitEnd {
+		opts.EndMarker = packets.EndMarker(packets.TypeEnd, sessionId)
+	} else if opts.BytesPromised > 0 && !datagrams && pktAck.Flags&packets.FlagResult != 0 {
+		// a server applying a download's byte target follows its data with the result rather
+		// than waiting for this side's half-close, so the result marks the end of the download
+		opts.EndMarker = packets.ResultMarker(sessionId)
+	}
+	if runOpts.Pauser != nil {
+		if pktAck.Flags&packets.FlagPause != 0 {
+			opts.Pauser = runOpts.Pauser
+			opts.PauseLocal = true
+			opts.PauseMarker = packets.PauseMarker(sessionId)
+			opts.ResumeMarker = packets.ResumeMarker(sessionId)
+		} else {
+			log.Warn().Msg("Server does not support pausing, the test cannot be paused")
+		}
+	}
+	opts.ChunkMin = runOpts.GetChunkMin()
+	// the server paces its own sends to the rate in the hello
+	if rate := runOpts.GetRate(); rate > 0 && pktHello.Direction != protocol.DirectionDownload {
+		opts.Limiter = transfer.NewLimiter(rate, pktHello.ChunkSize)
+	}
+
+	// a datagram test's data phase runs on its own socket, to the port the server opened for it
+	dataConn := conn
+	if datagrams {
+		dataConn, err = dialData(conn, pktAck.DataPort)
+		if err != nil {
+			return nil, err
+		}
+		defer dataConn.Close()
+	}
+
+	tcpSnap := newTCPSnapshot(dataConn)
 
-	switch runOpts.GetDirection() {
-	case protocol.DirectionBidi:
-		err = transfer.TransferData(ctx, conn, r, w, pktHello.ChunkSize, duration, warmup, &stats)
+	switch {
+	case datagrams:
+		err = c.transferDatagrams(ctx, stream, dataConn, pktHello, &stats, opts)
 		if err != nil {
-			return fmt.Errorf("data transfer failed: %w", err)
+			return nil, err
 		}
-	case protocol.DirectionUpload:
-		err = transfer.TransferData(ctx, conn, nil, w, pktHello.ChunkSize, duration, warmup, &stats)
+	case runOpts.GetDirection() == protocol.DirectionBidi:
+		err = transfer.TransferData(ctx, conn, r, w, pktHello.ChunkSize, duration, warmup, &stats, opts)
 		if err != nil {
-			return fmt.Errorf("data send failed: %w", err)
+			return nil, fmt.Errorf("data transfer failed: %w", err)
 		}
-	case protocol.DirectionDownload:
-		err = transfer.TransferData(ctx, conn, r, nil, pktHello.ChunkSize, duration, warmup, &stats)
+	case runOpts.GetDirection() == protocol.DirectionUpload:
+		err = transfer.TransferData(ctx, conn, nil, w, pktHello.ChunkSize, duration, warmup, &stats, opts)
 		if err != nil {
-			return fmt.Errorf("data receive failed: %w", err)
+			return nil, fmt.Errorf("data send failed: %w", err)
+		}
+	case runOpts.GetDirection() == protocol.DirectionDownload:
+		err = transfer.TransferData(ctx, conn, r, nil, pktHello.ChunkSize, duration, warmup, &stats, opts)
+		if err != nil {
+			return nil, fmt.Errorf("data receive failed: %w", err)
 		}
 	default:
-		return fmt.Errorf("invalid direction: %d", pktHello.Direction)
+		return nil, fmt.Errorf("invalid direction: %d", pktHello.Direction)
 	}
 
-	_ = w.Flush()
+	// the transfer flushes its own writes, but check in case anything is left behind
+	if err := w.Flush(); err != nil {
+		stats.MarkFlushFailed()
+	}
 
-	durationReal := time.Since(t) - warmup
-	if durationReal < 0 {
-		durationReal = 0
+	end := time.Now()
+	paused := opts.Pauser.PausedSince(stats.GetCountStart())
+	durationReal := max(0, stats.MeasuredDuration(end)-paused)
+	var warmupReal time.Duration
+	if countStart := stats.GetCountStart(); !countStart.IsZero() {
+		warmupReal = countStart.Sub(start)
+	}
+	tcpStats := tcpSnap.Stats()
+
+	// when interrupted, the transfer returns without waiting for its loops, which may still be
+	// reading the connection, so the trailing exchanges are skipped
+	interrupted := ctx.Err() != nil
+
+	if explicitEnd && !interrupted {
+		direction := pktHello.Direction
+		tail, err := handshake.Complete(stream, c.timeout, sessionId, direction != protocol.DirectionDownload, direction != protocol.DirectionUpload)
+		stats.AddBytesTail(tail)
+		if err != nil {
+			log.Warn().Err(err).Msg("Explicit completion with the server failed")
+		}
+	}
+
+	// read the server's view of the test if it agreed to send one; older servers don't. Data
+	// still arriving after the window closed precedes the result (or the server's half-close)
+	// and is tallied as tail bytes rather than counted towards the measurement.
+	var pktResult *packets.PktResult
+	switch {
+	case interrupted:
+	case pktAck.Flags&packets.FlagResult != 0:
+		var tail uint64
+		pktResult, tail, err = c.recvResultV1(stream, sessionId, packets.ResultSize(pktAck.Flags))
+		stats.AddBytesTail(tail)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to receive result from server")
+		}
+	case !explicitEnd && !datagrams && pktHello.Direction != protocol.DirectionUpload:
+		stats.AddBytesTail(transfer.DrainTail(conn, r, c.timeout))
 	}
 
 	sessionIdStr := sessionId.String()
 	evt := log.Info().Str("session_id", sessionIdStr)
-	evt = evt.Str("duration", utils.DisplayTime(durationReal))
+	if streams > 1 {
+		evt = evt.Uint8("stream", index)
+	}
+	if runOpts.Label != "" {
+		evt = evt.Str("label", runOpts.Label)
+	}
+	if keyNum > 0 {
+		evt = evt.Int("psk", keyNum)
+	}
+	evt = evt.Str("connect", utils.DisplayTime(durationConnect)).
+		Str("handshake", utils.DisplayTime(durationHandshake)).
+		Str("rtt", utils.DisplayTime(rtt)).
+		Str("duration", utils.DisplayTime(durationReal))
+	if pingStats != nil {
+		evt = evt.Str("ping_avg", utils.DisplayTime(pingStats.Avg))
+	}
+	if runOpts.AdaptWarmup {
+		evt = evt.Str("warmup", utils.DisplayTime(warmupReal))
+	}
+	if paused > 0 {
+		evt = evt.Str("paused", utils.DisplayTime(paused))
+	}
 	if stats.GetBytesSent() > 0 {
 		evt = evt.Str("total_sent", utils.DisplayBytes(stats.GetBytesSent())).
 			Str("avg_sent", utils.DisplayBitsPerTime(stats.GetBytesSent(), durationReal))
+		if overhead := stats.GetOverheadSent(); overhead > 0 {
+			evt = evt.Str("wire_sent", utils.DisplayBitsPerTime(stats.GetBytesSent()+overhead, durationReal))
+		}
 	}
 	if stats.GetBytesRcvd() > 0 {
 		evt = evt.Str("total_rcvd", utils.DisplayBytes(stats.GetBytesRcvd())).
 			Str("avg_rcvd", utils.DisplayBitsPerTime(stats.GetBytesRcvd(), durationReal))
+		if overhead := stats.GetOverheadRcvd(); overhead > 0 {
+			evt = evt.Str("wire_rcvd", utils.DisplayBitsPerTime(stats.GetBytesRcvd()+overhead, durationReal))
+		}
+	}
+	if stats.FlushFailed() {
+		evt = evt.Str("note", "final flush failed")
+	}
+	if stats.GetWrites() > 0 {
+		evt = evt.Uint64("writes", stats.GetWrites()).
+			Str("writes_per_sec", utils.DisplayOpsPerTime(stats.GetWrites(), durationReal))
+		if opts.ChunkMin > 0 {
+			evt = evt.Str("avg_write", utils.DisplayBytes(stats.GetBytesSent()/stats.GetWrites()))
+		}
+	}
+	if stats.GetReads() > 0 {
+		evt = evt.Uint64("reads", stats.GetReads()).
+			Str("reads_per_sec", utils.DisplayOpsPerTime(stats.GetReads(), durationReal))
+	}
+	// each write and read of a datagram test is one datagram, so their counts show the loss
+	if datagrams {
+		evt = evt.Str("transport", packets.TransportToString(pktHello.Transport))
+	}
+	if stats.GetBytesTail() > 0 {
+		evt = evt.Str("tail_rcvd", utils.DisplayBytes(stats.GetBytesTail()))
+	}
+	if opts.BytesTarget > 0 {
+		evt = evt.Bool("target_reached", stats.GetBytesSent() >= opts.BytesTarget)
+	}
+	if opts.BytesPromised > 0 {
+		// the server applied the target to its measured bytes, which its result reports exactly
+		reached := pktResult != nil && pktResult.BytesSent >= opts.BytesPromised
+		if pktResult == nil {
+			reached = opts.PromiseKept(&stats, end)
+		}
+		evt = evt.Bool("target_reached", reached)
+	}
+	if opts.Limiter != nil {
+		evt = evt.Str("limited_by", limitedBy(opts.Limiter, durationReal))
+	}
+	if tcpStats != nil && tcpStats.SegmentsOut > 0 {
+		evt = evt.Uint64("retransmits", tcpStats.Retransmits).
+			Str("retrans_rate", utils.DisplayPercent(tcpStats.RetransmitRate()))
+	}
+	if pktResult != nil {
+		evt = evt.Str("server_sent", utils.DisplayBytes(pktResult.BytesSent)).
+			Str("server_rcvd", utils.DisplayBytes(pktResult.BytesReceived))
 	}
 	evt.Msg("Client data transfer complete")
 
-	return nil
+	if pktResult != nil && pktResult.HasDuration() {
+		reconcile(&stats, durationReal, pktResult)
+	}
+	if pktResult != nil && pktResult.HasWindow() {
+		reconcileWindow(warmupReal, durationReal, pktResult)
+	}
+
+	if !runOpts.NoChunkAdvice {
+		adviseChunkSize(pktHello.ChunkSize, &stats, durationReal, opts.Limiter != nil)
+	}
+
+	rpt := &report.Report{
+		SessionID: sessionIdStr,
+		Label:     runOpts.Label,
+		Server:    c.Address(),
+		Direction: protocol.DirectionToString(pktHello.Direction),
+		ChunkSize: pktHello.ChunkSize,
+		ChunkMin:  opts.ChunkMin,
+		Start:     start,
+		Connect:   durationConnect,
+		Handshake: durationHandshake,
+		RTT:       rtt,
+		Warmup:    warmupReal,
+		Duration:  durationReal,
+		Paused:    paused,
+		BytesSent: stats.GetBytesSent(),
+		BytesRcvd: stats.GetBytesRcvd(),
+		BytesTail: stats.GetBytesTail(),
+		Writes:    stats.GetWrites(),
+		Reads:     stats.GetReads(),
+		Overhead:  stats.GetOverheadSent() + stats.GetOverheadRcvd(),
+		TCP:       tcpStats,
+		Ping:      pingStats,
+
+		FlushFailed: stats.FlushFailed(),
+		Intervals:   intervals.Intervals(),
+	}
+	if pktResult != nil {
+		rpt.Remote = &report.RemoteResult{
+			BytesSent: pktResult.BytesSent,
+			BytesRcvd: pktResult.BytesReceived,
+			Duration:  time.Duration(pktResult.DurationNS),
+			Warmup:    time.Duration(pktResult.WarmupNS),
+		}
+	}
+	opts.Final(rpt)
+
+	return rpt, nil
 }
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/transfer"
+	"github.com/goodieshq/goflo/internal/server"
+	"github.com/goodieshq/goflo/internal/utils"
+)
+
+// startServer runs a loopback server for the duration of the test and returns its port
+func startServer(t *testing.T, psk []byte) uint16 {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	ln.Close()
+
+	srv := server.NewServerTCP(server.ServerOpts{Host: "127.0.0.1", Port: port, PSK: psk, Timeout: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = srv.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// the server listens once Run is under way
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+		if err == nil {
+			conn.Close()
+			return port
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// A byte target applies to the sender's measured bytes, the client's when uploading and the
+// server's when downloading, and ends the test once reached however long the warmup before it.
+// The receiver measures nearly the same bytes, its window offset from the sender's by the latency.
+func TestRunBytesTarget(t *testing.T) {
+	const target = 8 << 20
+	psk := []byte("Test1234")
+	port := startServer(t, psk)
+
+	for _, dir := range []protocol.FloDir{protocol.DirectionUpload, protocol.DirectionDownload} {
+		t.Run(protocol.DirectionToString(dir), func(t *testing.T) {
+			cli := NewClientTCP("127.0.0.1", port, psk, nil, FamilyAny, nil)
+			runOpts := RunOpts{
+				Direction:     utils.Ptr(dir),
+				Duration:      utils.Ptr(10 * time.Second),
+				Warmup:        utils.Ptr(400 * time.Millisecond),
+				Bytes:         utils.Ptr(uint64(target)),
+				Rate:          utils.Ptr(uint64(128 << 20)), // 16 MiB/s, so the warmup outweighs half the target
+				Sinks:         []transfer.StatsSink{&transfer.IntervalRecorder{}},
+				NoChunkAdvice: true,
+			}
+
+			start := time.Now()
+			rpt, err := cli.Run(context.Background(), runOpts)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("test took %s, want it to end at the target", elapsed)
+			}
+			if rpt.Remote == nil {
+				t.Fatal("server sent no result")
+			}
+
+			sent, rcvd := rpt.BytesSent, rpt.Remote.BytesRcvd
+			if dir == protocol.DirectionDownload {
+				sent, rcvd = rpt.Remote.BytesSent, rpt.BytesRcvd
+			}
+			if sent != target {
+				t.Errorf("sender measured %d bytes, want the target %d", sent, target)
+			}
+			if rcvd+target/20 < target || rcvd > target+target/20 {
+				t.Errorf("receiver measured %d bytes, want about the target %d", rcvd, target)
+			}
+		})
+	}
+}
+
+// A probe is sent the way the test would be, so a client requiring TLS finds a plaintext server
+// unusable rather than measuring a round trip to a server its test would then fail against.
+func TestProbeTLS(t *testing.T) {
+	psk := []byte("Test1234")
+	port := startServer(t, psk)
+
+	result := NewClientTCP("127.0.0.1", port, psk, nil, FamilyAny, nil).Probe(context.Background())
+	if result.Err != nil || result.RTT <= 0 {
+		t.Errorf("plaintext probe = %+v, want a round trip", result)
+	}
+
+	result = NewClientTCP("127.0.0.1", port, psk, nil, FamilyAny, &tls.Config{InsecureSkipVerify: true}).Probe(context.Background())
+	if !errors.Is(result.Err, protocol.ErrUnsupportedSecurity) {
+		t.Errorf("TLS probe of a plaintext server failed with %v, want %v", result.Err, protocol.ErrUnsupportedSecurity)
+	}
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/goodieshq/goflo/internal/report"
+)
+
+// tcpCounters is a snapshot of the kernel's per-connection send counters
+type tcpCounters struct {
+	SegmentsOut uint64 // data segments sent, including retransmissions
+	Retransmits uint64 // segments retransmitted over the connection's lifetime
+}
+
+// tcpSnapshot captures the connection's counters before the data phase so only the test's own
+// segments are attributed to it. It is a no-op where TCP_INFO is unavailable.
+type tcpSnapshot struct {
+	conn   net.Conn
+	before tcpCounters
+	ok     bool
+}
+
+func newTCPSnapshot(conn net.Conn) *tcpSnapshot {
+	// the counters belong to the TCP connection underneath TLS
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	before, ok := readTCPCounters(conn)
+	return &tcpSnapshot{conn: conn, before: before, ok: ok}
+}
+
+// Stats returns the segments sent and retransmitted since the snapshot was taken, or nil if the
+// counters could not be read
+func (s *tcpSnapshot) Stats() *report.TCPStats {
+	if !s.ok {
+		return nil
+	}
+	after, ok := readTCPCounters(s.conn)
+	if !ok {
+		return nil
+	}
+	return &report.TCPStats{
+		SegmentsOut: after.SegmentsOut - s.before.SegmentsOut,
+		Retransmits: after.Retransmits - s.before.Retransmits,
+	}
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package client
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// readTCPCounters reads the kernel's segment and retransmission counters for a TCP connection
+func readTCPCounters(conn net.Conn) (tcpCounters, bool) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return tcpCounters{}, false
+	}
+
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return tcpCounters{}, false
+	}
+
+	var info *unix.TCPInfo
+	var infoErr error
+	err = raw.Control(func(fd uintptr) {
+		info, infoErr = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil || infoErr != nil {
+		return tcpCounters{}, false
+	}
+
+	return tcpCounters{
+		SegmentsOut: uint64(info.Data_segs_out),
+		Retransmits: uint64(info.Total_retrans),
+	}, true
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package client
+
+import "net"
+
+// readTCPCounters is unavailable on this platform, so retransmissions are not reported
+func readTCPCounters(conn net.Conn) (tcpCounters, bool) {
+	return tcpCounters{}, false
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// security returns the security the client's hellos request
+func (c *ClientTCP) security() packets.FloSecurity {
+	if c.tlsConfig != nil {
+		return packets.SecurityTLS
+	}
+	return packets.SecurityNone
+}
+
+// startTLS wraps the connection in TLS if the client has a TLS configuration. The server's
+// certificate is verified against the host it was dialed by, unless the configuration names
+// another.
+func (c *ClientTCP) startTLS(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	if c.tlsConfig == nil {
+		return conn, nil
+	}
+
+	cfg := c.tlsConfig
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = c.host
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	tlsConn := tls.Client(conn, cfg)
+	err := tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		// a server without TLS answers the ClientHello with a plaintext ack
+		var errRecord tls.RecordHeaderError
+		if errors.As(err, &errRecord) && bytes.HasPrefix(errRecord.RecordHeader[:], []byte("FLO\x00")) {
+			return nil, fmt.Errorf("server rejected hello: %w (the server does not accept TLS)", protocol.ErrUnsupportedSecurity)
+		}
+		return nil, fmt.Errorf("failed TLS handshake: %w", err)
+	}
+
+	state := tlsConn.ConnectionState()
+	log.Debug().
+		Str("version", tls.VersionName(state.Version)).
+		Str("cipher_suite", tls.CipherSuiteName(state.CipherSuite)).
+		Msg("TLS handshake complete")
+	return tlsConn, nil
+}
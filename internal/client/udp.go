@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/handshake"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/goodieshq/goflo/internal/protocol/transfer"
+)
+
+// dialData connects the data socket of a datagram test to the port the server opened for it, at
+// the address the control connection reached
+func dialData(conn net.Conn, port uint16) (net.Conn, error) {
+	if port == 0 {
+		return nil, fmt.Errorf("server did not open a data port for the UDP test")
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("UDP tests require a direct TCP control connection")
+	}
+
+	data, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: addr.IP, Port: int(port), Zone: addr.Zone})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data socket: %w", err)
+	}
+	return data, nil
+}
+
+// transferDatagrams runs the data phase of a datagram test once the server has confirmed the data
+// socket's registration on the control stream
+func (c *ClientTCP) transferDatagrams(ctx context.Context, stream *handshake.ConnStream, data net.Conn, pktHello *packets.PktHello, stats *protocol.Stats, opts transfer.Options) error {
+	err := handshake.RegisterClient(stream, data, c.timeout, pktHello.SessionID)
+	if err != nil {
+		return err
+	}
+
+	duration := time.Duration(pktHello.DurationMS) * time.Millisecond
+	warmup := time.Duration(pktHello.WarmupMS) * time.Millisecond
+	send := pktHello.Direction == protocol.DirectionUpload
+
+	err = transfer.TransferDatagrams(ctx, data, send, pktHello.ChunkSize, duration, warmup, stats, opts)
+	if err != nil {
+		return fmt.Errorf("datagram transfer failed: %w", err)
+	}
+	return nil
+}
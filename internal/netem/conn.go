@@ -0,0 +1,207 @@
+// Package netem impairs a net.Conn in-process with delay, jitter, loss and a rate limit, so the
+// measurement code can be exercised against a known bad link without real network emulation.
+package netem
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// Impairment describes the link conditions applied to writes on a Conn
+type Impairment struct {
+	Delay  time.Duration // fixed one-way delay added to every write
+	Jitter time.Duration // random extra delay in [0, Jitter) added to every write
+	Loss   float64       // probability in [0, 1] that a write is silently dropped
+	Seed   uint64        // seed for the jitter and loss decisions, for reproducible runs
+	Rate   uint64        // link rate in bits per second, serializing writes before their delay (0 is unlimited)
+	Queue  int           // bytes in flight before Write blocks, like a socket buffer (DefaultQueue if zero)
+}
+
+// DefaultQueue is the bytes in flight a Conn allows when the Impairment doesn't set a limit
+const DefaultQueue = 4 << 20
+
+func (imp Impairment) queueLimit() int {
+	if imp.Queue > 0 {
+		return imp.Queue
+	}
+	return DefaultQueue
+}
+
+// write is a pending write waiting for its delivery time
+type write struct {
+	data      []byte
+	deliverAt time.Time
+}
+
+// Conn wraps a net.Conn, delaying and dropping its writes according to an Impairment. Writes are
+// delivered in order, so jitter never reorders a byte stream; a later write whose jitter would
+// overtake an earlier one is held back until the earlier one has been delivered. A write blocks
+// while the queue holds its limit, so a writer faster than the link is held back as by a full
+// socket buffer. Reads pass through unchanged, so impair both ends to affect both directions.
+type Conn struct {
+	net.Conn
+	imp Impairment
+
+	mu       sync.Mutex
+	space    *sync.Cond // signalled when the queue shrinks, the connection fails or it is closed
+	rng      *rand.Rand
+	queue    []write
+	queued   int       // bytes in the queue
+	last     time.Time // delivery time of the most recently queued write
+	linkFree time.Time // when the rate-limited link finishes serializing the queued writes
+	err      error     // first error from the underlying connection, returned by later writes
+	closed   bool
+	wake     chan struct{}
+	done     chan struct{}
+	drained  chan struct{}
+	dropped  uint64
+	released uint64
+}
+
+var ErrClosed = errors.New("netem: connection closed")
+
+// Wrap impairs the connection. The caller must Close the returned Conn to stop its scheduler.
+func Wrap(conn net.Conn, imp Impairment) *Conn {
+	c := &Conn{
+		Conn:    conn,
+		imp:     imp,
+		rng:     rand.New(rand.NewPCG(imp.Seed, imp.Seed^0x9e3779b97f4a7c15)),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		drained: make(chan struct{}),
+	}
+	c.space = sync.NewCond(&c.mu)
+	go c.schedule()
+	return c
+}
+
+// Write queues b for delayed delivery, or drops it, and reports it as fully written either way.
+// It first waits for the queue to have room for b, unless the queue is empty.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for !c.closed && c.err == nil && c.queued > 0 && c.queued+len(b) > c.imp.queueLimit() {
+		c.space.Wait()
+	}
+	if c.closed {
+		return 0, ErrClosed
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if c.imp.Loss > 0 && c.rng.Float64() < c.imp.Loss {
+		c.dropped++
+		return len(b), nil
+	}
+
+	// the link sends one write at a time, so at a limited rate each waits for those before it
+	now := time.Now()
+	sent := now
+	if c.imp.Rate > 0 {
+		sent = c.linkFree
+		if sent.Before(now) {
+			sent = now
+		}
+		sent = sent.Add(time.Duration(float64(len(b)) * 8 / float64(c.imp.Rate) * float64(time.Second)))
+		c.linkFree = sent
+	}
+
+	delay := c.imp.Delay
+	if c.imp.Jitter > 0 {
+		delay += time.Duration(c.rng.Int64N(int64(c.imp.Jitter)))
+	}
+	deliverAt := sent.Add(delay)
+	if deliverAt.Before(c.last) {
+		deliverAt = c.last
+	}
+	c.last = deliverAt
+
+	c.queue = append(c.queue, write{data: append([]byte(nil), b...), deliverAt: deliverAt})
+	c.queued += len(b)
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+// schedule delivers queued writes to the underlying connection once they are due
+func (c *Conn) schedule() {
+	defer close(c.drained)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+
+		c.mu.Lock()
+		if len(c.queue) == 0 {
+			c.mu.Unlock()
+			select {
+			case <-c.done:
+				return
+			case <-c.wake:
+				continue
+			}
+		}
+		next := c.queue[0]
+		c.mu.Unlock()
+
+		if wait := time.Until(next.deliverAt); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-c.done:
+				return
+			case <-timer.C:
+			}
+		}
+
+		_, err := c.Conn.Write(next.data)
+
+		c.mu.Lock()
+		c.queue = c.queue[1:]
+		c.queued -= len(next.data)
+		c.released++
+		if err != nil && c.err == nil {
+			c.err = err
+		}
+		c.space.Broadcast()
+		c.mu.Unlock()
+	}
+}
+
+// Counts returns how many writes have been delivered and how many were dropped
+func (c *Conn) Counts() (delivered, dropped uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.released, c.dropped
+}
+
+// Close closes the underlying connection and stops the scheduler, discarding writes that were
+// still in flight. The connection is closed first, as the scheduler may be blocked writing to it.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	c.space.Broadcast()
+	c.mu.Unlock()
+
+	err := c.Conn.Close()
+	close(c.done)
+	<-c.drained
+	return err
+}
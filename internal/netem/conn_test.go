@@ -0,0 +1,147 @@
+package netem
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDelayPreservesOrder(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := Wrap(a, Impairment{Delay: 50 * time.Millisecond, Jitter: 20 * time.Millisecond, Seed: 1})
+	defer c.Close()
+
+	want := []byte("0123456789")
+	start := time.Now()
+	for i := range want {
+		if _, err := c.Write(want[i : i+1]); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(b, got); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("writes arrived after %v, before the 50ms delay", elapsed)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("received %q, want %q", got, want)
+	}
+}
+
+func TestLossDropsWrites(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := Wrap(a, Impairment{Loss: 1})
+	defer c.Close()
+
+	for range 10 {
+		if n, err := c.Write([]byte("x")); n != 1 || err != nil {
+			t.Fatalf("Write = %d, %v; want 1, nil", n, err)
+		}
+	}
+	if delivered, dropped := c.Counts(); delivered != 0 || dropped != 10 {
+		t.Errorf("Counts = %d delivered, %d dropped; want 0, 10", delivered, dropped)
+	}
+}
+
+func TestRateLimitsThroughput(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	// 100 KB at 8 Mbps takes 100ms on the link
+	c := Wrap(a, Impairment{Rate: 8_000_000})
+	defer c.Close()
+
+	go io.Copy(io.Discard, b)
+
+	chunk := make([]byte, 10_000)
+	start := time.Now()
+	for range 10 {
+		if _, err := c.Write(chunk); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	for {
+		if delivered, _ := c.Counts(); delivered == 10 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("100 KB delivered in %v at 8 Mbps, want about 100ms", elapsed)
+	}
+}
+
+// A writer faster than the link blocks once the queue is full, until writes are delivered
+func TestQueueBackpressure(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := Wrap(a, Impairment{Queue: 3})
+	defer c.Close()
+
+	// nothing reads b, so the scheduler blocks delivering the first write and the queue fills
+	for range 3 {
+		if _, err := c.Write([]byte("x")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := c.Write([]byte("x"))
+		blocked <- err
+	}()
+	select {
+	case err := <-blocked:
+		t.Fatalf("Write past the queue limit returned %v without blocking", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// reading one write makes room for the blocked one
+	if _, err := b.Read(make([]byte, 1)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	select {
+	case err := <-blocked:
+		if err != nil {
+			t.Fatalf("blocked Write: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write stayed blocked after the queue drained")
+	}
+}
+
+// Close must not wait on a scheduler stuck writing to a peer that stopped reading
+func TestCloseWhileDeliveryBlocked(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := Wrap(a, Impairment{Queue: 1})
+
+	if _, err := c.Write([]byte("stuck")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := c.Write([]byte("waiting"))
+		blocked <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close deadlocked on the blocked scheduler")
+	}
+	if err := <-blocked; err != ErrClosed {
+		t.Errorf("blocked Write returned %v, want %v", err, ErrClosed)
+	}
+}
@@ -9,16 +9,22 @@ var (
 	ErrIncorrectType      = errors.New("incorrect packet type")
 	ErrUnsupportedType    = errors.New("unsupported packet type")
 	ErrAuthFailed         = errors.New("authentication failed")
+	ErrAuthRequired       = errors.New("authentication required")
 	ErrInvalidSessionID   = errors.New("invalid session ID")
 	ErrInvalidNonce       = errors.New("invalid nonce")
 	ErrReusedNonce        = errors.New("reused nonce")
+	ErrInvalidAuthHash    = errors.New("invalid auth hash")
 
 	// Hello packet errors
-	ErrUnsupportedTransport = errors.New("unsupported transport type")
-	ErrUnsupportedSecurity  = errors.New("unsupported security type")
-	ErrUnsupportedDirection = errors.New("unsupported direction type")
-	ErrInvalidFlags         = errors.New("invalid flags")
-	ErrInvalidChunkSize     = errors.New("invalid chunk size")
-	ErrInvalidWarmup        = errors.New("invalid warmup period")
-	ErrInvalidDuration      = errors.New("invalid duration")
+	ErrUnsupportedTransport  = errors.New("unsupported transport type")
+	ErrUnsupportedSecurity   = errors.New("unsupported security type")
+	ErrTLSRequired           = errors.New("TLS required")
+	ErrUnsupportedDirection  = errors.New("unsupported direction type")
+	ErrIncompatibleDirection = errors.New("direction not supported by transport")
+	ErrInvalidFlags          = errors.New("invalid flags")
+	ErrInvalidChunkSize      = errors.New("invalid chunk size")
+	ErrInvalidWarmup         = errors.New("invalid warmup period")
+	ErrInvalidDuration       = errors.New("invalid duration")
+	ErrInvalidStreams        = errors.New("invalid stream count")
+	ErrInvalidPing           = errors.New("invalid ping")
 )
@@ -0,0 +1,98 @@
+package handshake
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/rs/zerolog/log"
+)
+
+// ClientConfig holds the client's credentials and limits for the handshake
+type ClientConfig struct {
+	PSK     []byte        // pre-shared key used to answer the server's challenge
+	Timeout time.Duration // bound on each packet read/write (if the stream supports deadlines)
+}
+
+// Client performs the client side of the v1 handshake: it sends the hello, answers the server's
+// challenge when authentication is required and returns the server's ack. It also returns the
+// round trip from sending the hello to the server's first response, challenge or ack, which
+// doesn't include the time either side spends computing the authentication hash.
+func Client(rw io.ReadWriter, pktHello *packets.PktHello, cfg ClientConfig) (*packets.PktAck, time.Duration, error) {
+	t := time.Now()
+	bufHello, err := Send(rw, cfg.Timeout, pktHello)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send hello packet: %w", err)
+	}
+	log.Debug().Msg("Hello packet sent")
+
+	// read the response header from the server
+	header, bufHeader, err := RecvHeader(rw, cfg.Timeout)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to receive packet header: %w", err)
+	}
+	rtt := time.Since(t)
+
+	// Handle server response based on packet type
+	switch header.Type {
+	case packets.TypeChallenge:
+		// without a key any answer is doomed, so give up before sending one
+		if len(cfg.PSK) == 0 {
+			return nil, 0, fmt.Errorf("%w: the server requires a pre-shared key but none is configured", protocol.ErrAuthRequired)
+		}
+
+		// receive Challenge packet from server
+		bufChallenge, err := RecvBody(rw, cfg.Timeout, bufHeader, packets.PktChallengeSize)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read challenge packet: %w", err)
+		}
+
+		pktChallenge, err := packets.UnmarshalChallenge(bufChallenge)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to unmarshal challenge packet: %w", err)
+		}
+
+		// compute auth hash from challenge and psk
+		hash := packets.ComputeAuthHash(bufHello, pktChallenge.NonceServer, cfg.PSK)
+
+		// send Answer packet to server
+		pktAnswer, err := packets.NewAnswer(pktHello.SessionID, hash[:], pktHello.Flags)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to create answer packet: %w", err)
+		}
+
+		_, err = Send(rw, cfg.Timeout, pktAnswer)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to send answer packet: %w", err)
+		}
+		log.Debug().Msg("Answer packet sent")
+
+		// receive Ack packet from server
+		header, bufHeader, err = RecvHeader(rw, cfg.Timeout)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to receive packet header: %w", err)
+		}
+
+		if header.Type != packets.TypeAck {
+			return nil, 0, fmt.Errorf("expected Ack packet, got type: %d", header.Type)
+		}
+	case packets.TypeAck:
+		// no authentication required
+	default:
+		return nil, 0, fmt.Errorf("unexpected packet type: %d", header.Type)
+	}
+
+	bufAck, err := RecvAck(rw, cfg.Timeout, bufHeader)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read ack packet: %w", err)
+	}
+
+	pktAck, err := packets.UnmarshalAck(bufAck)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to unmarshal ack packet: %w", err)
+	}
+
+	return pktAck, rtt, nil
+}
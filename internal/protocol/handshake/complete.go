@@ -0,0 +1,57 @@
+package handshake
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/oklog/ulid/v2"
+)
+
+// Complete performs the explicit completion handshake negotiated with FlagExplicitEnd, in place
+// of a TCP half-close that some middleboxes mishandle. A side that sent data announces its end
+// with an End packet; a side that received data finds the peer's End behind any data still in
+// flight and answers with an EndAck; the sender then waits for that EndAck before closing. Both
+// sides of a bidirectional test do all three. It returns the data bytes skipped while looking
+// for the peer's End (and EndAck), which arrived after the measured window.
+func Complete(rw PeekReader, timeout time.Duration, sessionID ulid.ULID, sending, receiving bool) (uint64, error) {
+	var tail uint64
+
+	if sending {
+		pktEnd, err := packets.NewEnd(sessionID)
+		if err != nil {
+			return tail, fmt.Errorf("failed to create end packet: %w", err)
+		}
+		_, err = Send(rw, timeout, pktEnd)
+		if err != nil {
+			return tail, fmt.Errorf("failed to send end packet: %w", err)
+		}
+	}
+
+	if receiving {
+		_, skipped, err := Scan(rw, timeout, packets.EndMarker(packets.TypeEnd, sessionID), packets.PktEndSize)
+		tail += skipped
+		if err != nil {
+			return tail, fmt.Errorf("failed to receive end packet: %w", err)
+		}
+
+		pktEndAck, err := packets.NewEndAck(sessionID)
+		if err != nil {
+			return tail, fmt.Errorf("failed to create end ack packet: %w", err)
+		}
+		_, err = Send(rw, timeout, pktEndAck)
+		if err != nil {
+			return tail, fmt.Errorf("failed to send end ack packet: %w", err)
+		}
+	}
+
+	if sending {
+		_, skipped, err := Scan(rw, timeout, packets.EndMarker(packets.TypeEndAck, sessionID), packets.PktEndSize)
+		tail += skipped
+		if err != nil {
+			return tail, fmt.Errorf("failed to receive end ack packet: %w", err)
+		}
+	}
+
+	return tail, nil
+}
@@ -0,0 +1,271 @@
+package handshake
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/oklog/ulid/v2"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	// the handshake logs each packet at debug level
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	os.Exit(m.Run())
+}
+
+const testTimeout = 2 * time.Second
+
+var testPSK = []byte("Test1234")
+
+func newTestHello(tb testing.TB, flags packets.FloFlags) *packets.PktHello {
+	tb.Helper()
+	pkt, err := packets.NewHello(packets.TransportTCP, ulid.Make(), packets.SecurityNone, protocol.DirectionUpload, 8192, 10*time.Second, time.Second, 0, 0, 0, flags)
+	if err != nil {
+		tb.Fatalf("NewHello: %v", err)
+	}
+	return pkt
+}
+
+// serve runs the server side of one handshake on conn, accepting the hello with an OK ack
+func serve(conn net.Conn, psk []byte) error {
+	_, bufHeader, err := RecvHeader(conn, testTimeout)
+	if err != nil {
+		return err
+	}
+	pktHello, auth, err := Server(conn, bufHeader, ServerConfig{PSK: psk, Timeout: testTimeout})
+	if err != nil {
+		return err
+	}
+	return SendAck(conn, testTimeout, pktHello.SessionID, auth, packets.AckOK, pktHello.Flags&packets.FlagResult)
+}
+
+// handshake runs a complete handshake over a fresh net.Pipe, returning the client's ack
+func handshake(tb testing.TB, serverPSK, clientPSK []byte) *packets.PktAck {
+	tb.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	errServer := make(chan error, 1)
+	go func() { errServer <- serve(server, serverPSK) }()
+
+	var flags packets.FloFlags = packets.FlagResult
+	if len(clientPSK) == 0 {
+		flags |= packets.FlagNoAuth
+	}
+	pktAck, _, err := Client(client, newTestHello(tb, flags), ClientConfig{PSK: clientPSK, Timeout: testTimeout})
+	if err != nil {
+		tb.Fatalf("Client: %v", err)
+	}
+	if err := <-errServer; err != nil {
+		tb.Fatalf("Server: %v", err)
+	}
+	return pktAck
+}
+
+func TestRejectAnswer(t *testing.T) {
+	tests := []struct {
+		name    string
+		version protocol.FloVersion
+		pktType protocol.FloType
+		want    error
+	}{
+		{"wrong type", protocol.FloVersion1, packets.TypeHello, protocol.ErrIncorrectType},
+		{"wrong version", protocol.FloVersion1 + 1, packets.TypeAnswer, protocol.ErrUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			errServer := make(chan error, 1)
+			go func() { errServer <- serve(server, testPSK) }()
+
+			_, err := Send(client, testTimeout, newTestHello(t, packets.FlagResult))
+			if err != nil {
+				t.Fatalf("Send hello: %v", err)
+			}
+			header, bufHeader, err := RecvHeader(client, testTimeout)
+			if err != nil {
+				t.Fatalf("RecvHeader: %v", err)
+			}
+			if header.Type != packets.TypeChallenge {
+				t.Fatalf("got %s, want challenge", packets.PacketTypeToString(header.Type))
+			}
+			_, err = RecvBody(client, testTimeout, bufHeader, packets.PktChallengeSize)
+			if err != nil {
+				t.Fatalf("RecvBody: %v", err)
+			}
+
+			// the server rejects on the header alone, so the body is never needed
+			_, err = client.Write([]byte{'F', 'L', 'O', 0x00, byte(tt.version), byte(tt.pktType)})
+			if err != nil {
+				t.Fatalf("Write answer header: %v", err)
+			}
+
+			_, bufHeader, err = RecvHeader(client, testTimeout)
+			if err != nil {
+				t.Fatalf("RecvHeader: %v", err)
+			}
+			bufAck, err := RecvAck(client, testTimeout, bufHeader)
+			if err != nil {
+				t.Fatalf("RecvAck: %v", err)
+			}
+			pktAck, err := packets.UnmarshalAck(bufAck)
+			if err != nil {
+				t.Fatalf("UnmarshalAck: %v", err)
+			}
+			if pktAck.Code != packets.AckProtocolError {
+				t.Errorf("ack code %d, want %d", pktAck.Code, packets.AckProtocolError)
+			}
+			if err := <-errServer; !errors.Is(err, tt.want) {
+				t.Errorf("Server error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// A client whose hello carries FlagAnswerLength puts its hash's length on the wire, so a hash of
+// the wrong length for the challenged method is read as sent and rejected as malformed, rather than
+// read as the method's size and failing verification like a wrong key.
+func TestAnswerLength(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		want error
+	}{
+		{"correct", sha256.Size, nil},
+		{"short", sha256.Size - 1, protocol.ErrInvalidAuthHash},
+		{"long", sha256.Size + 1, protocol.ErrInvalidAuthHash},
+		{"empty", 0, protocol.ErrInvalidAuthHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			errServer := make(chan error, 1)
+			go func() { errServer <- serve(server, testPSK) }()
+
+			pktHello := newTestHello(t, packets.FlagResult|packets.FlagAnswerLength)
+			bufHello, err := Send(client, testTimeout, pktHello)
+			if err != nil {
+				t.Fatalf("Send hello: %v", err)
+			}
+			_, bufHeader, err := RecvHeader(client, testTimeout)
+			if err != nil {
+				t.Fatalf("RecvHeader: %v", err)
+			}
+			bufChallenge, err := RecvBody(client, testTimeout, bufHeader, packets.PktChallengeSize)
+			if err != nil {
+				t.Fatalf("RecvBody: %v", err)
+			}
+			pktChallenge, err := packets.UnmarshalChallenge(bufChallenge)
+			if err != nil {
+				t.Fatalf("UnmarshalChallenge: %v", err)
+			}
+
+			// the correct hash, cut short or padded to the size under test
+			hash := packets.ComputeAuthHash(bufHello, pktChallenge.NonceServer, testPSK)
+			pktAnswer, err := packets.NewAnswer(pktHello.SessionID, append(hash[:], 0)[:tt.size], pktHello.Flags)
+			if err != nil {
+				t.Fatalf("NewAnswer: %v", err)
+			}
+			if _, err := Send(client, testTimeout, pktAnswer); err != nil {
+				t.Fatalf("Send answer: %v", err)
+			}
+
+			_, bufHeader, err = RecvHeader(client, testTimeout)
+			if err != nil {
+				t.Fatalf("RecvHeader: %v", err)
+			}
+			bufAck, err := RecvAck(client, testTimeout, bufHeader)
+			if err != nil {
+				t.Fatalf("RecvAck: %v", err)
+			}
+			pktAck, err := packets.UnmarshalAck(bufAck)
+			if err != nil {
+				t.Fatalf("UnmarshalAck: %v", err)
+			}
+
+			want := packets.AckOK
+			if tt.want != nil {
+				want = packets.AckProtocolError
+			}
+			if pktAck.Code != want {
+				t.Errorf("ack code %d, want %d", pktAck.Code, want)
+			}
+			if err := <-errServer; !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("Server error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// countingConn counts the bytes written through it
+type countingConn struct {
+	net.Conn
+	written int
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written += n
+	return n, err
+}
+
+func TestClientWithoutPSK(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	errServer := make(chan error, 1)
+	go func() { errServer <- serve(server, testPSK) }()
+
+	// without FlagNoAuth the server challenges, and the client must give up without answering
+	pktHello := newTestHello(t, packets.FlagResult)
+	bufHello, err := pktHello.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	conn := &countingConn{Conn: client}
+	_, _, err = Client(conn, pktHello, ClientConfig{Timeout: testTimeout})
+	if !errors.Is(err, protocol.ErrAuthRequired) {
+		t.Fatalf("Client error = %v, want %v", err, protocol.ErrAuthRequired)
+	}
+	if conn.written != len(bufHello) {
+		t.Errorf("client wrote %d bytes, want only the %d byte hello", conn.written, len(bufHello))
+	}
+
+	client.Close()
+	if err := <-errServer; err == nil || errors.Is(err, protocol.ErrAuthFailed) {
+		t.Errorf("Server error = %v, want the connection to close before an answer", err)
+	}
+}
+
+func benchmarkHandshake(b *testing.B, psk []byte) {
+	b.ReportAllocs()
+	for b.Loop() {
+		pktAck := handshake(b, psk, psk)
+		if pktAck.Code != packets.AckOK {
+			b.Fatalf("ack code %d, want OK", pktAck.Code)
+		}
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "handshakes/s")
+}
+
+func BenchmarkHandshakeAuth(b *testing.B) {
+	benchmarkHandshake(b, testPSK)
+}
+
+func BenchmarkHandshakeNoAuth(b *testing.B) {
+	benchmarkHandshake(b, nil)
+}
@@ -0,0 +1,87 @@
+package handshake
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/oklog/ulid/v2"
+)
+
+// PingClient performs the ping phase negotiated with FlagPing, sending count pings one at a time
+// and returning the round trip to each pong in order
+func PingClient(rw io.ReadWriter, timeout time.Duration, sessionID ulid.ULID, count uint16) ([]time.Duration, error) {
+	rtts := make([]time.Duration, 0, count)
+	for seq := range count {
+		pktPing, err := packets.NewPing(sessionID, seq, count)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ping packet: %w", err)
+		}
+
+		t := time.Now()
+		_, err = Send(rw, timeout, pktPing)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send ping packet: %w", err)
+		}
+
+		pktPong, err := recvPing(rw, timeout, packets.TypePong)
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive pong packet: %w", err)
+		}
+		rtts = append(rtts, time.Since(t))
+
+		if pktPong.SessionID != sessionID || pktPong.Seq != seq {
+			return nil, fmt.Errorf("%w: pong %d answered ping %d", protocol.ErrInvalidPing, pktPong.Seq, seq)
+		}
+	}
+	return rtts, nil
+}
+
+// PingServer answers the client's pings until the last of its ping phase, returning how many it answered
+func PingServer(rw io.ReadWriter, timeout time.Duration, sessionID ulid.ULID) (int, error) {
+	for n := 1; ; n++ {
+		pktPing, err := recvPing(rw, timeout, packets.TypePing)
+		if err != nil {
+			return n - 1, fmt.Errorf("failed to receive ping packet: %w", err)
+		}
+		if pktPing.SessionID != sessionID {
+			return n - 1, fmt.Errorf("%w: session %s", protocol.ErrInvalidPing, pktPing.SessionID)
+		}
+		// pings arrive in order, which bounds the phase by the largest count
+		if int(pktPing.Seq) != n-1 {
+			return n - 1, fmt.Errorf("%w: ping %d out of order, expected %d", protocol.ErrInvalidPing, pktPing.Seq, n-1)
+		}
+
+		pktPong, err := packets.NewPong(pktPing)
+		if err != nil {
+			return n - 1, fmt.Errorf("failed to create pong packet: %w", err)
+		}
+		_, err = Send(rw, timeout, pktPong)
+		if err != nil {
+			return n - 1, fmt.Errorf("failed to send pong packet: %w", err)
+		}
+
+		if pktPing.Seq+1 == pktPing.Count {
+			return n, nil
+		}
+	}
+}
+
+// recvPing reads a Ping or Pong packet, by type
+func recvPing(rw io.ReadWriter, timeout time.Duration, t protocol.FloType) (*packets.PktPing, error) {
+	header, bufHeader, err := RecvHeader(rw, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if header.Type != t {
+		return nil, fmt.Errorf("%w: expected %s, got %s", protocol.ErrIncorrectType, packets.PacketTypeToString(t), packets.PacketTypeToString(header.Type))
+	}
+
+	bufPing, err := RecvBody(rw, timeout, bufHeader, packets.PktPingSize)
+	if err != nil {
+		return nil, err
+	}
+	return packets.UnmarshalPing(bufPing)
+}
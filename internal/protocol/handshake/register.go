@@ -0,0 +1,86 @@
+package handshake
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/capture"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/oklog/ulid/v2"
+)
+
+// registerInterval is how often the client repeats its Register datagram until the server confirms
+// it, as any of them may be lost
+const registerInterval = 100 * time.Millisecond
+
+// rawPacket is a packet already in its wire form, such as a marker-only packet
+type rawPacket []byte
+
+func (p rawPacket) Marshal() ([]byte, error) {
+	return p, nil
+}
+
+// RegisterClient registers the client's data socket for a datagram test. It sends the Register
+// datagram on the connected data socket every registerInterval until the server confirms it by
+// returning the same packet on the control stream, or the timeout elapses.
+func RegisterClient(control PeekReader, data net.Conn, timeout time.Duration, sessionID ulid.ULID) error {
+	marker := packets.RegisterMarker(sessionID)
+
+	confirmed := make(chan error, 1)
+	go func() {
+		_, _, err := Scan(control, timeout, marker, len(marker))
+		confirmed <- err
+	}()
+
+	tick := time.NewTicker(registerInterval)
+	defer tick.Stop()
+	for {
+		_, err := data.Write(marker)
+		if err != nil {
+			return fmt.Errorf("failed to send register datagram: %w", err)
+		}
+		record(control, capture.Sent, marker)
+
+		select {
+		case err := <-confirmed:
+			if err != nil {
+				return fmt.Errorf("failed to receive register confirmation: %w", err)
+			}
+			return nil
+		case <-tick.C:
+		}
+	}
+}
+
+// RegisterServer waits on the data socket for the client's Register datagram, then confirms it on
+// the control stream. The marker travels in the clear, so a Register is only accepted from peerIP,
+// the client's address on the control connection, keeping anyone who sees it from pointing the
+// test's traffic at another host. The port is not checked, as a NAT maps the data socket to a port
+// of its own; the datagram's source is returned as the client's data address.
+func RegisterServer(control io.ReadWriter, data *net.UDPConn, timeout time.Duration, sessionID ulid.ULID, peerIP net.IP) (*net.UDPAddr, error) {
+	marker := packets.RegisterMarker(sessionID)
+
+	_ = data.SetReadDeadline(time.Now().Add(timeout))
+	defer data.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, len(marker)+1)
+	for {
+		n, addr, err := data.ReadFromUDP(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive register datagram: %w", err)
+		}
+		if !bytes.Equal(buf[:n], marker) || !addr.IP.Equal(peerIP) {
+			continue
+		}
+		record(control, capture.Received, marker)
+
+		_, err = Send(control, timeout, rawPacket(marker))
+		if err != nil {
+			return nil, fmt.Errorf("failed to confirm registration: %w", err)
+		}
+		return addr, nil
+	}
+}
@@ -0,0 +1,58 @@
+package handshake
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/capture"
+	"github.com/goodieshq/goflo/internal/utils"
+)
+
+// PeekReader is a buffered stream that can be searched without consuming past a match, as
+// implemented by bufio.Reader and ConnStream
+type PeekReader interface {
+	io.ReadWriter
+	Peek(n int) ([]byte, error)
+	Discard(n int) (int, error)
+	Buffered() int
+}
+
+// Scan locates a packet of the given size beginning with marker in a stream that may still carry
+// unframed data bytes ahead of it, such as a control packet trailing the data phase. Nothing past
+// the packet is consumed, so packets following it can be read afterwards. The whole search is
+// bounded by the timeout. It returns the packet and the number of bytes skipped.
+func Scan(rw PeekReader, timeout time.Duration, marker []byte, size int) ([]byte, uint64, error) {
+	setReadDeadline(rw, timeout)
+
+	var discarded uint64
+	keep := len(marker) - 1
+
+	for {
+		// wait for at least enough data to hold the marker, then search everything buffered
+		_, err := rw.Peek(len(marker))
+		if err != nil {
+			return nil, discarded, fmt.Errorf("failed to find packet: %w", err)
+		}
+		buf, _ := rw.Peek(rw.Buffered())
+
+		idx := bytes.Index(buf, marker)
+		if idx >= 0 {
+			_, _ = rw.Discard(idx)
+			discarded += uint64(idx)
+
+			// the deadline set above bounds the whole search, so it isn't extended here
+			bufPkt, err := utils.ReadExact(rw, size)
+			if err != nil {
+				return nil, discarded, fmt.Errorf("failed to read packet: %w", err)
+			}
+			record(rw, capture.Received, bufPkt)
+			return bufPkt, discarded, nil
+		}
+
+		// only the tail that could be the start of a split marker needs to be kept
+		n, _ := rw.Discard(len(buf) - keep)
+		discarded += uint64(n)
+	}
+}
@@ -0,0 +1,173 @@
+package handshake
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/oklog/ulid/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// ServerConfig holds the server's credentials, limits and hello policy for the handshake
+type ServerConfig struct {
+	PSK      []byte                        // pre-shared key; authentication is required if non-empty
+	Timeout  time.Duration                 // bound on each packet read/write (if the stream supports deadlines)
+	Validate func(*packets.PktHello) error // optional policy check, an error rejects the hello with the matching ack code
+	AuthWait time.Duration                 // bound on the wait for the client's answer to the challenge (Timeout if zero)
+}
+
+// Server performs the server side of the v1 handshake once the hello's header has been read.
+// Rejected hellos and failed authentication are reported to the client with an ack; on success
+// the hello and the authentication method used are returned and the caller sends the final ack.
+func Server(rw io.ReadWriter, bufHeader []byte, cfg ServerConfig) (*packets.PktHello, packets.FloAuth, error) {
+	// read the rest of the hello packet and re-assemble
+	bufHello, err := RecvHello(rw, cfg.Timeout, bufHeader)
+	if err != nil {
+		return nil, packets.AuthNone, fmt.Errorf("failed to read hello packet: %w", err)
+	}
+
+	pktHello, err := packets.UnmarshalHello(bufHello)
+	if err != nil {
+		// the hello arrived but was rejected, let the client know which field was invalid
+		var sessionID ulid.ULID
+		copy(sessionID[:], bufHello[6:22])
+		return nil, packets.AuthNone, rejectHello(rw, cfg.Timeout, sessionID, fmt.Errorf("failed to unmarshal hello packet: %w", err))
+	}
+
+	if cfg.Validate != nil {
+		err = cfg.Validate(pktHello)
+		if err != nil {
+			return nil, packets.AuthNone, rejectHello(rw, cfg.Timeout, pktHello.SessionID, err)
+		}
+	}
+
+	// perform authentication if it is enabled on the server
+	if len(cfg.PSK) == 0 {
+		return pktHello, packets.AuthNone, nil
+	}
+
+	// a client without credentials can't answer a challenge, so reject it outright
+	if pktHello.Flags&packets.FlagNoAuth != 0 {
+		err := SendAck(rw, cfg.Timeout, pktHello.SessionID, packets.AuthHMAC, packets.AckAuthRequired, 0)
+		if err != nil {
+			return nil, packets.AuthHMAC, fmt.Errorf("failed to send auth required ack: %w", err)
+		}
+		return nil, packets.AuthHMAC, protocol.ErrAuthRequired
+	}
+
+	authenticated, err := authenticate(rw, bufHello, pktHello, cfg)
+	if err != nil {
+		return nil, packets.AuthHMAC, fmt.Errorf("authentication failed: %w", err)
+	}
+
+	if !authenticated {
+		err := SendAck(rw, cfg.Timeout, pktHello.SessionID, packets.AuthHMAC, packets.AckAuthFailed, 0)
+		if err != nil {
+			return nil, packets.AuthHMAC, fmt.Errorf("failed to send auth failed ack: %w", err)
+		}
+		return nil, packets.AuthHMAC, protocol.ErrAuthFailed
+	}
+
+	return pktHello, packets.AuthHMAC, nil
+}
+
+// RejectVersion answers a hello of an unsupported version with a minimal v1 ack whose header
+// advertises the version this server speaks
+func RejectVersion(rw io.ReadWriter, timeout time.Duration) error {
+	return SendAck(rw, timeout, ulid.ULID{}, packets.AuthNone, packets.AckInvalidVersion, 0)
+}
+
+// rejectHello sends an ack describing why the hello was rejected and returns the rejection error
+func rejectHello(rw io.ReadWriter, timeout time.Duration, sessionID ulid.ULID, errHello error) error {
+	code := packets.AckCodeForHelloError(errHello)
+	err := SendAck(rw, timeout, sessionID, packets.AuthNone, code, 0)
+	if err != nil {
+		return fmt.Errorf("failed to send hello rejection ack: %w", err)
+	}
+	return fmt.Errorf("rejected hello: %w", errHello)
+}
+
+// rejectAnswer tells the client its answer could not be processed, rather than leaving it to time
+// out, and returns the reason
+func rejectAnswer(rw io.ReadWriter, timeout time.Duration, sessionID ulid.ULID, errAnswer error) error {
+	err := SendAck(rw, timeout, sessionID, packets.AuthHMAC, packets.AckProtocolError, 0)
+	if err != nil {
+		return fmt.Errorf("failed to send protocol error ack: %w", err)
+	}
+	return errAnswer
+}
+
+// authenticate challenges the client and verifies its answer against the hello and PSK
+func authenticate(rw io.ReadWriter, bufHello []byte, pktHello *packets.PktHello, cfg ServerConfig) (bool, error) {
+	// generate server nonce
+	nonceServer, err := utils.NewNonce()
+	if err != nil {
+		return false, fmt.Errorf("failed to generate server nonce: %w", err)
+	}
+
+	pktChallenge, err := packets.NewChallenge(pktHello.SessionID, packets.AuthHMAC, nonceServer)
+	if err != nil {
+		return false, fmt.Errorf("failed to create challenge packet: %w", err)
+	}
+
+	_, err = Send(rw, cfg.Timeout, pktChallenge)
+	if err != nil {
+		return false, fmt.Errorf("failed to send challenge packet: %w", err)
+	}
+	log.Trace().Str("session_id", pktChallenge.SessionID.String()).Msg("Challenge packet sent")
+
+	// the client computes its answer before sending it, so allow for that on top of the network
+	authWait := cfg.AuthWait
+	if authWait == 0 {
+		authWait = cfg.Timeout
+	}
+	header, bufHeader, err := RecvHeader(rw, authWait)
+	if err != nil {
+		return false, fmt.Errorf("failed to read answer packet header: %w", err)
+	}
+
+	if header.Version != protocol.FloVersion1 {
+		return false, rejectAnswer(rw, cfg.Timeout, pktHello.SessionID, fmt.Errorf("%w in answer packet: %d", protocol.ErrUnsupportedVersion, header.Version))
+	}
+
+	if header.Type != packets.TypeAnswer {
+		return false, rejectAnswer(rw, cfg.Timeout, pktHello.SessionID, fmt.Errorf("%w: expected answer, got %s", protocol.ErrIncorrectType, packets.PacketTypeToString(header.Type)))
+	}
+
+	// a client that puts the hash's length on the wire says so in its hello; otherwise the answer's
+	// size follows from the hash the challenged method produces
+	prefixed := pktHello.Flags&packets.FlagAnswerLength != 0
+	bufAnswer, err := RecvAnswer(rw, cfg.Timeout, bufHeader, prefixed, pktChallenge.AuthMethod)
+	if err != nil {
+		return false, fmt.Errorf("failed to read answer packet: %w", err)
+	}
+
+	unmarshal := packets.UnmarshalAnswer
+	if prefixed {
+		unmarshal = packets.UnmarshalAnswerPrefixed
+	}
+	pktAnswer, err := unmarshal(bufAnswer)
+	if err != nil {
+		return false, rejectAnswer(rw, cfg.Timeout, pktHello.SessionID, fmt.Errorf("failed to unmarshal answer packet: %w", err))
+	}
+
+	// a hash of the wrong length for the challenged method is malformed, not merely incorrect
+	err = packets.ValidateAuthHash(pktChallenge.AuthMethod, pktAnswer.AuthHash)
+	if err != nil {
+		return false, rejectAnswer(rw, cfg.Timeout, pktHello.SessionID, fmt.Errorf("malformed answer packet: %w", err))
+	}
+
+	// verify the expected auth hash
+	verified := packets.VerifyAuthHash(bufHello, nonceServer, cfg.PSK, pktAnswer.AuthHash)
+	if !verified {
+		log.Warn().Str("session_id", pktChallenge.SessionID.String()).Msg("Authentication failed: invalid auth hash")
+	} else {
+		log.Trace().Str("session_id", pktChallenge.SessionID.String()).Msg("Client authenticated successfully")
+	}
+
+	return verified, nil
+}
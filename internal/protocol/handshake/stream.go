@@ -0,0 +1,256 @@
+package handshake
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/capture"
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// deadliner is implemented by streams that can bound individual reads and writes
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// ConnStream adapts a connection and its buffered reader/writer into a handshake stream, so
+// each packet exchange is bounded by a deadline while the buffered reader stays usable afterwards
+type ConnStream struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+	capture *capture.Writer // records the packets exchanged if non-nil
+}
+
+func NewConnStream(conn net.Conn, r *bufio.Reader, w *bufio.Writer) *ConnStream {
+	return &ConnStream{conn: conn, r: r, w: w}
+}
+
+// SetCapture records every packet subsequently sent or received over the stream by this package
+// to the capture (none if nil)
+func (s *ConnStream) SetCapture(c *capture.Writer) {
+	s.capture = c
+}
+
+func (s *ConnStream) record(dir capture.Direction, pkt []byte) {
+	if s.capture == nil {
+		return
+	}
+	if err := s.capture.Record(dir, pkt); err != nil {
+		log.Debug().Err(err).Msg("Failed to capture packet")
+	}
+}
+
+func (s *ConnStream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
+
+func (s *ConnStream) Peek(n int) ([]byte, error) {
+	return s.r.Peek(n)
+}
+
+func (s *ConnStream) Discard(n int) (int, error) {
+	return s.r.Discard(n)
+}
+
+func (s *ConnStream) Buffered() int {
+	return s.r.Buffered()
+}
+
+func (s *ConnStream) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *ConnStream) Flush() error {
+	return s.w.Flush()
+}
+
+func (s *ConnStream) SetReadDeadline(t time.Time) error {
+	return s.conn.SetReadDeadline(t)
+}
+
+func (s *ConnStream) SetWriteDeadline(t time.Time) error {
+	return s.conn.SetWriteDeadline(t)
+}
+
+// recorder is implemented by streams that capture the packets exchanged over them
+type recorder interface {
+	record(dir capture.Direction, pkt []byte)
+}
+
+func record(rw io.ReadWriter, dir capture.Direction, pkt []byte) {
+	if r, ok := rw.(recorder); ok {
+		r.record(dir, pkt)
+	}
+}
+
+func setReadDeadline(rw io.ReadWriter, timeout time.Duration) {
+	if d, ok := rw.(deadliner); ok && timeout > 0 {
+		d.SetReadDeadline(time.Now().Add(timeout))
+	}
+}
+
+func setWriteDeadline(rw io.ReadWriter, timeout time.Duration) {
+	if d, ok := rw.(deadliner); ok && timeout > 0 {
+		d.SetWriteDeadline(time.Now().Add(timeout))
+	}
+}
+
+// RecvHeader reads and unmarshals a packet header from the stream
+func RecvHeader(rw io.ReadWriter, timeout time.Duration) (*protocol.Header, []byte, error) {
+	bufHeader, err := utils.ReadExactTimeout(rw, protocol.HeaderSize, timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read packet header: %w", err)
+	}
+
+	header, err := protocol.UnmarshalHeader(bufHeader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal packet header: %w", err)
+	}
+
+	return header, bufHeader, nil
+}
+
+// RecvBody reads the remainder of a packet of the given total size and re-assembles it with its header
+func RecvBody(rw io.ReadWriter, timeout time.Duration, bufHeader []byte, size int) ([]byte, error) {
+	bufBody, err := utils.ReadExactTimeout(rw, size-protocol.HeaderSize, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, bufHeader...)
+	buf = append(buf, bufBody...)
+	record(rw, capture.Received, buf)
+	return buf, nil
+}
+
+// extension describes the block a packet's base layout may be followed by: whether the base flags
+// one, the size of its length prefix, and how the prefix is read into the block's length
+type extension struct {
+	present    func(base []byte) bool
+	prefixSize int
+	length     func(prefix []byte) (int, error)
+}
+
+var (
+	helloExtension = extension{packets.HelloExtended, packets.HelloExtLenSize, packets.HelloExtLen}
+	ackExtension   = extension{packets.AckExtended, packets.AckExtLenSize, packets.AckExtLen}
+
+	// the hello, not the answer itself, says whether the answer is prefixed
+	answerPrefix = extension{func([]byte) bool { return true }, 1, packets.AnswerHashLen}
+)
+
+// recvExtended reads the remainder of a packet with the given base size, followed by its extension
+// block if the base flags one, and re-assembles it with its header as one captured packet
+func recvExtended(rw io.ReadWriter, timeout time.Duration, bufHeader []byte, size int, ext extension) ([]byte, error) {
+	bufBody, err := utils.ReadExactTimeout(rw, size-protocol.HeaderSize, timeout)
+	if err != nil {
+		return nil, err
+	}
+	buf := append(append(make([]byte, 0, size), bufHeader...), bufBody...)
+
+	if ext.present(buf) {
+		prefix, err := utils.ReadExactTimeout(rw, ext.prefixSize, timeout)
+		if err != nil {
+			return nil, err
+		}
+		n, err := ext.length(prefix)
+		if err != nil {
+			return nil, err
+		}
+		block, err := utils.ReadExactTimeout(rw, n, timeout)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(append(buf, prefix...), block...)
+	}
+
+	record(rw, capture.Received, buf)
+	return buf, nil
+}
+
+// RecvHello reads the remainder of a Hello, including its extension block if it has one
+func RecvHello(rw io.ReadWriter, timeout time.Duration, bufHeader []byte) ([]byte, error) {
+	return recvExtended(rw, timeout, bufHeader, packets.PktHelloSize, helloExtension)
+}
+
+// RecvAck reads the remainder of an Ack, including its extension block if it has one
+func RecvAck(rw io.ReadWriter, timeout time.Duration, bufHeader []byte) ([]byte, error) {
+	return recvExtended(rw, timeout, bufHeader, packets.PktAckSize, ackExtension)
+}
+
+// RecvAnswer reads the remainder of an Answer. The prefixed form is read up to the length it
+// gives, the plain one up to the hash size of the challenged method.
+func RecvAnswer(rw io.ReadWriter, timeout time.Duration, bufHeader []byte, prefixed bool, method packets.FloAuth) ([]byte, error) {
+	if prefixed {
+		return recvExtended(rw, timeout, bufHeader, packets.PktAnswerBaseSize, answerPrefix)
+	}
+	return RecvBody(rw, timeout, bufHeader, packets.PktAnswerBaseSize+packets.AuthHashSize(method))
+}
+
+// Send writes a packet to the stream and returns the raw bytes sent
+func Send(rw io.ReadWriter, timeout time.Duration, pkt protocol.Packet) ([]byte, error) {
+	setWriteDeadline(rw, timeout)
+	buf, err := packets.SendPacket(rw, pkt)
+	if err == nil {
+		record(rw, capture.Sent, buf)
+	}
+	return buf, err
+}
+
+// SendAck creates and sends an Ack packet
+func SendAck(rw io.ReadWriter, timeout time.Duration, sessionID [16]byte, auth packets.FloAuth, code packets.FloAckCode, flags packets.FloFlags) error {
+	pktAck, err := packets.NewAck(sessionID, auth, code, flags)
+	if err != nil {
+		return fmt.Errorf("failed to create ack packet: %w", err)
+	}
+
+	_, err = Send(rw, timeout, pktAck)
+	if err != nil {
+		return fmt.Errorf("failed to send ack packet: %w", err)
+	}
+
+	return nil
+}
+
+// SendBusyAck sends a busy ack advertising the server's total and currently free test slots. Pass
+// zero counts for a client whose Hello carried no flags, which reads only the base ack.
+func SendBusyAck(rw io.ReadWriter, timeout time.Duration, sessionID [16]byte, auth packets.FloAuth, slotsTotal, slotsFree uint32) error {
+	pktAck, err := packets.NewAck(sessionID, auth, packets.AckBusy, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create ack packet: %w", err)
+	}
+	pktAck.SlotsTotal = slotsTotal
+	pktAck.SlotsFree = slotsFree
+
+	_, err = Send(rw, timeout, pktAck)
+	if err != nil {
+		return fmt.Errorf("failed to send ack packet: %w", err)
+	}
+
+	return nil
+}
+
+// SendDataAck sends an ok ack for a datagram test, carrying the port of the server's data socket
+func SendDataAck(rw io.ReadWriter, timeout time.Duration, sessionID [16]byte, auth packets.FloAuth, flags packets.FloFlags, port uint16) error {
+	pktAck, err := packets.NewAck(sessionID, auth, packets.AckOK, flags)
+	if err != nil {
+		return fmt.Errorf("failed to create ack packet: %w", err)
+	}
+	pktAck.DataPort = port
+
+	_, err = Send(rw, timeout, pktAck)
+	if err != nil {
+		return fmt.Errorf("failed to send ack packet: %w", err)
+	}
+
+	return nil
+}
@@ -5,19 +5,29 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"slices"
 
 	"github.com/goodieshq/goflo/internal/protocol"
 	"github.com/oklog/ulid/v2"
 )
 
 const (
-	TypeHello     protocol.FloType = 1 // Initiate connection
-	TypeChallenge protocol.FloType = 2 // Server challenge for authentication (if auth enabled)
-	TypeAnswer    protocol.FloType = 3 // Client challenge answer
-	TypeAck       protocol.FloType = 4 // Acknowledgment packet
-	TypeResult    protocol.FloType = 5 // Result packet (for download requests)
+	TypeHello     protocol.FloType = 1  // Initiate connection
+	TypeChallenge protocol.FloType = 2  // Server challenge for authentication (if auth enabled)
+	TypeAnswer    protocol.FloType = 3  // Client challenge answer
+	TypeAck       protocol.FloType = 4  // Acknowledgment packet
+	TypeResult    protocol.FloType = 5  // Result packet (for download requests)
+	TypeEnd       protocol.FloType = 6  // End of data from one side (explicit completion)
+	TypeEndAck    protocol.FloType = 7  // Acknowledges the peer's End (explicit completion)
+	TypePause     protocol.FloType = 8  // Client paused the data phase (pausable tests)
+	TypeResume    protocol.FloType = 9  // Client resumed the data phase (pausable tests)
+	TypeRegister  protocol.FloType = 10 // Client registers its data address (datagram transports)
+	TypePing      protocol.FloType = 11 // Client times a round trip before the data phase
+	TypePong      protocol.FloType = 12 // Server answers a Ping
 )
 
 func PacketTypeToString(t protocol.FloType) string {
@@ -32,6 +42,20 @@ func PacketTypeToString(t protocol.FloType) string {
 		return "ACK"
 	case TypeResult:
 		return "RESULT"
+	case TypeEnd:
+		return "END"
+	case TypeEndAck:
+		return "END_ACK"
+	case TypePause:
+		return "PAUSE"
+	case TypeResume:
+		return "RESUME"
+	case TypeRegister:
+		return "REGISTER"
+	case TypePing:
+		return "PING"
+	case TypePong:
+		return "PONG"
 	default:
 		return "UNKNOWN"
 	}
@@ -45,6 +69,30 @@ const (
 	AuthHMAC FloAuth = 1 // HMAC-based authentication
 )
 
+// AuthHashSize returns the length of the hash an answer carries for the authentication method, or
+// zero for a method the client doesn't answer
+func AuthHashSize(method FloAuth) int {
+	switch method {
+	case AuthHMAC:
+		return sha256.Size
+	default:
+		return 0
+	}
+}
+
+// ValidateAuthHash checks that an answer's hash has the length the challenged method produces, so
+// a malformed answer is rejected as such rather than failing verification like a wrong key would
+func ValidateAuthHash(method FloAuth, hash []byte) error {
+	size := AuthHashSize(method)
+	if size == 0 {
+		return fmt.Errorf("%w: method %d is not answered with a hash", protocol.ErrInvalidAuthHash, method)
+	}
+	if len(hash) != size {
+		return fmt.Errorf("%w: %d bytes where method %d expects %d", protocol.ErrInvalidAuthHash, len(hash), method, size)
+	}
+	return nil
+}
+
 // Transport + optional wrapping protocol to use
 type FloTransport uint8
 
@@ -54,6 +102,90 @@ const (
 	TransportSCTP FloTransport = 3
 )
 
+func TransportToString(t FloTransport) string {
+	switch t {
+	case TransportTCP:
+		return "tcp"
+	case TransportUDP:
+		return "udp"
+	case TransportSCTP:
+		return "sctp"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseTransport parses the string form of a transport as produced by TransportToString
+func ParseTransport(s string) (FloTransport, error) {
+	switch s {
+	case "tcp":
+		return TransportTCP, nil
+	case "udp":
+		return TransportUDP, nil
+	case "sctp":
+		return TransportSCTP, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", protocol.ErrUnsupportedTransport, s)
+	}
+}
+
+// Compatibility matrix of the directions each transport can carry. UDP has no reliable
+// delivery or flow control shared between both ends, so simultaneous bidirectional tests
+// would produce misleading results and are only offered as one-way tests.
+//
+//	Transport | Bidi | Upload | Download
+//	----------+------+--------+---------
+//	TCP       | yes  | yes    | yes
+//	UDP       | no   | yes    | yes
+//	SCTP      | yes  | yes    | yes
+var transportDirections = map[FloTransport][]protocol.FloDir{
+	TransportTCP:  {protocol.DirectionBidi, protocol.DirectionUpload, protocol.DirectionDownload},
+	TransportUDP:  {protocol.DirectionUpload, protocol.DirectionDownload},
+	TransportSCTP: {protocol.DirectionBidi, protocol.DirectionUpload, protocol.DirectionDownload},
+}
+
+// ValidateTransportDirection checks that the requested direction can be carried by the transport
+func ValidateTransportDirection(transport FloTransport, direction protocol.FloDir) error {
+	directions, ok := transportDirections[transport]
+	if !ok {
+		return protocol.ErrUnsupportedTransport
+	}
+	if !slices.Contains(directions, direction) {
+		return protocol.ErrIncompatibleDirection
+	}
+	return nil
+}
+
+// MaxDatagramChunkSize is the largest chunk a datagram transport sends, one chunk per datagram. It
+// is the minimum IPv6 MTU (1280) less the IPv6 and UDP headers, so a datagram fits any path
+// without IP fragmentation, which would turn the loss of one fragment into the loss of the chunk.
+const MaxDatagramChunkSize = 1232
+
+// MaxTransportChunkSize returns the largest chunk size the transport can carry
+func MaxTransportChunkSize(transport FloTransport) uint32 {
+	if transport == TransportUDP {
+		return MaxDatagramChunkSize
+	}
+	return MaxChunkSize
+}
+
+// ValidateTransportChunkSize checks that the chunk size is within the accepted range and fits
+// the transport, explaining the limit if it doesn't
+func ValidateTransportChunkSize(transport FloTransport, chunkSize uint32) error {
+	if chunkSize < MinChunkSize || chunkSize > MaxChunkSize {
+		return fmt.Errorf("%w: %d bytes is outside %d-%d", protocol.ErrInvalidChunkSize, chunkSize, MinChunkSize, MaxChunkSize)
+	}
+	if limit := MaxTransportChunkSize(transport); chunkSize > limit {
+		return fmt.Errorf("%w: %d bytes exceeds the %d byte datagram limit, use a chunk size of at most %d", protocol.ErrInvalidChunkSize, chunkSize, limit, limit)
+	}
+	return nil
+}
+
+// ClampChunkSize returns the chunk size limited to what the transport can carry
+func ClampChunkSize(transport FloTransport, chunkSize uint32) uint32 {
+	return min(chunkSize, MaxTransportChunkSize(transport))
+}
+
 // Security protocol to use (if any)
 type FloSecurity uint8
 
@@ -62,23 +194,73 @@ const (
 	SecurityTLS  FloSecurity = 1
 )
 
+func SecurityToString(s FloSecurity) string {
+	switch s {
+	case SecurityNone:
+		return "none"
+	case SecurityTLS:
+		return "tls"
+	default:
+		return "unknown"
+	}
+}
+
 type FloAckCode uint8
 
 const (
-	AckOK             FloAckCode = 0 // Acknowledgment OK, proceed with test
-	AckInvalidVersion FloAckCode = 1 // Flo protocol version not supported
-	AckInvalidHello   FloAckCode = 2 // Malformed Hello packet
-	AckAuthFailed     FloAckCode = 3 // Authentication failed
-	AckBusy           FloAckCode = 4 // Server is busy / cannot accept new connections
+	AckOK             FloAckCode = 0  // Acknowledgment OK, proceed with test
+	AckInvalidVersion FloAckCode = 1  // Flo protocol version not supported
+	AckInvalidHello   FloAckCode = 2  // Malformed Hello packet
+	AckAuthFailed     FloAckCode = 3  // Authentication failed
+	AckBusy           FloAckCode = 4  // Server is busy / cannot accept new connections
+	AckIncompatible   FloAckCode = 5  // Requested direction is not supported by the transport
+	AckBadTransport   FloAckCode = 6  // Requested transport is not supported
+	AckBadSecurity    FloAckCode = 7  // Requested security type is not supported
+	AckBadDirection   FloAckCode = 8  // Requested direction is not supported
+	AckBadSession     FloAckCode = 9  // Session ID is invalid or too old (possible replay)
+	AckProtocolError  FloAckCode = 10 // Unexpected or malformed packet during the handshake
+	AckAuthRequired   FloAckCode = 11 // Authentication is required but the client has no credentials
+	AckTLSRequired    FloAckCode = 12 // The server requires TLS but the client connected without it
 )
 
-// Flags for additional options (placeholder for future use)
+// AckCodeForHelloError maps a Hello validation error to the Ack code reporting it to the client
+func AckCodeForHelloError(err error) FloAckCode {
+	switch {
+	case errors.Is(err, protocol.ErrUnsupportedTransport):
+		return AckBadTransport
+	case errors.Is(err, protocol.ErrUnsupportedSecurity):
+		return AckBadSecurity
+	case errors.Is(err, protocol.ErrTLSRequired):
+		return AckTLSRequired
+	case errors.Is(err, protocol.ErrUnsupportedDirection):
+		return AckBadDirection
+	case errors.Is(err, protocol.ErrIncompatibleDirection):
+		return AckIncompatible
+	case errors.Is(err, protocol.ErrInvalidSessionID):
+		return AckBadSession
+	default:
+		return AckInvalidHello
+	}
+}
+
+// Flags for additional options, set by the client in the Hello and echoed by the server in the Ack if accepted
 type FloFlags uint16
 
 const (
-// FloFlags reserved for future use
+	FlagResult         FloFlags = 1 << 0 // Server sends a Result packet after the data phase
+	FlagResultDuration FloFlags = 1 << 1 // Result includes the server's measured duration (requires FlagResult)
+	FlagExplicitEnd    FloFlags = 1 << 2 // Complete with End/EndAck packets instead of a TCP half-close
+	FlagPause          FloFlags = 1 << 3 // Client may pause and resume the data phase with Pause/Resume packets
+	FlagResultWindow   FloFlags = 1 << 4 // Result also includes the server's warmup, locating its measured window (requires FlagResultDuration)
+	FlagNoAuth         FloFlags = 1 << 5 // Client has no credentials, so a server requiring authentication rejects it rather than challenging
+	FlagPing           FloFlags = 1 << 6 // Client times round trips with Ping/Pong packets between the Ack and the data phase
+	FlagHelloExt       FloFlags = 1 << 7 // Hello carries the extension block after its base layout (Hello only, never echoed)
+	FlagAnswerLength   FloFlags = 1 << 8 // Answer carries its hash's length before the hash (Hello only, never echoed)
 )
 
+// FlagsKnown is the set of flags understood by this implementation
+const FlagsKnown = FlagResult | FlagResultDuration | FlagExplicitEnd | FlagPause | FlagResultWindow | FlagNoAuth | FlagPing | FlagHelloExt | FlagAnswerLength
+
 var le = binary.LittleEndian
 
 /* AuthHash is computed as HMAC_SHA256(HELLO_PACKET || NONCE_SERVER, SHARED_SECRET) */
@@ -104,12 +286,46 @@ func ComputeAuthHashPacket(helloPkt *PktHello, nonceServer [16]byte, psk []byte)
 }
 
 // Verify the received authentication hash against expected value
-func VerifyAuthHash(helloPktBytes []byte, nonceServer [16]byte, psk []byte, receivedHash [32]byte) bool {
+func VerifyAuthHash(helloPktBytes []byte, nonceServer [16]byte, psk []byte, receivedHash []byte) bool {
 	expectedHash := ComputeAuthHash(helloPktBytes, nonceServer, psk)
-	return hmac.Equal(expectedHash[:], receivedHash[:])
+	return hmac.Equal(expectedHash[:], receivedHash)
 }
 
-func SendPacket(w *bufio.Writer, pkt protocol.Packet) ([]byte, error) {
+// Unmarshal parses a complete packet of any type that has its own layout, as recorded in a
+// capture, returning the matching Pkt* struct
+func Unmarshal(data []byte) (protocol.Packet, error) {
+	header, err := protocol.UnmarshalHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch header.Type {
+	case TypeHello:
+		return UnmarshalHello(data)
+	case TypeChallenge:
+		return UnmarshalChallenge(data)
+	case TypeAnswer:
+		// a capture doesn't say which form the hello negotiated, so the length prefix is taken to be
+		// present when it matches the rest of the packet
+		if len(data) > PktAnswerPrefixedBaseSize && int(data[PktAnswerBaseSize]) == len(data)-PktAnswerPrefixedBaseSize {
+			return UnmarshalAnswerPrefixed(data)
+		}
+		return UnmarshalAnswer(data)
+	case TypeAck:
+		return UnmarshalAck(data)
+	case TypeResult:
+		return UnmarshalResult(data)
+	case TypeEnd, TypeEndAck:
+		return UnmarshalEnd(data)
+	case TypePing, TypePong:
+		return UnmarshalPing(data)
+	default:
+		return nil, fmt.Errorf("%w: %s", protocol.ErrUnsupportedType, PacketTypeToString(header.Type))
+	}
+}
+
+// SendPacket marshals and writes a packet, flushing it if the writer is buffered
+func SendPacket(w io.Writer, pkt protocol.Packet) ([]byte, error) {
 	// marshal the packet into bytes
 	buf, err := pkt.Marshal()
 	if err != nil {
@@ -121,9 +337,11 @@ func SendPacket(w *bufio.Writer, pkt protocol.Packet) ([]byte, error) {
 		return nil, fmt.Errorf("failed to send packet: %w", err)
 	}
 
-	err = w.Flush()
-	if err != nil {
-		return nil, fmt.Errorf("failed to flush packet: %w", err)
+	if f, ok := w.(interface{ Flush() error }); ok {
+		err = f.Flush()
+		if err != nil {
+			return nil, fmt.Errorf("failed to flush packet: %w", err)
+		}
 	}
 
 	return buf, nil
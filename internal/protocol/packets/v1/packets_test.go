@@ -0,0 +1,128 @@
+package packets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"testing"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/oklog/ulid/v2"
+)
+
+func TestValidateTransportDirection(t *testing.T) {
+	tests := []struct {
+		transport FloTransport
+		direction protocol.FloDir
+		want      error
+	}{
+		{TransportTCP, protocol.DirectionBidi, nil},
+		{TransportTCP, protocol.DirectionUpload, nil},
+		{TransportTCP, protocol.DirectionDownload, nil},
+		{TransportUDP, protocol.DirectionBidi, protocol.ErrIncompatibleDirection},
+		{TransportUDP, protocol.DirectionUpload, nil},
+		{TransportUDP, protocol.DirectionDownload, nil},
+		{TransportSCTP, protocol.DirectionBidi, nil},
+		{TransportSCTP, protocol.DirectionUpload, nil},
+		{TransportSCTP, protocol.DirectionDownload, nil},
+		{TransportTCP, protocol.FloDir(3), protocol.ErrIncompatibleDirection},
+		{TransportUDP, protocol.FloDir(255), protocol.ErrIncompatibleDirection},
+		{FloTransport(0), protocol.DirectionUpload, protocol.ErrUnsupportedTransport},
+		{FloTransport(4), protocol.DirectionBidi, protocol.ErrUnsupportedTransport},
+	}
+	for _, tt := range tests {
+		name := TransportToString(tt.transport) + "/" + protocol.DirectionToString(tt.direction)
+		t.Run(name, func(t *testing.T) {
+			err := ValidateTransportDirection(tt.transport, tt.direction)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("ValidateTransportDirection(%d, %d) = %v, want %v", tt.transport, tt.direction, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAuthHash(t *testing.T) {
+	tests := []struct {
+		name   string
+		method FloAuth
+		size   int
+		want   error
+	}{
+		{"hmac", AuthHMAC, sha256.Size, nil},
+		{"hmac short", AuthHMAC, sha256.Size - 1, protocol.ErrInvalidAuthHash},
+		{"hmac long", AuthHMAC, sha512.Size, protocol.ErrInvalidAuthHash},
+		{"hmac empty", AuthHMAC, 0, protocol.ErrInvalidAuthHash},
+		{"none", AuthNone, 0, protocol.ErrInvalidAuthHash},
+		{"none with hash", AuthNone, sha256.Size, protocol.ErrInvalidAuthHash},
+		{"unknown", FloAuth(2), sha256.Size, protocol.ErrInvalidAuthHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAuthHash(tt.method, make([]byte, tt.size))
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Errorf("ValidateAuthHash(%d, %d bytes) = %v, want %v", tt.method, tt.size, err, tt.want)
+			}
+		})
+	}
+}
+
+// The hash's length comes from the wire rather than being fixed by the packet, so a truncated
+// answer reaches ValidateAuthHash instead of being padded with zeroes. The prefixed form carries
+// the length itself, while the plain one keeps the layout of clients predating it.
+func TestAnswerHashLength(t *testing.T) {
+	for _, form := range []struct {
+		name      string
+		flags     FloFlags
+		base      int
+		unmarshal func([]byte) (*PktAnswer, error)
+	}{
+		{"plain", 0, PktAnswerBaseSize, UnmarshalAnswer},
+		{"prefixed", FlagAnswerLength, PktAnswerPrefixedBaseSize, UnmarshalAnswerPrefixed},
+	} {
+		t.Run(form.name, func(t *testing.T) {
+			for _, size := range []int{0, sha256.Size - 1, sha256.Size, sha512.Size} {
+				pkt, err := NewAnswer(ulid.Make(), bytes.Repeat([]byte{0xAB}, size), form.flags)
+				if err != nil {
+					t.Fatalf("NewAnswer: %v", err)
+				}
+				buf, err := pkt.Marshal()
+				if err != nil {
+					t.Fatalf("Marshal: %v", err)
+				}
+				if len(buf) != form.base+size {
+					t.Errorf("%d byte hash marshalled to %d bytes, want %d", size, len(buf), form.base+size)
+				}
+				got, err := form.unmarshal(buf)
+				if err != nil {
+					t.Fatalf("unmarshal: %v", err)
+				}
+				if !bytes.Equal(got.AuthHash, pkt.AuthHash) {
+					t.Errorf("%d byte hash unmarshalled as %d bytes", size, len(got.AuthHash))
+				}
+				err = ValidateAuthHash(AuthHMAC, got.AuthHash)
+				if valid := size == AuthHashSize(AuthHMAC); valid != (err == nil) {
+					t.Errorf("ValidateAuthHash on a %d byte hash = %v", size, err)
+				}
+			}
+
+			if _, err := form.unmarshal(make([]byte, form.base-1)); !errors.Is(err, protocol.ErrInvalidPacketSize) {
+				t.Errorf("unmarshal on a truncated header = %v, want %v", err, protocol.ErrInvalidPacketSize)
+			}
+		})
+	}
+
+	// a length byte disagreeing with the hash that follows is malformed
+	pkt, _ := NewAnswer(ulid.Make(), make([]byte, sha256.Size), FlagAnswerLength)
+	buf, _ := pkt.Marshal()
+	buf[PktAnswerBaseSize]--
+	if _, err := UnmarshalAnswerPrefixed(buf); !errors.Is(err, protocol.ErrInvalidPacketSize) {
+		t.Errorf("UnmarshalAnswerPrefixed with a wrong length byte = %v, want %v", err, protocol.ErrInvalidPacketSize)
+	}
+
+	// the plain HMAC answer keeps its original size for servers predating the prefix
+	pkt, _ = NewAnswer(ulid.Make(), make([]byte, sha256.Size), 0)
+	if buf, _ := pkt.Marshal(); len(buf) != PktAnswerSize {
+		t.Errorf("plain HMAC answer is %d bytes, want %d", len(buf), PktAnswerSize)
+	}
+}
@@ -1,21 +1,88 @@
 package packets
 
 import (
+	"fmt"
+
 	"github.com/goodieshq/goflo/internal/protocol"
 	"github.com/oklog/ulid/v2"
 )
 
+// Ack packet sent by the server to accept or reject a test.
+//
+// The base layout ends with the code. Fields added since then travel in an extension block that
+// follows it when the high bit of the Auth byte is set: a one byte length, then the fields from
+// Flags on. The server only extends the Ack for a client whose Hello carried flags, as such a
+// client reads the block; any other client gets the base layout it expects. Fields appended to
+// the block later are ignored by clients that don't know them.
+//
+// An Ack with code AckInvalidVersion is sent in response to a Hello of any version the server
+// does not speak, before that Hello can be parsed. It therefore has the base layout and a zero
+// session ID, and its header version is the highest protocol version the server supports so the
+// client can downgrade.
+//
+// An Ack with code AckBusy carries the server's total test slots and how many were free when it
+// was sent, letting the client judge whether retrying soon is worthwhile. Both are zero otherwise.
+//
+// An Ack with code AckOK for a datagram transport carries the port of the server's data socket,
+// which the client sends its Register datagram to. It is zero for stream transports, whose data
+// phase continues on the handshake connection.
 type PktAck struct {
 	protocol.Header            // Common packet header
 	SessionID       ulid.ULID  // Unique session identifier
 	Auth            FloAuth    // Authentication type used
 	Code            FloAckCode // Acknowledgment code (OK, Error, etc.)
+	Flags           FloFlags   // Requested flags the server accepted (extension)
+	SlotsTotal      uint32     // Concurrent tests the server allows (busy acks only, extension)
+	SlotsFree       uint32     // Test slots free when the ack was sent (busy acks only, extension)
+	DataPort        uint16     // Port of the data socket (datagram transports only, extension)
+}
+
+const (
+	PktAckSize       = protocol.HeaderSize + 16 + 1 + 1 // base layout
+	AckExtLenSize    = 1                                // length prefix of the extension block
+	AckExtSize       = 2 + 4 + 4 + 2                    // extension fields this implementation knows
+	pktAckExtendSize = PktAckSize + AckExtLenSize + AckExtSize
+
+	ackExtended = 0x80 // set in the Auth byte when the extension block follows
+)
+
+// AckExtended reports whether the Ack whose base layout is given carries an extension block
+func AckExtended(base []byte) bool {
+	return len(base) >= PktAckSize && base[22]&ackExtended != 0
 }
 
-const PktAckSize = protocol.HeaderSize + 16 + 1 + 1
+// AckExtLen returns the length of the extension block from its prefix, checking it holds the known fields
+func AckExtLen(prefix []byte) (int, error) {
+	n := int(prefix[0])
+	if n < AckExtSize {
+		return 0, fmt.Errorf("%w: ack extension of %d bytes is shorter than %d", protocol.ErrInvalidPacketSize, n, AckExtSize)
+	}
+	return n, nil
+}
+
+// extended reports whether any extension field is set, so the ack must carry the block
+func (p *PktAck) extended() bool {
+	return p.Flags != 0 || p.SlotsTotal != 0 || p.SlotsFree != 0 || p.DataPort != 0
+}
 
 func UnmarshalAck(data []byte) (*PktAck, error) {
-	if len(data) != PktAckSize {
+	if len(data) < PktAckSize {
+		return nil, protocol.ErrInvalidPacketSize
+	}
+	var ext []byte
+	if AckExtended(data) {
+		if len(data) < PktAckSize+AckExtLenSize {
+			return nil, protocol.ErrInvalidPacketSize
+		}
+		n, err := AckExtLen(data[PktAckSize:])
+		if err != nil {
+			return nil, err
+		}
+		ext = data[PktAckSize+AckExtLenSize:]
+		if len(ext) != n {
+			return nil, protocol.ErrInvalidPacketSize
+		}
+	} else if len(data) != PktAckSize {
 		return nil, protocol.ErrInvalidPacketSize
 	}
 
@@ -31,14 +98,24 @@ func UnmarshalAck(data []byte) (*PktAck, error) {
 	var pkt PktAck
 	pkt.Header = *header
 	copy(pkt.SessionID[:], data[6:22])
-	pkt.Auth = FloAuth(data[22])
+	pkt.Auth = FloAuth(data[22] &^ ackExtended)
 	pkt.Code = FloAckCode(data[23])
+	if ext != nil {
+		pkt.Flags = FloFlags(le.Uint16(ext[0:2]))
+		pkt.SlotsTotal = le.Uint32(ext[2:6])
+		pkt.SlotsFree = le.Uint32(ext[6:10])
+		pkt.DataPort = le.Uint16(ext[10:12])
+	}
 
 	return &pkt, nil
 }
 
 func (p *PktAck) Marshal() ([]byte, error) {
-	buf := make([]byte, PktAckSize)
+	size := PktAckSize
+	if p.extended() {
+		size = pktAckExtendSize
+	}
+	buf := make([]byte, size)
 
 	if p.Header.Magic != [4]byte{'F', 'L', 'O', 0x00} {
 		return nil, protocol.ErrInvalidMagic
@@ -50,10 +127,18 @@ func (p *PktAck) Marshal() ([]byte, error) {
 	copy(buf[6:22], p.SessionID[:])
 	buf[22] = byte(p.Auth)
 	buf[23] = byte(p.Code)
+	if p.extended() {
+		buf[22] |= ackExtended
+		buf[24] = AckExtSize
+		le.PutUint16(buf[25:27], uint16(p.Flags))
+		le.PutUint32(buf[27:31], p.SlotsTotal)
+		le.PutUint32(buf[31:35], p.SlotsFree)
+		le.PutUint16(buf[35:37], p.DataPort)
+	}
 	return buf, nil
 }
 
-func NewAck(sessionID ulid.ULID, auth FloAuth, code FloAckCode) (*PktAck, error) {
+func NewAck(sessionID ulid.ULID, auth FloAuth, code FloAckCode, flags FloFlags) (*PktAck, error) {
 	var pkt PktAck
 
 	pkt.Header = createHeader(TypeAck)
@@ -61,5 +146,6 @@ func NewAck(sessionID ulid.ULID, auth FloAuth, code FloAckCode) (*PktAck, error)
 	copy(pkt.SessionID[:], sessionID[:])
 	pkt.Auth = auth
 	pkt.Code = code
+	pkt.Flags = flags
 	return &pkt, nil
 }
@@ -0,0 +1,107 @@
+package packets
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/oklog/ulid/v2"
+)
+
+func TestAckRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       FloAckCode
+		flags      FloFlags
+		slotsTotal uint32
+		slotsFree  uint32
+		dataPort   uint16
+		size       int
+	}{
+		{"base", AckOK, 0, 0, 0, 0, PktAckSize},
+		{"flags", AckOK, FlagResult | FlagPing, 0, 0, 0, pktAckExtendSize},
+		{"busy", AckBusy, 0, 8, 0, 0, pktAckExtendSize},
+		{"busy free", AckBusy, 0, 8, 3, 0, pktAckExtendSize},
+		{"busy max", AckBusy, 0, ^uint32(0), ^uint32(0) - 1, 0, pktAckExtendSize},
+		{"data port", AckOK, FlagResult, 0, 0, 50123, pktAckExtendSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt, err := NewAck(ulid.Make(), AuthHMAC, tt.code, tt.flags)
+			if err != nil {
+				t.Fatalf("NewAck: %v", err)
+			}
+			pkt.SlotsTotal, pkt.SlotsFree, pkt.DataPort = tt.slotsTotal, tt.slotsFree, tt.dataPort
+
+			buf, err := pkt.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if len(buf) != tt.size {
+				t.Fatalf("marshalled %d bytes, want %d", len(buf), tt.size)
+			}
+
+			got, err := UnmarshalAck(buf)
+			if err != nil {
+				t.Fatalf("UnmarshalAck: %v", err)
+			}
+			if *got != *pkt {
+				t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", *got, *pkt)
+			}
+		})
+	}
+}
+
+// Fields appended to the extension by a newer server are skipped
+func TestAckExtensionForwardCompatible(t *testing.T) {
+	pkt, err := NewAck(ulid.Make(), AuthNone, AckBusy, 0)
+	if err != nil {
+		t.Fatalf("NewAck: %v", err)
+	}
+	pkt.SlotsTotal, pkt.SlotsFree = 4, 1
+	buf, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	longer := append(append([]byte{}, buf...), 0xaa, 0xbb)
+	longer[PktAckSize] = AckExtSize + 2
+	got, err := UnmarshalAck(longer)
+	if err != nil {
+		t.Fatalf("UnmarshalAck: %v", err)
+	}
+	if got.Auth != AuthNone || got.SlotsTotal != 4 || got.SlotsFree != 1 {
+		t.Fatalf("unexpected fields: %+v", *got)
+	}
+}
+
+func TestAckExtensionMalformed(t *testing.T) {
+	pkt, err := NewAck(ulid.Make(), AuthHMAC, AckBusy, 0)
+	if err != nil {
+		t.Fatalf("NewAck: %v", err)
+	}
+	pkt.SlotsTotal, pkt.SlotsFree = 2, 0
+	buf, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mangle func([]byte) []byte
+	}{
+		{"truncated block", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"missing prefix", func(b []byte) []byte { return b[:PktAckSize] }},
+		{"short length", func(b []byte) []byte { b[PktAckSize] = AckExtSize - 1; return b }},
+		{"block without marker", func(b []byte) []byte { b[22] &^= ackExtended; return b }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.mangle(append([]byte{}, buf...))
+			_, err := UnmarshalAck(data)
+			if !errors.Is(err, protocol.ErrInvalidPacketSize) {
+				t.Fatalf("UnmarshalAck error = %v, want %v", err, protocol.ErrInvalidPacketSize)
+			}
+		})
+	}
+}
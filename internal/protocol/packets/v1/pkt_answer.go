@@ -1,24 +1,57 @@
 package packets
 
 import (
+	"crypto/sha256"
+	"fmt"
+
 	"github.com/goodieshq/goflo/internal/protocol"
 	"github.com/oklog/ulid/v2"
 )
 
-// Answer packet sent by the client in response to a challenge
+// Answer packet sent by the client in response to a challenge.
+//
+// When the client's hello carries FlagAnswerLength, the prefixed form is sent, putting the hash's
+// length in a byte before it so the server reads the hash the client actually sent, of whatever
+// length, rather than as many bytes as the challenged method should produce.
 type PktAnswer struct {
 	protocol.Header           // Common packet header
 	SessionID       ulid.ULID // Unique session identifier
-	AuthHash        [32]byte  // SHA-256 HMAC of the challenge nonces
+	AuthHash        []byte    // hash for the challenged method, see AuthHashSize
+	prefixed        bool
 }
 
-const PktAnswerSize = protocol.HeaderSize + 16 + 32
+const (
+	PktAnswerBaseSize         = protocol.HeaderSize + 16        // answer without its hash
+	PktAnswerSize             = PktAnswerBaseSize + sha256.Size // answer to an HMAC challenge
+	PktAnswerPrefixedBaseSize = PktAnswerBaseSize + 1           // prefixed answer without its hash
+	MaxAuthHashSize           = 0xff                            // longest hash the prefixed form can carry
+)
+
+// AnswerHashLen returns the length of the hash from the prefixed form's length byte. Any length
+// the byte holds is read, so a wrong one is rejected by ValidateAuthHash rather than here.
+func AnswerHashLen(prefix []byte) (int, error) {
+	return int(prefix[0]), nil
+}
 
+// UnmarshalAnswer parses an answer, taking everything after the session ID as the hash. Its
+// length depends on the challenged method, so it is checked with ValidateAuthHash.
 func UnmarshalAnswer(data []byte) (*PktAnswer, error) {
-	if len(data) != PktAnswerSize {
+	if len(data) < PktAnswerBaseSize {
 		return nil, protocol.ErrInvalidPacketSize
 	}
+	return unmarshalAnswer(data, data[PktAnswerBaseSize:], false)
+}
 
+// UnmarshalAnswerPrefixed parses an answer in the prefixed form, whose length byte must match the
+// hash that follows it. The hash's length is still checked with ValidateAuthHash.
+func UnmarshalAnswerPrefixed(data []byte) (*PktAnswer, error) {
+	if len(data) < PktAnswerPrefixedBaseSize || int(data[PktAnswerBaseSize]) != len(data)-PktAnswerPrefixedBaseSize {
+		return nil, protocol.ErrInvalidPacketSize
+	}
+	return unmarshalAnswer(data, data[PktAnswerPrefixedBaseSize:], true)
+}
+
+func unmarshalAnswer(data, hash []byte, prefixed bool) (*PktAnswer, error) {
 	header, err := protocol.UnmarshalHeader(data[0:protocol.HeaderSize])
 	if err != nil {
 		return nil, err
@@ -31,32 +64,46 @@ func UnmarshalAnswer(data []byte) (*PktAnswer, error) {
 	var pkt PktAnswer
 	pkt.Header = *header
 	copy(pkt.SessionID[:], data[6:22])
-	copy(pkt.AuthHash[:], data[22:54])
+	pkt.AuthHash = append([]byte(nil), hash...)
+	pkt.prefixed = prefixed
 
 	return &pkt, nil
 }
 
 func (p *PktAnswer) Marshal() ([]byte, error) {
-	buf := make([]byte, PktAnswerSize)
-
 	if p.Header.Magic != [4]byte{'F', 'L', 'O', 0x00} {
 		return nil, protocol.ErrInvalidMagic
 	}
 
+	base := PktAnswerBaseSize
+	if p.prefixed {
+		if len(p.AuthHash) > MaxAuthHashSize {
+			return nil, fmt.Errorf("%w: %d byte hash is longer than %d", protocol.ErrInvalidAuthHash, len(p.AuthHash), MaxAuthHashSize)
+		}
+		base = PktAnswerPrefixedBaseSize
+	}
+	buf := make([]byte, base+len(p.AuthHash))
+
 	copy(buf[0:4], p.Header.Magic[:])
 	buf[4] = byte(p.Header.Version)
 	buf[5] = byte(p.Header.Type)
 	copy(buf[6:22], p.SessionID[:])
-	copy(buf[22:54], p.AuthHash[:])
+	if p.prefixed {
+		buf[PktAnswerBaseSize] = byte(len(p.AuthHash))
+	}
+	copy(buf[base:], p.AuthHash)
 	return buf, nil
 }
 
-func NewAnswer(sessionID ulid.ULID, authHash [32]byte) (*PktAnswer, error) {
+// NewAnswer creates an answer carrying the hash, in the prefixed form if the hello's flags
+// requested it
+func NewAnswer(sessionID ulid.ULID, authHash []byte, flags FloFlags) (*PktAnswer, error) {
 	var pkt PktAnswer
 
 	pkt.Header = createHeader(TypeAnswer)
 	copy(pkt.SessionID[:], sessionID[:])
-	copy(pkt.AuthHash[:], authHash[:])
+	pkt.AuthHash = append([]byte(nil), authHash...)
+	pkt.prefixed = flags&FlagAnswerLength != 0
 
 	return &pkt, nil
 }
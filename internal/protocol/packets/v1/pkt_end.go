@@ -0,0 +1,74 @@
+package packets
+
+import (
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/oklog/ulid/v2"
+)
+
+// End packet marking the end of one side's data when FlagExplicitEnd is negotiated. The same
+// layout with TypeEndAck acknowledges the peer's End. Both follow unframed data, so receivers
+// locate them by their marker rather than reading them at a known offset.
+type PktEnd struct {
+	protocol.Header           // Common packet header (TypeEnd or TypeEndAck)
+	SessionID       ulid.ULID // Unique session identifier
+}
+
+const PktEndSize = protocol.HeaderSize + 16
+
+func UnmarshalEnd(data []byte) (*PktEnd, error) {
+	if len(data) != PktEndSize {
+		return nil, protocol.ErrInvalidPacketSize
+	}
+
+	header, err := protocol.UnmarshalHeader(data[0:protocol.HeaderSize])
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Type != TypeEnd && header.Type != TypeEndAck {
+		return nil, protocol.ErrIncorrectType
+	}
+
+	var pkt PktEnd
+	pkt.Header = *header
+	copy(pkt.SessionID[:], data[6:22])
+
+	return &pkt, nil
+}
+
+func (p *PktEnd) Marshal() ([]byte, error) {
+	buf := make([]byte, PktEndSize)
+
+	if p.Header.Magic != [4]byte{'F', 'L', 'O', 0x00} {
+		return nil, protocol.ErrInvalidMagic
+	}
+
+	copy(buf[0:4], p.Header.Magic[:])
+	buf[4] = byte(p.Header.Version)
+	buf[5] = byte(p.Header.Type)
+	copy(buf[6:22], p.SessionID[:])
+	return buf, nil
+}
+
+func NewEnd(sessionID ulid.ULID) (*PktEnd, error) {
+	var pkt PktEnd
+
+	pkt.Header = createHeader(TypeEnd)
+	copy(pkt.SessionID[:], sessionID[:])
+
+	return &pkt, nil
+}
+
+func NewEndAck(sessionID ulid.ULID) (*PktEnd, error) {
+	var pkt PktEnd
+
+	pkt.Header = createHeader(TypeEndAck)
+	copy(pkt.SessionID[:], sessionID[:])
+
+	return &pkt, nil
+}
+
+// EndMarker returns the leading bytes of an End (or EndAck, by type) packet for the session
+func EndMarker(t protocol.FloType, sessionID ulid.ULID) []byte {
+	return packetMarker(t, sessionID)
+}
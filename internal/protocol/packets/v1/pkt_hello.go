@@ -1,6 +1,7 @@
 package packets
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/goodieshq/goflo/internal/protocol"
@@ -8,24 +9,110 @@ import (
 	"github.com/oklog/ulid/v2"
 )
 
-// Hello packet sent by the client to initiate a connection
+// Hello packet sent by the client to initiate a connection.
+//
+// The base layout ends with the client nonce. Parameters added since then travel in an extension
+// block that follows it when FlagHelloExt is set: a little-endian uint16 length, then the fields
+// from PrimeBytes on. A Hello without the block is read with those fields at their defaults, so
+// clients that predate it keep working, and a client only sends it when a field isn't the
+// default, so servers that predate it keep accepting plain tests. Fields appended to the block
+// later are ignored by servers that don't know them.
 type PktHello struct {
 	protocol.Header                 // Common packet header
 	SessionID       ulid.ULID       // Unique session identifier
 	Transport       FloTransport    // Transport type (TCP/UDP/SCTP/QUIC/etc)
 	Security        FloSecurity     // Security type (None/TLS)
 	Direction       protocol.FloDir // Direction of data flow (BiDi/Upload/Download)
-	Flags           FloFlags        // Optional flags (reserved for future use)
+	Flags           FloFlags        // Optional features requested by the client
 	ChunkSize       uint32          // Size of each data chunk
 	DurationMS      uint64          // Intended duration of the flo test in milliseconds
 	WarmupMS        uint64          // Warmup period in milliseconds
 	NonceClient     [16]byte        // Client nonce for authentication
+	PrimeBytes      uint64          // Bytes to transfer before measuring (0 uses the timed warmup, extension)
+	BytesTarget     uint64          // Measured bytes the sending side transfers before finishing (0 runs for the duration)
+	RateBps         uint64          // Bitrate each sending side paces its data to (0 is unlimited)
+	Streams         uint8           // Parallel connections the test runs over, each sending its own Hello
+	StreamIndex     uint8           // Which of the test's connections this is, from 0
 }
 
-const PktHelloSize = protocol.HeaderSize + 16 + 1 + 1 + 1 + 2 + 4 + 8 + 8 + 16
+const (
+	PktHelloSize       = protocol.HeaderSize + 16 + 1 + 1 + 1 + 2 + 4 + 8 + 8 + 16 // base layout
+	HelloExtLenSize    = 2                                                         // length prefix of the extension block
+	HelloExtSize       = 8 + 8 + 8 + 1 + 1                                         // extension fields this implementation knows
+	MaxHelloExtSize    = 1024                                                      // largest extension block accepted
+	PktHelloMaxSize    = PktHelloSize + HelloExtLenSize + MaxHelloExtSize
+	pktHelloExtendSize = PktHelloSize + HelloExtLenSize + HelloExtSize
+)
+
+// HelloExtended reports whether the Hello whose base layout is given carries an extension block
+func HelloExtended(base []byte) bool {
+	return len(base) >= 27 && FloFlags(le.Uint16(base[25:27]))&FlagHelloExt != 0
+}
+
+// HelloExtLen returns the length of the extension block from its prefix, checking it is in bounds
+func HelloExtLen(prefix []byte) (int, error) {
+	n := int(le.Uint16(prefix))
+	if n < HelloExtSize || n > MaxHelloExtSize {
+		return 0, fmt.Errorf("%w: hello extension of %d bytes is outside %d-%d", protocol.ErrInvalidPacketSize, n, HelloExtSize, MaxHelloExtSize)
+	}
+	return n, nil
+}
+
+// Bounds enforced on Hello parameters
+const (
+	MinChunkSize  = 10                   // smallest accepted chunk size in bytes
+	MaxChunkSize  = 10 * 1000 * 1000     // largest accepted chunk size in bytes
+	MinDurationMS = 1000                 // shortest accepted test duration in milliseconds
+	MaxDurationMS = 7 * 24 * 3600 * 1000 // longest accepted test duration in milliseconds (one week)
+	MaxWarmupMS   = 3600 * 1000          // longest accepted warmup period in milliseconds (one hour)
+	MaxStreams    = 128                  // most parallel connections a test may run over
+)
+
+// ValidateTiming checks a duration and warmup against the Hello bounds. The upper bounds keep
+// duration+warmup well within time.Duration once converted back from milliseconds.
+func ValidateTiming(durationMS, warmupMS uint64) error {
+	if durationMS < MinDurationMS || durationMS > MaxDurationMS {
+		return fmt.Errorf("%w: %dms is outside %d-%dms", protocol.ErrInvalidDuration, durationMS, uint64(MinDurationMS), uint64(MaxDurationMS))
+	}
+	if warmupMS > MaxWarmupMS {
+		return fmt.Errorf("%w: %dms exceeds %dms", protocol.ErrInvalidWarmup, warmupMS, uint64(MaxWarmupMS))
+	}
+	return nil
+}
+
+// ValidateStreams checks a test's stream count and a connection's index among its streams. Only
+// TCP tests run over parallel connections; a datagram test has a single data socket.
+func ValidateStreams(transport FloTransport, streams, index uint8) error {
+	if streams < 1 || streams > MaxStreams {
+		return fmt.Errorf("%w: %d is outside 1-%d", protocol.ErrInvalidStreams, streams, MaxStreams)
+	}
+	if index >= streams {
+		return fmt.Errorf("%w: stream %d of %d", protocol.ErrInvalidStreams, index, streams)
+	}
+	if streams > 1 && transport != TransportTCP {
+		return fmt.Errorf("%w: parallel streams require the TCP transport", protocol.ErrInvalidStreams)
+	}
+	return nil
+}
 
 func UnmarshalHello(data []byte) (*PktHello, error) {
-	if len(data) != PktHelloSize {
+	if len(data) < PktHelloSize {
+		return nil, protocol.ErrInvalidPacketSize
+	}
+	var ext []byte
+	if HelloExtended(data) {
+		if len(data) < PktHelloSize+HelloExtLenSize {
+			return nil, protocol.ErrInvalidPacketSize
+		}
+		n, err := HelloExtLen(data[PktHelloSize:])
+		if err != nil {
+			return nil, err
+		}
+		ext = data[PktHelloSize+HelloExtLenSize:]
+		if len(ext) != n {
+			return nil, protocol.ErrInvalidPacketSize
+		}
+	} else if len(data) != PktHelloSize {
 		return nil, protocol.ErrInvalidPacketSize
 	}
 
@@ -68,22 +155,23 @@ func UnmarshalHello(data []byte) (*PktHello, error) {
 	}
 
 	pkt.Flags = FloFlags(le.Uint16(data[25:27]))
-	if pkt.Flags != 0 {
+	if pkt.Flags&^FlagsKnown != 0 {
 		return nil, protocol.ErrInvalidFlags
 	}
 
 	pkt.ChunkSize = le.Uint32(data[27:31])
-	// Validate chunk size (e.g., between 1KB and 10MB)
-	if pkt.ChunkSize < 10 || pkt.ChunkSize > 10*1000*1000 {
-		return nil, protocol.ErrInvalidChunkSize
+	// Validate chunk size (between 10B and 10MB, and within a datagram for UDP)
+	err = ValidateTransportChunkSize(pkt.Transport, pkt.ChunkSize)
+	if err != nil {
+		return nil, err
 	}
 
 	pkt.DurationMS = le.Uint64(data[31:39])
-	if pkt.DurationMS < 1000 {
-		return nil, protocol.ErrInvalidDuration
-	}
-
 	pkt.WarmupMS = le.Uint64(data[39:47])
+	err = ValidateTiming(pkt.DurationMS, pkt.WarmupMS)
+	if err != nil {
+		return nil, err
+	}
 
 	// Copy the client nonce
 	copy(pkt.NonceClient[:], data[47:63])
@@ -96,11 +184,35 @@ func UnmarshalHello(data []byte) (*PktHello, error) {
 		return nil, protocol.ErrInvalidNonce
 	}
 
+	// the extension's fields default to a plain timed test over one connection
+	pkt.Streams = 1
+	if ext != nil {
+		pkt.PrimeBytes = le.Uint64(ext[0:8])
+		pkt.BytesTarget = le.Uint64(ext[8:16])
+		pkt.RateBps = le.Uint64(ext[16:24])
+		pkt.Streams = ext[24]
+		pkt.StreamIndex = ext[25]
+	}
+
+	if pkt.BytesTarget > 0 && pkt.Direction == protocol.DirectionBidi {
+		// the target is applied by the one side sending, of which a bidirectional test has two
+		return nil, protocol.ErrIncompatibleDirection
+	}
+
+	err = ValidateStreams(pkt.Transport, pkt.Streams, pkt.StreamIndex)
+	if err != nil {
+		return nil, err
+	}
+
 	return &pkt, nil
 }
 
 func (p *PktHello) Marshal() ([]byte, error) {
-	buf := make([]byte, PktHelloSize)
+	size := PktHelloSize
+	if p.Flags&FlagHelloExt != 0 {
+		size = pktHelloExtendSize
+	}
+	buf := make([]byte, size)
 
 	if p.Header.Magic != [4]byte{'F', 'L', 'O', 0x00} {
 		return nil, protocol.ErrInvalidMagic
@@ -118,10 +230,44 @@ func (p *PktHello) Marshal() ([]byte, error) {
 	le.PutUint64(buf[31:39], p.DurationMS)
 	le.PutUint64(buf[39:47], p.WarmupMS)
 	copy(buf[47:63], p.NonceClient[:])
+	if p.Flags&FlagHelloExt != 0 {
+		le.PutUint16(buf[63:65], HelloExtSize)
+		le.PutUint64(buf[65:73], p.PrimeBytes)
+		le.PutUint64(buf[73:81], p.BytesTarget)
+		le.PutUint64(buf[81:89], p.RateBps)
+		buf[89] = p.Streams
+		buf[90] = p.StreamIndex
+	}
 	return buf, nil
 }
 
-func NewHello(transport FloTransport, id ulid.ULID, security FloSecurity, direction protocol.FloDir, chunkSize uint32, duration, warmup time.Duration) (*PktHello, error) {
+// extend sets FlagHelloExt if any extension field differs from its default, so the block is only
+// sent to servers when the test needs it
+func (p *PktHello) extend() {
+	if p.PrimeBytes != 0 || p.BytesTarget != 0 || p.RateBps != 0 || p.Streams != 1 || p.StreamIndex != 0 {
+		p.Flags |= FlagHelloExt
+	} else {
+		p.Flags &^= FlagHelloExt
+	}
+}
+
+func NewHello(transport FloTransport, id ulid.ULID, security FloSecurity, direction protocol.FloDir, chunkSize uint32, duration, warmup time.Duration, primeBytes, bytesTarget, rateBps uint64, flags FloFlags) (*PktHello, error) {
+	// reject negative values before converting, as they would wrap to huge unsigned ones
+	if duration < 0 {
+		return nil, fmt.Errorf("%w: negative duration %s", protocol.ErrInvalidDuration, duration)
+	}
+	if warmup < 0 {
+		return nil, fmt.Errorf("%w: negative warmup %s", protocol.ErrInvalidWarmup, warmup)
+	}
+	err := ValidateTiming(uint64(duration.Milliseconds()), uint64(warmup.Milliseconds()))
+	if err != nil {
+		return nil, err
+	}
+	err = ValidateTransportChunkSize(transport, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+
 	var pkt PktHello
 
 	pkt.Header = createHeader(TypeHello)
@@ -135,11 +281,29 @@ func NewHello(transport FloTransport, id ulid.ULID, security FloSecurity, direct
 	pkt.Transport = transport
 	pkt.Security = security
 	pkt.Direction = direction
-	pkt.Flags = 0
+	pkt.Flags = flags
 	pkt.ChunkSize = chunkSize
 	pkt.DurationMS = uint64(duration.Milliseconds())
 	pkt.WarmupMS = uint64(warmup.Milliseconds())
 	copy(pkt.NonceClient[:], nonce[:])
+	pkt.PrimeBytes = primeBytes
+	pkt.BytesTarget = bytesTarget
+	pkt.RateBps = rateBps
+	pkt.Streams = 1
+	pkt.extend()
 
 	return &pkt, nil
 }
+
+// SetStream marks the Hello as opening connection index of a test running over streams parallel
+// connections, all sharing its session ID
+func (p *PktHello) SetStream(streams, index uint8) error {
+	err := ValidateStreams(p.Transport, streams, index)
+	if err != nil {
+		return err
+	}
+	p.Streams = streams
+	p.StreamIndex = index
+	p.extend()
+	return nil
+}
@@ -0,0 +1,172 @@
+package packets
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/oklog/ulid/v2"
+)
+
+func newTestHello(t *testing.T, primeBytes, bytesTarget, rateBps uint64) *PktHello {
+	t.Helper()
+	pkt, err := NewHello(TransportTCP, ulid.Make(), SecurityNone, protocol.DirectionUpload, 8192, 10*time.Second, time.Second, primeBytes, bytesTarget, rateBps, FlagResult)
+	if err != nil {
+		t.Fatalf("NewHello: %v", err)
+	}
+	return pkt
+}
+
+func TestHelloRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		prime    uint64
+		target   uint64
+		rate     uint64
+		streams  uint8
+		index    uint8
+		size     int
+		extended bool
+	}{
+		{"base", 0, 0, 0, 1, 0, PktHelloSize, false},
+		{"prime", 1 << 20, 0, 0, 1, 0, pktHelloExtendSize, true},
+		{"target", 0, 1 << 30, 0, 1, 0, pktHelloExtendSize, true},
+		{"rate", 0, 0, 100_000_000, 1, 0, pktHelloExtendSize, true},
+		{"streams", 0, 0, 0, 4, 3, pktHelloExtendSize, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt := newTestHello(t, tt.prime, tt.target, tt.rate)
+			if err := pkt.SetStream(tt.streams, tt.index); err != nil {
+				t.Fatalf("SetStream: %v", err)
+			}
+
+			buf, err := pkt.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if len(buf) != tt.size {
+				t.Fatalf("marshalled %d bytes, want %d", len(buf), tt.size)
+			}
+			if HelloExtended(buf) != tt.extended {
+				t.Fatalf("HelloExtended = %v, want %v", HelloExtended(buf), tt.extended)
+			}
+
+			got, err := UnmarshalHello(buf)
+			if err != nil {
+				t.Fatalf("UnmarshalHello: %v", err)
+			}
+			if *got != *pkt {
+				t.Fatalf("round trip mismatch:\n got %+v\nwant %+v", *got, *pkt)
+			}
+		})
+	}
+}
+
+// A Hello from a client that predates the extension is the base layout with no flags
+func TestHelloBaseDefaults(t *testing.T) {
+	pkt := newTestHello(t, 0, 0, 0)
+	pkt.Flags = 0
+	buf, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if len(buf) != PktHelloSize {
+		t.Fatalf("marshalled %d bytes, want the %d byte base", len(buf), PktHelloSize)
+	}
+
+	got, err := UnmarshalHello(buf)
+	if err != nil {
+		t.Fatalf("UnmarshalHello: %v", err)
+	}
+	if got.Streams != 1 || got.StreamIndex != 0 || got.PrimeBytes != 0 || got.BytesTarget != 0 || got.RateBps != 0 {
+		t.Fatalf("base hello has non-default extension fields: %+v", *got)
+	}
+}
+
+// Fields appended to the extension by a newer client are skipped
+func TestHelloExtensionForwardCompatible(t *testing.T) {
+	pkt := newTestHello(t, 0, 0, 12345)
+	buf, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	longer := append(append([]byte{}, buf...), 0xaa, 0xbb, 0xcc)
+	le.PutUint16(longer[PktHelloSize:], HelloExtSize+3)
+	got, err := UnmarshalHello(longer)
+	if err != nil {
+		t.Fatalf("UnmarshalHello: %v", err)
+	}
+	if got.RateBps != 12345 {
+		t.Fatalf("RateBps = %d, want 12345", got.RateBps)
+	}
+}
+
+func TestHelloExtensionMalformed(t *testing.T) {
+	pkt := newTestHello(t, 0, 0, 12345)
+	buf, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mangle func([]byte) []byte
+	}{
+		{"truncated block", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"missing prefix", func(b []byte) []byte { return b[:PktHelloSize+1] }},
+		{"flag without block", func(b []byte) []byte { return b[:PktHelloSize] }},
+		{"short length", func(b []byte) []byte { le.PutUint16(b[PktHelloSize:], HelloExtSize-1); return b }},
+		{"oversized length", func(b []byte) []byte { le.PutUint16(b[PktHelloSize:], MaxHelloExtSize+1); return b }},
+		{"block without flag", func(b []byte) []byte {
+			le.PutUint16(b[25:27], le.Uint16(b[25:27])&^uint16(FlagHelloExt))
+			return b
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.mangle(append([]byte{}, buf...))
+			_, err := UnmarshalHello(data)
+			if !errors.Is(err, protocol.ErrInvalidPacketSize) {
+				t.Fatalf("UnmarshalHello error = %v, want %v", err, protocol.ErrInvalidPacketSize)
+			}
+		})
+	}
+}
+
+func TestNewHelloTiming(t *testing.T) {
+	const week = 7 * 24 * time.Hour
+	tests := []struct {
+		name     string
+		duration time.Duration
+		warmup   time.Duration
+		want     error
+	}{
+		{"minimum duration", time.Second, 0, nil},
+		{"below minimum duration", time.Second - time.Millisecond, 0, protocol.ErrInvalidDuration},
+		{"maximum duration", week, 0, nil},
+		{"above maximum duration", week + time.Millisecond, 0, protocol.ErrInvalidDuration},
+		{"maximum warmup", time.Minute, time.Hour, nil},
+		{"above maximum warmup", time.Minute, time.Hour + time.Millisecond, protocol.ErrInvalidWarmup},
+		{"negative duration", -time.Second, 0, protocol.ErrInvalidDuration},
+		{"negative warmup", time.Minute, -time.Second, protocol.ErrInvalidWarmup},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHello(TransportTCP, ulid.Make(), SecurityNone, protocol.DirectionUpload, 8192, tt.duration, tt.warmup, 0, 0, 0, FlagResult)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("NewHello(%s, %s) error = %v, want %v", tt.duration, tt.warmup, err, tt.want)
+			}
+			if tt.duration < 0 || tt.warmup < 0 {
+				return
+			}
+			// the wire values are validated by the receiver the same way
+			err = ValidateTiming(uint64(tt.duration.Milliseconds()), uint64(tt.warmup.Milliseconds()))
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("ValidateTiming(%s, %s) error = %v, want %v", tt.duration, tt.warmup, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package packets
+
+import (
+	"github.com/oklog/ulid/v2"
+)
+
+// Pause and Resume packets share the End packet's layout: a header followed by the session ID.
+// The client sends them in-band on its side of the data phase when FlagPause is negotiated, so
+// the whole packet doubles as the marker the server scans the unframed data for.
+
+// PauseMarker returns the Pause packet for the session
+func PauseMarker(sessionID ulid.ULID) []byte {
+	return packetMarker(TypePause, sessionID)
+}
+
+// ResumeMarker returns the Resume packet for the session
+func ResumeMarker(sessionID ulid.ULID) []byte {
+	return packetMarker(TypeResume, sessionID)
+}
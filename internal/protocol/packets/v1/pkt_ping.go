@@ -0,0 +1,101 @@
+package packets
+
+import (
+	"fmt"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/oklog/ulid/v2"
+)
+
+// MaxPings is the most Ping packets a client may send ahead of a test's data phase
+const MaxPings = 1000
+
+// Ping packet timing a round trip on the control connection when FlagPing is negotiated. After
+// the Ack the client sends Count pings one at a time, and the server answers each with the same
+// layout as TypePong. The pong after the ping with sequence number Count-1 ends the ping phase.
+type PktPing struct {
+	protocol.Header           // Common packet header (TypePing or TypePong)
+	SessionID       ulid.ULID // Unique session identifier
+	Seq             uint16    // Position of the ping in the ping phase, from zero
+	Count           uint16    // Number of pings in the ping phase
+}
+
+const PktPingSize = protocol.HeaderSize + 16 + 2 + 2
+
+func UnmarshalPing(data []byte) (*PktPing, error) {
+	if len(data) != PktPingSize {
+		return nil, protocol.ErrInvalidPacketSize
+	}
+
+	header, err := protocol.UnmarshalHeader(data[0:protocol.HeaderSize])
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Type != TypePing && header.Type != TypePong {
+		return nil, protocol.ErrIncorrectType
+	}
+
+	var pkt PktPing
+	pkt.Header = *header
+	copy(pkt.SessionID[:], data[6:22])
+	pkt.Seq = le.Uint16(data[22:24])
+	pkt.Count = le.Uint16(data[24:26])
+
+	err = ValidatePings(pkt.Seq, pkt.Count)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pkt, nil
+}
+
+func (p *PktPing) Marshal() ([]byte, error) {
+	buf := make([]byte, PktPingSize)
+
+	if p.Header.Magic != [4]byte{'F', 'L', 'O', 0x00} {
+		return nil, protocol.ErrInvalidMagic
+	}
+
+	copy(buf[0:4], p.Header.Magic[:])
+	buf[4] = byte(p.Header.Version)
+	buf[5] = byte(p.Header.Type)
+	copy(buf[6:22], p.SessionID[:])
+	le.PutUint16(buf[22:24], p.Seq)
+	le.PutUint16(buf[24:26], p.Count)
+	return buf, nil
+}
+
+// ValidatePings checks a ping's position within a ping phase of count pings
+func ValidatePings(seq, count uint16) error {
+	if count < 1 || count > MaxPings {
+		return fmt.Errorf("%w: %d pings is outside 1-%d", protocol.ErrInvalidPing, count, MaxPings)
+	}
+	if seq >= count {
+		return fmt.Errorf("%w: ping %d of %d", protocol.ErrInvalidPing, seq, count)
+	}
+	return nil
+}
+
+func NewPing(sessionID ulid.ULID, seq, count uint16) (*PktPing, error) {
+	err := ValidatePings(seq, count)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkt PktPing
+
+	pkt.Header = createHeader(TypePing)
+	copy(pkt.SessionID[:], sessionID[:])
+	pkt.Seq = seq
+	pkt.Count = count
+
+	return &pkt, nil
+}
+
+// NewPong returns the answer to a ping
+func NewPong(ping *PktPing) (*PktPing, error) {
+	pkt := *ping
+	pkt.Header = createHeader(TypePong)
+	return &pkt, nil
+}
@@ -0,0 +1,15 @@
+package packets
+
+import (
+	"github.com/oklog/ulid/v2"
+)
+
+// Register packets share the End packet's layout: a header followed by the session ID. Ahead of a
+// datagram test the client sends one to the data port from the Ack, and the server echoes it back.
+// The server learns the address to accept the client's datagrams from and to send its own to, and
+// the echo tells the client the path works in both directions before the data phase starts.
+
+// RegisterMarker returns the Register packet for the session
+func RegisterMarker(sessionID ulid.ULID) []byte {
+	return packetMarker(TypeRegister, sessionID)
+}
@@ -0,0 +1,163 @@
+package packets
+
+import (
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/oklog/ulid/v2"
+)
+
+// Result packet sent by the server after the data phase (if negotiated) with its view of the test.
+//
+// When FlagResultDuration is also negotiated the extended form is sent, appending the server's
+// measured duration so the client can compute the server-side bitrate itself. When
+// FlagResultWindow is negotiated as well, the window form follows it with the server's warmup,
+// i.e. how long after the data phase began the server started measuring, so the client can
+// check that both ends measured the same window.
+type PktResult struct {
+	protocol.Header           // Common packet header
+	SessionID       ulid.ULID // Unique session identifier
+	BytesSent       uint64    // Bytes sent by the server during the measured window
+	BytesReceived   uint64    // Bytes received by the server during the measured window
+	DurationNS      uint64    // Server's measured duration in nanoseconds (extended form only)
+	WarmupNS        uint64    // Server's warmup before measuring in nanoseconds (window form only)
+	extended        bool
+	window          bool
+}
+
+const (
+	PktResultSize         = protocol.HeaderSize + 16 + 8 + 8
+	PktResultExtendedSize = PktResultSize + 8
+	PktResultWindowSize   = PktResultExtendedSize + 8
+)
+
+// ResultSize returns the size of the Result packet sent under the negotiated flags
+func ResultSize(flags FloFlags) int {
+	switch {
+	case flags&FlagResultDuration != 0 && flags&FlagResultWindow != 0:
+		return PktResultWindowSize
+	case flags&FlagResultDuration != 0:
+		return PktResultExtendedSize
+	default:
+		return PktResultSize
+	}
+}
+
+// HasDuration reports whether the result carries the server's measured duration
+func (p *PktResult) HasDuration() bool {
+	return p.extended
+}
+
+// HasWindow reports whether the result carries the server's warmup
+func (p *PktResult) HasWindow() bool {
+	return p.window
+}
+
+func UnmarshalResult(data []byte) (*PktResult, error) {
+	if len(data) != PktResultSize && len(data) != PktResultExtendedSize && len(data) != PktResultWindowSize {
+		return nil, protocol.ErrInvalidPacketSize
+	}
+
+	header, err := protocol.UnmarshalHeader(data[0:protocol.HeaderSize])
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Type != TypeResult {
+		return nil, protocol.ErrIncorrectType
+	}
+
+	var pkt PktResult
+	pkt.Header = *header
+	copy(pkt.SessionID[:], data[6:22])
+	pkt.BytesSent = le.Uint64(data[22:30])
+	pkt.BytesReceived = le.Uint64(data[30:38])
+	if len(data) >= PktResultExtendedSize {
+		pkt.DurationNS = le.Uint64(data[38:46])
+		pkt.extended = true
+	}
+	if len(data) == PktResultWindowSize {
+		pkt.WarmupNS = le.Uint64(data[46:54])
+		pkt.window = true
+	}
+
+	return &pkt, nil
+}
+
+func (p *PktResult) Marshal() ([]byte, error) {
+	size := PktResultSize
+	switch {
+	case p.window:
+		size = PktResultWindowSize
+	case p.extended:
+		size = PktResultExtendedSize
+	}
+	buf := make([]byte, size)
+
+	if p.Header.Magic != [4]byte{'F', 'L', 'O', 0x00} {
+		return nil, protocol.ErrInvalidMagic
+	}
+
+	copy(buf[0:4], p.Header.Magic[:])
+	buf[4] = byte(p.Header.Version)
+	buf[5] = byte(p.Header.Type)
+	copy(buf[6:22], p.SessionID[:])
+	le.PutUint64(buf[22:30], p.BytesSent)
+	le.PutUint64(buf[30:38], p.BytesReceived)
+	if p.extended {
+		le.PutUint64(buf[38:46], p.DurationNS)
+	}
+	if p.window {
+		le.PutUint64(buf[46:54], p.WarmupNS)
+	}
+	return buf, nil
+}
+
+func NewResult(sessionID ulid.ULID, bytesSent, bytesReceived uint64) (*PktResult, error) {
+	var pkt PktResult
+
+	pkt.Header = createHeader(TypeResult)
+	copy(pkt.SessionID[:], sessionID[:])
+	pkt.BytesSent = bytesSent
+	pkt.BytesReceived = bytesReceived
+
+	return &pkt, nil
+}
+
+// NewResultWithDuration creates an extended Result carrying the server's measured duration
+func NewResultWithDuration(sessionID ulid.ULID, bytesSent, bytesReceived uint64, duration time.Duration) (*PktResult, error) {
+	pkt, err := NewResult(sessionID, bytesSent, bytesReceived)
+	if err != nil {
+		return nil, err
+	}
+	pkt.DurationNS = uint64(max(duration, 0))
+	pkt.extended = true
+	return pkt, nil
+}
+
+// NewResultWithWindow creates a window-form Result carrying the server's measured duration and the
+// warmup that preceded it
+func NewResultWithWindow(sessionID ulid.ULID, bytesSent, bytesReceived uint64, duration, warmup time.Duration) (*PktResult, error) {
+	pkt, err := NewResultWithDuration(sessionID, bytesSent, bytesReceived, duration)
+	if err != nil {
+		return nil, err
+	}
+	pkt.WarmupNS = uint64(max(warmup, 0))
+	pkt.window = true
+	return pkt, nil
+}
+
+// ResultMarker returns the leading bytes of a Result packet for the given session, used to
+// locate the packet in a stream that may still contain trailing data bytes
+func ResultMarker(sessionID ulid.ULID) []byte {
+	return packetMarker(TypeResult, sessionID)
+}
+
+// packetMarker returns the header and session ID that begin a packet of the given type
+func packetMarker(t protocol.FloType, sessionID ulid.ULID) []byte {
+	header := createHeader(t)
+	marker := make([]byte, 0, protocol.HeaderSize+16)
+	marker = append(marker, header.Magic[:]...)
+	marker = append(marker, byte(header.Version), byte(header.Type))
+	return append(marker, sessionID[:]...)
+}
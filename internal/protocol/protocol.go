@@ -1,5 +1,7 @@
 package protocol
 
+import "fmt"
+
 // 4-byte magic constant at the start of each packet
 const MAGIC = "FLO\x00"
 
@@ -33,6 +35,33 @@ const (
 	DirectionDownload FloDir = 2 // Client Receive, Server Send
 )
 
+func DirectionToString(d FloDir) string {
+	switch d {
+	case DirectionBidi:
+		return "bidi"
+	case DirectionUpload:
+		return "upload"
+	case DirectionDownload:
+		return "download"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseDirection parses the string form of a direction as produced by DirectionToString
+func ParseDirection(s string) (FloDir, error) {
+	switch s {
+	case "bidi":
+		return DirectionBidi, nil
+	case "upload":
+		return DirectionUpload, nil
+	case "download":
+		return DirectionDownload, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrUnsupportedDirection, s)
+	}
+}
+
 const HeaderSize = 6
 
 // UnmarshalHeader parses raw bytes into a Header struct
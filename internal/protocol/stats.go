@@ -5,10 +5,33 @@ import (
 	"time"
 )
 
-// Stats will keep track of total bytes sent and received during a test session
+// Stats will keep track of total bytes sent and received during a test session.
+//
+// The write and read operation counts cover the measured window like the byte totals. Combined
+// with throughput they show whether small chunks made a test syscall-bound.
+//
+// Tail bytes are data received after the local measurement window closed, i.e. still in
+// flight from the peer when the deadline hit. They are excluded from the sent/received
+// totals and averages and reported separately so the measured window stays well defined.
+//
+// The sent and received totals are goodput: payload bytes only. Protocol bytes carried in-band
+// during the data phase, such as pause and resume markers, are counted separately as overhead, so
+// payload plus overhead is what the transport carried above its own headers.
+//
+// A failed final flush means the sent total includes bytes that were buffered but never reached
+// the wire, so the sending side's figures overstate what the peer could have received.
 type Stats struct {
-	bytesSent atomic.Uint64
-	bytesRcvd atomic.Uint64
+	bytesSent   atomic.Uint64
+	bytesRcvd   atomic.Uint64
+	bytesWarmup atomic.Uint64 // bytes transferred in either direction before counting began
+	bytesTail   atomic.Uint64 // bytes received after the measurement window closed
+	countStart  atomic.Int64  // unix nanoseconds at which counting began (0 if not yet)
+	writes      atomic.Uint64 // write operations while counting
+	reads       atomic.Uint64 // read operations while counting
+	flushFailed atomic.Bool   // the final flush of buffered data failed
+
+	overheadSent atomic.Uint64 // protocol bytes sent in-band while counting
+	overheadRcvd atomic.Uint64 // protocol bytes received in-band while counting
 }
 
 func (s *Stats) AddBytesSent(delta uint64) {
@@ -19,9 +42,41 @@ func (s *Stats) AddBytesRcvd(delta uint64) {
 	s.bytesRcvd.Add(delta)
 }
 
+func (s *Stats) AddBytesWarmup(delta uint64) {
+	s.bytesWarmup.Add(delta)
+}
+
+func (s *Stats) AddBytesTail(delta uint64) {
+	s.bytesTail.Add(delta)
+}
+
+func (s *Stats) AddOverheadSent(delta uint64) {
+	s.overheadSent.Add(delta)
+}
+
+func (s *Stats) AddOverheadRcvd(delta uint64) {
+	s.overheadRcvd.Add(delta)
+}
+
+func (s *Stats) AddWrites(delta uint64) {
+	s.writes.Add(delta)
+}
+
+func (s *Stats) AddReads(delta uint64) {
+	s.reads.Add(delta)
+}
+
 func (s *Stats) Reset() {
 	s.bytesSent.Store(0)
 	s.bytesRcvd.Store(0)
+	s.bytesWarmup.Store(0)
+	s.bytesTail.Store(0)
+	s.countStart.Store(0)
+	s.writes.Store(0)
+	s.reads.Store(0)
+	s.flushFailed.Store(false)
+	s.overheadSent.Store(0)
+	s.overheadRcvd.Store(0)
 }
 
 func (s *Stats) GetBytesSent() uint64 {
@@ -32,8 +87,70 @@ func (s *Stats) GetBytesRcvd() uint64 {
 	return s.bytesRcvd.Load()
 }
 
+func (s *Stats) GetBytesWarmup() uint64 {
+	return s.bytesWarmup.Load()
+}
+
+func (s *Stats) GetBytesTail() uint64 {
+	return s.bytesTail.Load()
+}
+
+func (s *Stats) GetOverheadSent() uint64 {
+	return s.overheadSent.Load()
+}
+
+func (s *Stats) GetOverheadRcvd() uint64 {
+	return s.overheadRcvd.Load()
+}
+
+func (s *Stats) GetWrites() uint64 {
+	return s.writes.Load()
+}
+
+func (s *Stats) GetReads() uint64 {
+	return s.reads.Load()
+}
+
+// MarkFlushFailed records that buffered data could not be flushed at the end of the transfer
+func (s *Stats) MarkFlushFailed() {
+	s.flushFailed.Store(true)
+}
+
+// FlushFailed reports whether the final flush of buffered data failed
+func (s *Stats) FlushFailed() bool {
+	return s.flushFailed.Load()
+}
+
+// MarkCountStart records the moment measurement began
+func (s *Stats) MarkCountStart(t time.Time) {
+	s.countStart.Store(t.UnixNano())
+}
+
+// GetCountStart returns when measurement began, or the zero time if it never did
+func (s *Stats) GetCountStart() time.Time {
+	ns := s.countStart.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// MeasuredDuration returns the time elapsed between the start of measurement and end
+func (s *Stats) MeasuredDuration(end time.Time) time.Duration {
+	start := s.GetCountStart()
+	if start.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// StatsDiff is the change in the counters over one reporting interval. BytesSent and BytesRcvd are
+// goodput; the overhead fields hold the in-band protocol bytes moved alongside them.
 type StatsDiff struct {
 	BytesSent uint64
 	BytesRcvd uint64
 	Duration  time.Duration
+
+	OverheadSent uint64
+	OverheadRcvd uint64
 }
@@ -0,0 +1,29 @@
+package transfer
+
+import (
+	"errors"
+
+	"github.com/rs/zerolog/log"
+)
+
+var ErrAffinityUnsupported = errors.New("CPU affinity is not supported on this platform")
+
+// runPinned runs a transfer loop with its goroutine locked to an OS thread pinned to one of the
+// CPUs, chosen round-robin by the loop's index, so the loop keeps its caches warm instead of
+// migrating between cores. With no CPUs, or if pinning fails, the loop runs unpinned.
+func runPinned(cpus []int, index int, loop func() error) error {
+	if len(cpus) == 0 {
+		return loop()
+	}
+
+	cpu := cpus[index%len(cpus)]
+	unpin, err := pinThread(cpu)
+	if err != nil {
+		log.Warn().Err(err).Int("cpu", cpu).Msg("Failed to pin transfer loop to CPU, running unpinned")
+		return loop()
+	}
+	defer unpin()
+
+	log.Debug().Int("cpu", cpu).Msg("Pinned transfer loop to CPU")
+	return loop()
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package transfer
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// pinThread locks the calling goroutine to its OS thread and restricts that thread to the CPU.
+// The returned function restores the thread's previous affinity and unlocks it.
+func pinThread(cpu int) (func(), error) {
+	runtime.LockOSThread()
+
+	var prev unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &prev); err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("failed to get thread affinity: %w", err)
+	}
+
+	var set unix.CPUSet
+	set.Set(cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		runtime.UnlockOSThread()
+		return nil, fmt.Errorf("failed to set thread affinity: %w", err)
+	}
+
+	return func() {
+		_ = unix.SchedSetaffinity(0, &prev)
+		runtime.UnlockOSThread()
+	}, nil
+}
@@ -0,0 +1,109 @@
+//go:build linux
+
+package transfer
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"golang.org/x/sys/unix"
+)
+
+// allowedCPUs returns the CPUs this process may run on
+func allowedCPUs(tb testing.TB) []int {
+	tb.Helper()
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		tb.Fatalf("SchedGetaffinity: %v", err)
+	}
+	var cpus []int
+	for cpu := 0; len(cpus) < set.Count(); cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
+
+func TestPinThreadRestoresAffinity(t *testing.T) {
+	cpus := allowedCPUs(t)
+	err := runPinned(cpus[len(cpus)-1:], 0, func() error {
+		var set unix.CPUSet
+		if err := unix.SchedGetaffinity(0, &set); err != nil {
+			return err
+		}
+		if set.Count() != 1 || !set.IsSet(cpus[len(cpus)-1]) {
+			t.Errorf("pinned thread may run on %d CPUs, want only CPU %d", set.Count(), cpus[len(cpus)-1])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runPinned: %v", err)
+	}
+	if got := allowedCPUs(t); len(got) != len(cpus) {
+		t.Errorf("after unpinning the thread may run on %d CPUs, want %d", len(got), len(cpus))
+	}
+}
+
+// cpuTime returns the user and system CPU time this process has used
+func cpuTime(tb testing.TB) time.Duration {
+	var ru unix.Rusage
+	if err := unix.Getrusage(unix.RUSAGE_SELF, &ru); err != nil {
+		tb.Fatalf("Getrusage: %v", err)
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// benchmarkPinned runs a send and a receive loop over loopback TCP, each pinned round-robin to the
+// CPUs (or unpinned if there are none), reporting throughput and the CPU time spent per chunk
+func benchmarkPinned(b *testing.B, cpus []int) {
+	a, peer := tcpPair(b)
+	var sent, rcvd protocol.Stats
+	var counting atomic.Bool
+	counting.Store(true)
+	target := uint64(b.N) * benchChunkSize
+
+	b.SetBytes(benchChunkSize)
+	start := cpuTime(b)
+	b.ResetTimer()
+
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- runPinned(cpus, 0, func() error {
+			defer a.CloseWrite()
+			return SendLoop(context.Background(), a, benchChunkSize, 0, &sent, &counting, nil, target, nil)
+		})
+	}()
+	go func() {
+		errCh <- runPinned(cpus, 1, func() error {
+			err := RecvLoop(context.Background(), peer, benchChunkSize, &rcvd, &counting)
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		})
+	}()
+	for range 2 {
+		if err := <-errCh; err != nil {
+			b.Fatalf("transfer loop: %v", err)
+		}
+	}
+
+	b.StopTimer()
+	b.ReportMetric(float64(cpuTime(b)-start)/float64(b.N), "cpu-ns/op")
+	if got := rcvd.GetBytesRcvd(); got != target {
+		b.Fatalf("received %d bytes, want %d", got, target)
+	}
+}
+
+func BenchmarkTransferUnpinned(b *testing.B) {
+	benchmarkPinned(b, nil)
+}
+
+func BenchmarkTransferPinned(b *testing.B) {
+	benchmarkPinned(b, allowedCPUs(b))
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package transfer
+
+// pinThread is unavailable on this platform
+func pinThread(cpu int) (func(), error) {
+	return nil, ErrAffinityUnsupported
+}
@@ -0,0 +1,89 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// maxDatagramSize is the receive buffer size, enough for any UDP datagram so none is truncated
+const maxDatagramSize = 64 << 10
+
+// RecvDatagramLoop receives datagrams until the context is done, counting each as one read, so the
+// receiver's read count against the sender's write count shows how many datagrams were lost.
+// Datagrams equal to stray, such as a duplicated registration, are discarded uncounted.
+func RecvDatagramLoop(ctx context.Context, conn net.Conn, stats *protocol.Stats, counting *atomic.Bool, stray []byte) error {
+	buf := make([]byte, maxDatagramSize)
+
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+			return err
+		}
+		if stray != nil && bytes.Equal(buf[:n], stray) {
+			continue
+		}
+
+		if counting.Load() {
+			stats.AddBytesRcvd(uint64(n))
+			stats.AddReads(1)
+		} else {
+			stats.AddBytesWarmup(uint64(n))
+		}
+	}
+}
+
+// TransferDatagrams runs a one-way datagram transfer on a connected socket with its own stats
+// monitor, sending one chunk per datagram or receiving. There is no connection to half-close, so
+// the sender stops at its deadline alone; the receiver stops at its deadline, or earlier when the
+// caller cancels ctx on learning through the control connection that the sender has finished.
+func TransferDatagrams(ctx context.Context, conn net.Conn, send bool, chunkSize uint32, duration, warmup time.Duration, stats *protocol.Stats, opts Options) error {
+	counters := CountRcvd
+	if send {
+		counters = CountSent
+	}
+
+	mon := NewMonitor(stats)
+	mon.Start(ctx, opts.warmupPolicy(warmup), opts.getInterval(duration), counters, opts.getSinks())
+	defer mon.Stop()
+
+	if opts.ShutdownGrace > 0 {
+		var cancelGrace context.CancelFunc
+		ctx, cancelGrace = WithShutdownGrace(ctx, opts.ShutdownGrace, opts.getInterval(duration), stats)
+		defer cancelGrace()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, duration+warmup)
+	defer cancel()
+
+	// unblock a loop waiting on the socket once the transfer is over
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer func() {
+		stop()
+		_ = conn.SetDeadline(time.Time{})
+	}()
+
+	err := runPinned(opts.CPUs, 0, func() error {
+		if send {
+			return SendLoop(ctx, conn, chunkSize, opts.ChunkMin, stats, &mon.counting, opts.Limiter, opts.BytesTarget, nil)
+		}
+		return RecvDatagramLoop(ctx, conn, stats, &mon.counting, opts.StrayMarker)
+	})
+	if err != nil {
+		// a refused datagram means the peer's socket is gone, e.g. it stopped early
+		log.Warn().Err(err).Msg("Transfer ended early (peer unreachable)")
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package transfer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiterBurstWindow is the span of traffic the limiter lets through at once, keeping bursts
+// small enough that the rate holds over sub-second intervals
+const limiterBurstWindow = 10 * time.Millisecond
+
+// limiterQuantum is how much each sender reserves from a shared limiter at a time. Reservations
+// queue behind each other, so equal reservations give every sender an equal share of the rate
+// whatever its chunk size.
+const limiterQuantum = 64 << 10
+
+// Limiter paces writes to a target bitrate using a token bucket. It is safe for concurrent use,
+// so a single limiter may be shared between several send loops to cap their combined rate.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64 // maximum tokens that may accumulate
+	tokens float64
+	last   time.Time
+	waited time.Duration // total time callers were delayed
+	share  int           // bytes reserved per turn by each sender (exactly what is sent if zero)
+	parent *Limiter      // further limit every send is held to, such as a shared cap (optional)
+}
+
+// NewLimiter creates a limiter for the given rate in bits per second. The bucket holds enough
+// tokens for the larger of one chunk or limiterBurstWindow worth of traffic.
+func NewLimiter(bitsPerSecond uint64, chunkSize uint32) *Limiter {
+	rate := float64(bitsPerSecond) / 8
+	burst := max(float64(chunkSize), rate*limiterBurstWindow.Seconds())
+	return &Limiter{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// NewSharedLimiter creates a limiter for the given aggregate rate in bits per second, to be shared
+// by any number of concurrent send loops with different chunk sizes. Each loop reserves
+// limiterQuantum bytes at a time and sends from its reservation, so a loop with large chunks
+// can't starve those with small ones.
+func NewSharedLimiter(bitsPerSecond uint64) *Limiter {
+	l := NewLimiter(bitsPerSecond, limiterQuantum)
+	l.share = limiterQuantum
+	return l
+}
+
+// Within holds senders to the parent's rate as well as this limiter's, e.g. a per-test rate within
+// a cap shared by all tests, and returns the limiter. A nil parent adds no limit.
+func (l *Limiter) Within(parent *Limiter) *Limiter {
+	l.parent = parent
+	return l
+}
+
+// Wait blocks until n bytes may be sent or the context is done
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	// reserve the tokens up front so concurrent callers queue behind each other
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.waited += delay
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Waited returns the total time writes were delayed to honor the rate
+func (l *Limiter) Waited() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.waited
+}
+
+// limiterCredit tracks one sender's unspent reservation from a shared limiter
+type limiterCredit struct {
+	limiter *Limiter
+	credit  int
+	parent  *limiterCredit // the sender's credit with the limiter's parent, if it has one
+}
+
+// newLimiterCredit creates a sender's credit with the limiter and each of its parents
+func newLimiterCredit(limiter *Limiter) *limiterCredit {
+	c := &limiterCredit{limiter: limiter}
+	if limiter != nil && limiter.parent != nil {
+		c.parent = newLimiterCredit(limiter.parent)
+	}
+	return c
+}
+
+// Wait blocks until n bytes may be sent under the limiter and its parents, or the context is done
+func (c *limiterCredit) Wait(ctx context.Context, n int) error {
+	err := c.wait(ctx, n)
+	if err != nil || c.parent == nil {
+		return err
+	}
+	return c.parent.Wait(ctx, n)
+}
+
+// wait blocks until n bytes may be sent under the limiter alone, reserving whole shares from it as
+// the credit runs out, or the context is done
+func (c *limiterCredit) wait(ctx context.Context, n int) error {
+	if c.limiter.share == 0 {
+		return c.limiter.Wait(ctx, n)
+	}
+	for c.credit < n {
+		if err := c.limiter.Wait(ctx, c.limiter.share); err != nil {
+			return err
+		}
+		c.credit += c.limiter.share
+	}
+	c.credit -= n
+	return nil
+}
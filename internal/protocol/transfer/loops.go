@@ -2,10 +2,14 @@ package transfer
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"math/rand/v2"
 	"net"
+	"runtime"
 	"sync/atomic"
 	"time"
 
@@ -14,11 +18,17 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func SendLoop(ctx context.Context, w io.Writer, chunkSize uint32, stats *protocol.Stats, counting *atomic.Bool) error {
+// SendLoop writes chunks until the context is done. A non-zero chunkMin makes each write a random
+// size from chunkMin up to chunkSize, modelling bursty application traffic. A non-nil limiter paces
+// the writes, and a non-zero target stops the loop once that many bytes have been counted across
+// all streams sharing the stats; the final write is trimmed so a single stream meets the target
+// exactly. A non-nil pauser holds the loop between chunks while paused.
+func SendLoop(ctx context.Context, w io.Writer, chunkSize, chunkMin uint32, stats *protocol.Stats, counting *atomic.Bool, limiter *Limiter, target uint64, pauser *Pauser) error {
 	buf := make([]byte, chunkSize)
 	for i := 0; i < int(chunkSize); i++ {
 		buf[i] = byte(i)
 	}
+	credit := newLimiterCredit(limiter)
 
 	for {
 		select {
@@ -27,9 +37,40 @@ func SendLoop(ctx context.Context, w io.Writer, chunkSize uint32, stats *protoco
 		default:
 		}
 
-		n, err := w.Write(buf)
-		if n > 0 && counting.Load() {
-			stats.AddBytesSent(uint64(n))
+		if pauser != nil {
+			if err := pauser.Wait(ctx); err != nil {
+				return nil // context done
+			}
+		}
+
+		chunk := buf
+		if chunkMin > 0 && chunkMin < chunkSize {
+			chunk = buf[:chunkMin+rand.Uint32N(chunkSize-chunkMin+1)]
+		}
+		if target > 0 && counting.Load() {
+			sent := stats.GetBytesSent()
+			if sent >= target {
+				return nil
+			}
+			if remaining := target - sent; remaining < uint64(len(chunk)) {
+				chunk = chunk[:remaining]
+			}
+		}
+
+		if limiter != nil {
+			if err := credit.Wait(ctx, len(chunk)); err != nil {
+				return nil // context done
+			}
+		}
+
+		n, err := w.Write(chunk)
+		if n > 0 {
+			if counting.Load() {
+				stats.AddBytesSent(uint64(n))
+				stats.AddWrites(1)
+			} else {
+				stats.AddBytesWarmup(uint64(n))
+			}
 		}
 		if err != nil {
 			select {
@@ -42,8 +83,15 @@ func SendLoop(ctx context.Context, w io.Writer, chunkSize uint32, stats *protoco
 	}
 }
 
+// maxConsecutiveEmptyReads bounds how many (0, nil) reads RecvLoop tolerates before giving up
+const maxConsecutiveEmptyReads = 100
+
+// RecvLoop receives and counts the peer's data until the context is done or the peer closes. It never
+// stops on a byte count: the warmup bytes the peer sent are unknown here, so only the peer's half-close
+// (or, through RecvLoopUntil, its end marker) reliably marks the end of its data.
 func RecvLoop(ctx context.Context, r io.Reader, chunkSize uint32, stats *protocol.Stats, counting *atomic.Bool) error {
 	buf := make([]byte, chunkSize)
+	emptyReads := 0
 
 	for {
 		select {
@@ -53,10 +101,95 @@ func RecvLoop(ctx context.Context, r io.Reader, chunkSize uint32, stats *protoco
 		}
 
 		n, err := r.Read(buf)
-		if n > 0 && counting.Load() {
+		if n == 0 && err == nil {
+			// some readers legally return (0, nil); yield instead of spinning and fail if it persists
+			emptyReads++
+			if emptyReads >= maxConsecutiveEmptyReads {
+				return io.ErrNoProgress
+			}
+			runtime.Gosched()
+			continue
+		}
+		emptyReads = 0
+
+		if n > 0 {
+			if counting.Load() {
+				stats.AddBytesRcvd(uint64(n))
+				stats.AddReads(1)
+			} else {
+				stats.AddBytesWarmup(uint64(n))
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			default:
+			}
+			return err
+		}
+	}
+}
+
+// ErrPeerEnded is returned by RecvLoopUntil when the peer's end marker arrives
+var ErrPeerEnded = errors.New("peer ended the data phase")
+
+// PeerMarkers are the control packets RecvLoopUntil recognizes in the peer's unframed data. Any
+// of them may be nil.
+type PeerMarkers struct {
+	End    []byte // stops the loop, leaving the packet unread
+	Pause  []byte // consumed and applied to the pauser
+	Resume []byte // consumed and applied to the pauser
+}
+
+// find returns the offset of the earliest marker in buf and the marker, or -1
+func (m PeerMarkers) find(buf []byte) (int, []byte) {
+	idx, found := -1, []byte(nil)
+	for _, marker := range [][]byte{m.End, m.Pause, m.Resume} {
+		if marker == nil {
+			continue
+		}
+		if i := bytes.Index(buf, marker); i >= 0 && (idx < 0 || i < idx) {
+			idx, found = i, marker
+		}
+	}
+	return idx, found
+}
+
+func (m PeerMarkers) size() int {
+	return max(len(m.End), len(m.Pause), len(m.Resume))
+}
+
+// RecvLoopUntil receives like RecvLoop but stops at the marker announcing the end of the peer's
+// data, leaving the marker unread so the caller can consume the packet it begins. Pause and
+// resume markers are removed from the data and applied to the pauser. Reads go through the
+// bufio.Reader's buffer so a marker split across reads is still found.
+func RecvLoopUntil(ctx context.Context, r *bufio.Reader, stats *protocol.Stats, counting *atomic.Bool, markers PeerMarkers, pauser *Pauser) error {
+	size := markers.size()
+	keep := size - 1
+	count := func(n int) {
+		if counting.Load() {
 			stats.AddBytesRcvd(uint64(n))
+		} else {
+			stats.AddBytesWarmup(uint64(n))
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
 		}
+
+		_, err := r.Peek(size)
 		if err != nil {
+			// what is left is too short to hold a marker, so it is data
+			n, _ := r.Discard(r.Buffered())
+			count(n)
 			if errors.Is(err, io.EOF) {
 				return err
 			}
@@ -67,77 +200,192 @@ func RecvLoop(ctx context.Context, r io.Reader, chunkSize uint32, stats *protoco
 			}
 			return err
 		}
+		buf, _ := r.Peek(r.Buffered())
+
+		if idx, marker := markers.find(buf); idx >= 0 {
+			n, _ := r.Discard(idx)
+			count(n)
+
+			switch {
+			case bytes.Equal(marker, markers.End):
+				return ErrPeerEnded
+			case bytes.Equal(marker, markers.Pause):
+				if pauser.Pause() {
+					log.Info().Msg("Peer paused the test")
+				}
+			default:
+				if pauser.Resume() {
+					log.Info().Msg("Peer resumed the test")
+				}
+			}
+			n, _ = r.Discard(len(marker))
+			if counting.Load() {
+				stats.AddOverheadRcvd(uint64(n))
+			}
+			continue
+		}
+
+		// keep the tail that could be the start of a split marker; the next Peek then refills
+		// the buffer, which takes one read from the connection
+		n, _ := r.Discard(len(buf) - keep)
+		count(n)
+		if counting.Load() {
+			stats.AddReads(1)
+		}
+	}
+}
+
+const (
+	// maxPlausibleRate bounds an interval's throughput in bytes per second (10 Tbps); a faster
+	// interval means the counters are broken rather than the link being fast
+	maxPlausibleRate = 10e12 / 8
+
+	// maxIntervalBurst allows for several maximal chunks being counted at once, which would
+	// exceed the rate bound over a very short final interval
+	maxIntervalBurst = 64 << 20
+)
+
+// counterDelta returns how much a counter grew over an interval. The counters only grow during a
+// test, so one that shrank (reset mid-test) or grew implausibly fast yields a warning and an empty
+// interval rather than a wrapped or garbage figure.
+func counterDelta(name string, current, last uint64, interval time.Duration) uint64 {
+	if current < last {
+		log.Warn().
+			Str("counter", name).
+			Uint64("previous", last).
+			Uint64("current", current).
+			Msg("Stats counter went backwards, skipping its interval")
+		return 0
+	}
+
+	delta := current - last
+	if float64(delta) > maxPlausibleRate*interval.Seconds()+maxIntervalBurst {
+		log.Warn().
+			Str("counter", name).
+			Str("delta", utils.DisplayBytes(delta)).
+			Str("interval", utils.DisplayTime(interval)).
+			Msg("Implausible stats counter increase, skipping its interval")
+		return 0
 	}
+	return delta
 }
 
-func Reporter(ctx context.Context, statsCh chan<- protocol.StatsDiff, stats *protocol.Stats, counting *atomic.Bool, warmup time.Duration) {
-	if warmup > 0 {
-		log.Info().Msgf("Warming up for %s", warmup)
+// Reporter produces a StatsDiff every interval once measurement begins. When the context ends it
+// sends a final partial interval covering the bytes since the last tick, so the intervals sum to
+// the totals unless the counters misbehaved (and a measurement shorter than one interval still
+// produces one), then closes statsCh.
+func Reporter(ctx context.Context, statsCh chan<- protocol.StatsDiff, stats *protocol.Stats, counting *atomic.Bool, warmup WarmupPolicy, interval time.Duration, counters Counters) {
+	defer close(statsCh)
+
+	if !warmup.wait(ctx, stats) {
+		return
 	}
-	<-time.After(warmup)
+	stats.MarkCountStart(time.Now())
 	counting.Store(true)
 
-	tick := time.NewTicker(1 * time.Second)
+	tick := time.NewTicker(interval)
 	defer tick.Stop()
 	t := time.Now()
 
 	var lastBytesSent uint64 = 0
 	var lastBytesRcvd uint64 = 0
+	var lastOverheadSent uint64 = 0
+	var lastOverheadRcvd uint64 = 0
+
+	// diff computes the interval since the previous one
+	diff := func() protocol.StatsDiff {
+		now := time.Now()
+		d := protocol.StatsDiff{Duration: now.Sub(t)}
+		t = now
+
+		if counters&CountSent != 0 {
+			bytesSent := stats.GetBytesSent()
+			d.BytesSent = counterDelta("bytes_sent", bytesSent, lastBytesSent, d.Duration)
+			lastBytesSent = bytesSent
+
+			overheadSent := stats.GetOverheadSent()
+			d.OverheadSent = overheadSent - lastOverheadSent
+			lastOverheadSent = overheadSent
+		}
+		if counters&CountRcvd != 0 {
+			bytesRcvd := stats.GetBytesRcvd()
+			d.BytesRcvd = counterDelta("bytes_rcvd", bytesRcvd, lastBytesRcvd, d.Duration)
+			lastBytesRcvd = bytesRcvd
+
+			overheadRcvd := stats.GetOverheadRcvd()
+			d.OverheadRcvd = overheadRcvd - lastOverheadRcvd
+			lastOverheadRcvd = overheadRcvd
+		}
+		return d
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
+			// the receiver drains statsCh until it is closed, so this send cannot block forever
+			if d := diff(); d.Duration > 0 {
+				statsCh <- d
+			}
 			return
 		case <-tick.C:
-			bytesSent := stats.GetBytesSent()
-			bytesRcvd := stats.GetBytesRcvd()
-
-			now := time.Now()
-			diffSent := bytesSent - lastBytesSent
-			diffRcvd := bytesRcvd - lastBytesRcvd
-			diffTime := now.Sub(t)
-			t = now
-
-			lastBytesSent = bytesSent
-			lastBytesRcvd = bytesRcvd
-
-			statsCh <- protocol.StatsDiff{
-				BytesSent: diffSent,
-				BytesRcvd: diffRcvd,
-				Duration:  diffTime,
+			// fold the tick into the final interval instead. The diff must not be taken unless it is
+			// sent, as it advances the interval start.
+			if ctx.Err() != nil {
+				continue
 			}
+			statsCh <- diff()
 		}
 	}
 }
 
-func Logger(ctx context.Context, statsCh chan protocol.StatsDiff, stats *protocol.Stats, counting *atomic.Bool, warmup time.Duration) {
-	go Reporter(ctx, statsCh, stats, counting, warmup)
+// Dispatch starts the Reporter and forwards each interval it produces to the sinks until the
+// Reporter finishes, including the final partial interval
+func Dispatch(ctx context.Context, statsCh chan protocol.StatsDiff, stats *protocol.Stats, counting *atomic.Bool, warmup WarmupPolicy, interval time.Duration, counters Counters, sinks []StatsSink) {
+	go Reporter(ctx, statsCh, stats, counting, warmup, interval, counters)
 
-	for {
-		diff := <-statsCh
-		evt := log.Info()
-		if diff.BytesSent > 0 {
-			evt = evt.Str("sent", utils.DisplayBitsPerTime(diff.BytesSent, diff.Duration))
-		}
-		if diff.BytesRcvd > 0 {
-			evt = evt.Str("rcvd", utils.DisplayBitsPerTime(diff.BytesRcvd, diff.Duration))
+	for diff := range statsCh {
+		for _, sink := range sinks {
+			sink.Interval(diff)
 		}
-		evt.Msg("Throughput stats")
 	}
 }
 
-func TransferData(ctx context.Context, conn net.Conn, r *bufio.Reader, w *bufio.Writer, chunkSize uint32, duration, warmup time.Duration, stats *protocol.Stats) error {
+// TransferData runs a single-stream transfer with its own stats monitor. The stream applies the
+// test's timeout itself, so cancelling ctx means the caller is shutting down.
+func TransferData(ctx context.Context, conn net.Conn, r *bufio.Reader, w *bufio.Writer, chunkSize uint32, duration, warmup time.Duration, stats *protocol.Stats, opts Options) error {
+	mon := NewMonitor(stats)
+	mon.Start(ctx, opts.warmupPolicy(warmup), opts.getInterval(duration), opts.getCounters(r, w), opts.getSinks())
+	defer mon.Stop()
+
+	return TransferStream(ctx, conn, r, w, chunkSize, duration, warmup, mon, opts)
+}
+
+// TransferStream runs the send/recv loops for one stream, accounting into the given monitor's
+// stats. Multiple concurrent streams may share one monitor so only a single Reporter/Dispatch
+// pair runs for the whole test regardless of the number of streams. Cancelling ctx (rather than
+// reaching the test's timeout) stops the stream without waiting for its loops to drain.
+func TransferStream(ctx context.Context, conn net.Conn, r *bufio.Reader, w *bufio.Writer, chunkSize uint32, duration, warmup time.Duration, mon *Monitor, opts Options) error {
 	// Clear deadline during data transfer
 	_ = conn.SetDeadline(time.Time{})
 
 	totalTime := duration + warmup
 
-	// Create a cancellable context for transfer loops
-	ctx, cancel := context.WithTimeout(ctx, totalTime)
+	// the caller's context signals shutdown, as opposed to the loops' own timeout
+	shutdown := ctx.Done()
+	if opts.ShutdownGrace > 0 {
+		var cancelGrace context.CancelFunc
+		ctx, cancelGrace = WithShutdownGrace(ctx, opts.ShutdownGrace, opts.getInterval(duration), mon.stats)
+		defer cancelGrace()
+	}
+
+	// Create a cancellable context for transfer loops; paused time doesn't count towards it
+	ctx, cancel := withActiveTimeout(ctx, totalTime, opts.Pauser)
 	defer cancel()
 
-	deadline, deadlineOk := ctx.Deadline()
-	var counting atomic.Bool
+	start := time.Now()
+	pausedBefore := opts.Pauser.Total()
+	stats := mon.stats
+	counting := &mon.counting
 
 	count := 0
 	if r != nil {
@@ -147,65 +395,143 @@ func TransferData(ctx context.Context, conn net.Conn, r *bufio.Reader, w *bufio.
 		count++
 	}
 
-	errCh := make(chan error, count)
-	statsCh := make(chan protocol.StatsDiff)
+	// Flush any pending handshake bytes; in direct mode the send loop then writes chunks
+	// straight to the connection so bytes are only counted once the kernel has accepted them
+	// rather than when they land in the bufio buffer, keeping interval stats honest when the
+	// network saturates and avoiding an extra copy through the small bufio buffer
+	var sink io.Writer = conn
+	if w != nil {
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("failed to flush writer before transfer: %w", err)
+		}
+		if opts.WriteMode == WriteBuffered {
+			sink = w
+		}
+	}
 
-	// Start the logger goroutine to periodically log stats
-	go Logger(ctx, statsCh, stats, &counting, warmup)
+	// a locally driven pauser announces each pause and resume to the peer in-band, between chunks
+	var announced chan struct{}
+	if opts.Pauser != nil && opts.PauseLocal {
+		var out io.Writer = conn
+		if w != nil {
+			out = sink
+		}
+		sw := &syncWriter{w: out}
+		sink = sw
+
+		announced = make(chan struct{})
+		go func() {
+			defer close(announced)
+			announcePauses(ctx, sw, opts.Pauser, opts.PauseMarker, opts.ResumeMarker, stats, counting)
+		}()
+	}
+
+	markers := PeerMarkers{End: opts.EndMarker}
+	if opts.Pauser != nil && !opts.PauseLocal {
+		markers.Pause = opts.PauseMarker
+		markers.Resume = opts.ResumeMarker
+	}
+
+	errCh := make(chan error, count)
 
 	// Start both send and recv transfer loops
+	loops := 0
+	spawn := func(loop func() error) {
+		index := loops
+		loops++
+		go func() { errCh <- runPinned(opts.CPUs, index, loop) }()
+	}
 	if w != nil {
-		go func() { errCh <- SendLoop(ctx, w, chunkSize, stats, &counting) }()
+		spawn(func() error {
+			return SendLoop(ctx, sink, chunkSize, opts.ChunkMin, stats, counting, opts.Limiter, opts.BytesTarget, opts.Pauser)
+		})
 	}
 	if r != nil {
-		go func() { errCh <- RecvLoop(ctx, r, chunkSize, stats, &counting) }()
+		if markers.size() > 0 {
+			spawn(func() error { return RecvLoopUntil(ctx, r, stats, counting, markers, opts.Pauser) })
+		} else {
+			spawn(func() error { return RecvLoop(ctx, r, chunkSize, stats, counting) })
+		}
 	}
 
 	var errStop error
+	remaining := count
 	// Wait for either either timeout or an error from one of the loops
 	select {
 	case <-ctx.Done():
 		errStop = ctx.Err()
 	case err := <-errCh:
 		errStop = err
+		remaining--
 		cancel()
 	}
 
-	// Half-close the connection if possible
-	if w != nil {
-		_ = w.Flush()
-		if tc, ok := conn.(*net.TCPConn); ok {
-			_ = tc.CloseWrite()
+	// Unblock any loop still waiting on the connection so it exits promptly and stops
+	// accounting, leaving the connection free for a trailing packet if one follows
+	_ = conn.SetDeadline(time.Now())
+
+	// drain the remaining goroutine results so no loop is still reading once the caller takes
+	// over the connection for trailing packets. On shutdown there are no trailing packets to
+	// protect, so the drain is cut short rather than waiting out each loop's timeout.
+	drainTimer := time.NewTimer(opts.getDrainTimeout())
+	defer drainTimer.Stop()
+drain:
+	for i := 0; i < remaining; i++ {
+		select {
+		case <-errCh:
+		case <-shutdown:
+			break drain
+		case <-drainTimer.C:
+			drainTimer.Reset(opts.getDrainTimeout())
 		}
 	}
+	if announced != nil {
+		cancel()
+		<-announced
+	}
+	_ = conn.SetDeadline(time.Time{})
 
-	// drain the remaining goroutine results
-	for i := 1; i < count; i++ {
-		select {
-		case <-errCh:
-		case <-time.After(100 * time.Millisecond):
+	// Flush anything left in the buffered writer. A failed flush means the peer is gone and some
+	// counted bytes never left this host.
+	var errFlush error
+	if w != nil {
+		if errFlush = w.Flush(); errFlush != nil {
+			stats.MarkFlushFailed()
+			log.Debug().Err(errFlush).Str("unflushed", utils.DisplayBytes(uint64(w.Buffered()))).Msg("Final flush failed")
 		}
 	}
+	// half-close the connection if possible, also when only receiving, so the peer learns this
+	// side is done with the data phase and may follow it with a trailing packet
+	if hc, ok := conn.(halfCloser); ok && !opts.NoHalfClose {
+		_ = hc.CloseWrite()
+	}
 
-	const grace = 250 * time.Millisecond
+	grace := opts.getGrace()
 
-	remaining := time.Duration(0)
-	if deadlineOk {
-		remaining = time.Until(deadline)
-	}
+	// paused time moves the deadline, so time left is measured in active time
+	timeLeft := totalTime - (time.Since(start) - (opts.Pauser.Total() - pausedBefore))
 
 	var premature bool
 	switch {
+	case errFlush != nil:
+		premature = true
+		errStop = errors.Join(errStop, errFlush)
+	case opts.BytesPromised > 0 && (errors.Is(errStop, io.EOF) || errors.Is(errStop, ErrPeerEnded)):
+		// the promise is of measured bytes, so it is checked against this side's measured bytes
+		premature = !opts.PromiseKept(stats, time.Now())
+		if !premature {
+			log.Debug().Str("received", utils.DisplayBytes(stats.GetBytesRcvd())).Msg("Received all promised bytes")
+		}
 	case errStop == nil:
 		premature = false
-	case errors.Is(errStop, context.DeadlineExceeded):
+	case errors.Is(errStop, context.DeadlineExceeded), errors.Is(errStop, ErrPeerEnded):
 		premature = false
 	case errors.Is(errStop, io.EOF):
-		if deadlineOk && remaining > grace {
+		if timeLeft > grace {
 			premature = true
 		}
 	default:
-		if !(deadlineOk && remaining <= grace) {
+		if timeLeft > grace {
 			premature = true
 		}
 	}
@@ -216,3 +542,19 @@ func TransferData(ctx context.Context, conn net.Conn, r *bufio.Reader, w *bufio.
 
 	return nil
 }
+
+// halfCloser is implemented by connections that can shut down their sending side alone, such as
+// TCP connections and TLS connections over them
+type halfCloser interface {
+	CloseWrite() error
+}
+
+// DrainTail reads and discards whatever the peer still sends after the measurement window
+// closed, until it closes its side or the timeout elapses, and returns the number of bytes read
+func DrainTail(conn net.Conn, r io.Reader, timeout time.Duration) uint64 {
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	n, _ := io.Copy(io.Discard, r)
+	return uint64(n)
+}
@@ -0,0 +1,544 @@
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/oklog/ulid/v2"
+	"github.com/rs/zerolog"
+)
+
+func TestMain(m *testing.M) {
+	// the loops log pinning and stream events at debug level
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	os.Exit(m.Run())
+}
+
+// tcpPair returns the two ends of a loopback TCP connection
+func tcpPair(tb testing.TB) (*net.TCPConn, *net.TCPConn) {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatalf("dial: %v", err)
+	}
+	conn := <-accepted
+	if conn == nil {
+		tb.Fatal("accept failed")
+	}
+	tb.Cleanup(func() {
+		dialed.Close()
+		conn.Close()
+	})
+	return dialed.(*net.TCPConn), conn.(*net.TCPConn)
+}
+
+// countingMonitor returns a monitor already counting, with no reporter running
+func countingMonitor(stats *protocol.Stats) *Monitor {
+	mon := NewMonitor(stats)
+	mon.counting.Store(true)
+	return mon
+}
+
+// Both sides of a bidirectional transfer half-close when done. Whatever one side counted as sent
+// must be what the other counted as received, including what it drains after the window. Direct
+// writes are counted once the kernel accepted them, so this holds however the loops are stopped.
+func TestTransferStreamBidiNoTruncation(t *testing.T) {
+	a, b := tcpPair(t)
+	var statsA, statsB protocol.Stats
+	opts := OptionsFromTimeout(time.Second)
+
+	var wg sync.WaitGroup
+	for _, side := range []struct {
+		conn  *net.TCPConn
+		stats *protocol.Stats
+	}{{a, &statsA}, {b, &statsB}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, w := bufio.NewReader(side.conn), bufio.NewWriter(side.conn)
+			err := TransferStream(context.Background(), side.conn, r, w, 8192, 300*time.Millisecond, 0, countingMonitor(side.stats), opts)
+			if err != nil {
+				t.Errorf("TransferStream: %v", err)
+			}
+			// the peer's half-close ends the drain, so nothing it sent is left unread
+			side.stats.AddBytesTail(DrainTail(side.conn, r, 2*time.Second))
+		}()
+	}
+	wg.Wait()
+
+	for _, dir := range []struct {
+		name     string
+		from, to *protocol.Stats
+	}{{"a to b", &statsA, &statsB}, {"b to a", &statsB, &statsA}} {
+		if dir.from.FlushFailed() {
+			t.Errorf("%s: final flush failed", dir.name)
+		}
+		sent := dir.from.GetBytesSent()
+		rcvd := dir.to.GetBytesRcvd() + dir.to.GetBytesTail()
+		if sent == 0 || rcvd != sent {
+			t.Errorf("%s: sent %d bytes, received %d", dir.name, sent, rcvd)
+		}
+	}
+}
+
+// A peer that is gone before the sender's final flush leaves counted bytes in the buffer, which
+// must be flagged rather than silently dropped.
+func TestTransferStreamPeerClosedBeforeFlush(t *testing.T) {
+	a, b := tcpPair(t)
+	_ = b.SetLinger(0)
+	b.Close()
+
+	var stats protocol.Stats
+	opts := OptionsFromTimeout(time.Second)
+	opts.WriteMode = WriteBuffered
+
+	start := time.Now()
+	err := TransferStream(context.Background(), a, nil, bufio.NewWriter(a), 8192, 5*time.Second, 0, countingMonitor(&stats), opts)
+	if err != nil {
+		t.Fatalf("TransferStream: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("transfer took %s after the peer closed", elapsed)
+	}
+	if !stats.FlushFailed() {
+		t.Error("final flush to a closed peer was not flagged")
+	}
+}
+
+// Data still queued in large socket buffers when the window closes is all read by DrainTail,
+// which ends at the peer's half-close or, failing that, at its timeout.
+func TestDrainTailLargeBuffers(t *testing.T) {
+	const size = 4 << 20
+	for _, halfClose := range []bool{true, false} {
+		name := "half-close"
+		if !halfClose {
+			name = "timeout"
+		}
+		t.Run(name, func(t *testing.T) {
+			a, b := tcpPair(t)
+			_ = a.SetWriteBuffer(size)
+			_ = b.SetReadBuffer(size)
+
+			written := make(chan error, 1)
+			go func() {
+				_, err := a.Write(make([]byte, size))
+				if err == nil && halfClose {
+					err = a.CloseWrite()
+				}
+				written <- err
+			}()
+
+			timeout := 2 * time.Second
+			if !halfClose {
+				timeout = 500 * time.Millisecond
+			}
+			start := time.Now()
+			n := DrainTail(b, bufio.NewReader(b), timeout)
+			elapsed := time.Since(start)
+			if err := <-written; err != nil {
+				t.Fatalf("write: %v", err)
+			}
+
+			if n != size {
+				t.Errorf("drained %d bytes, want %d", n, size)
+			}
+			if halfClose && elapsed >= timeout {
+				t.Errorf("drain took %s, want it to end at the half-close", elapsed)
+			}
+			if !halfClose && elapsed < timeout {
+				t.Errorf("drain ended after %s without a half-close, want %s", elapsed, timeout)
+			}
+			if !halfClose {
+				// the read deadline is cleared for whatever follows the tail
+				go a.Write([]byte{1})
+				if _, err := b.Read(make([]byte, 1)); err != nil {
+					t.Errorf("read after drain: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// A byte target applies to the sender's measured bytes, and the receiver, which cannot know how
+// much the sender sent during its warmup, must keep reading until the sender's half-close rather
+// than stop at a count. With a warmup larger than the target, stopping at the count would end the
+// transfer well short of it.
+func TestTransferStreamBytesTargetWithWarmup(t *testing.T) {
+	const (
+		target    = 8 << 20
+		chunkSize = 16 << 10
+		rate      = 128 << 20 // bits per second, 16 MiB/s
+		warmup    = 400 * time.Millisecond
+	)
+	sender, receiver := tcpPair(t)
+	var sent, rcvd protocol.Stats
+
+	opts := OptionsFromTimeout(time.Second)
+
+	done := make(chan error, 1)
+	go func() {
+		sendOpts := opts
+		sendOpts.Sinks = []StatsSink{&IntervalRecorder{}}
+		sendOpts.BytesTarget = target
+		sendOpts.Limiter = NewLimiter(rate, chunkSize)
+		err := TransferData(context.Background(), sender, nil, bufio.NewWriter(sender), chunkSize, 10*time.Second, warmup, &sent, sendOpts)
+		done <- err
+	}()
+
+	recvOpts := opts
+	recvOpts.Sinks = []StatsSink{&IntervalRecorder{}}
+	recvOpts.BytesPromised = target
+	start := time.Now()
+	if err := TransferData(context.Background(), receiver, bufio.NewReader(receiver), nil, chunkSize, 10*time.Second, warmup, &rcvd, recvOpts); err != nil {
+		t.Fatalf("receiving TransferData: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("sending TransferData: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("transfer took %s, want it to end at the target", elapsed)
+	}
+
+	if sent.FlushFailed() {
+		t.Error("final flush failed")
+	}
+	if got := sent.GetBytesSent(); got != target {
+		t.Errorf("sender measured %d bytes, want the target %d", got, target)
+	}
+	if sent.GetBytesWarmup() == 0 {
+		t.Fatal("sender sent nothing during the warmup")
+	}
+	total := sent.GetBytesWarmup() + sent.GetBytesSent()
+	if got := rcvd.GetBytesWarmup() + rcvd.GetBytesRcvd() + rcvd.GetBytesTail(); got != total {
+		t.Errorf("receiver got %d bytes in all, sender sent %d", got, total)
+	}
+	// the two ends' warmup boundaries are apart by no more than a few chunks on loopback
+	if got := rcvd.GetBytesRcvd(); got+target/20 < target || got > target+target/20 {
+		t.Errorf("receiver measured %d bytes, want about the target %d", got, target)
+	}
+	if !recvOpts.PromiseKept(&rcvd, time.Now()) {
+		t.Errorf("receiver measured %d bytes, which does not keep the promise of %d", rcvd.GetBytesRcvd(), target)
+	}
+}
+
+// A promised byte count is of measured bytes: bytes received during this side's warmup never make
+// up for missing measured ones, while a shortfall the measured rate covers within the grace (the
+// two ends' windows being offset by the link latency) still completes the promise.
+func TestOptionsPromiseKept(t *testing.T) {
+	const promised = 10 << 20
+	end := time.Now()
+	opts := Options{Grace: 100 * time.Millisecond, BytesPromised: promised}
+
+	for _, tc := range []struct {
+		name         string
+		warmup, rcvd uint64
+		measured     time.Duration
+		want         bool
+	}{
+		{"exact", 1 << 20, promised, time.Second, true},
+		{"more", 0, promised + 1, time.Second, true},
+		{"within grace", 1 << 20, promised - promised/20, time.Second, true},
+		{"beyond grace", 0, promised - promised/5, time.Second, false},
+		{"warmup makes up the difference", promised / 2, promised / 2, time.Second, false},
+		{"never measured", promised, 0, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var stats protocol.Stats
+			stats.AddBytesWarmup(tc.warmup)
+			stats.AddBytesRcvd(tc.rcvd)
+			if tc.measured > 0 {
+				stats.MarkCountStart(end.Add(-tc.measured))
+			}
+			if got := opts.PromiseKept(&stats, end); got != tc.want {
+				t.Errorf("PromiseKept with %d warmup and %d measured bytes = %v, want %v", tc.warmup, tc.rcvd, got, tc.want)
+			}
+		})
+	}
+}
+
+// stallReader returns (0, nil) stalls times before each read of data, then io.EOF
+type stallReader struct {
+	stalls int
+	data   []byte
+	empty  int
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	if r.empty < r.stalls {
+		r.empty++
+		return 0, nil
+	}
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	r.empty = 0
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestRecvLoopEmptyReads(t *testing.T) {
+	tests := []struct {
+		name   string
+		stalls int
+		want   error
+		rcvd   uint64
+	}{
+		{"recovers", maxConsecutiveEmptyReads - 1, io.EOF, 10},
+		{"no progress", maxConsecutiveEmptyReads, io.ErrNoProgress, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats protocol.Stats
+			var counting atomic.Bool
+			counting.Store(true)
+
+			r := &stallReader{stalls: tt.stalls, data: []byte("0123456789")}
+			err := RecvLoop(context.Background(), r, 4, &stats, &counting)
+			if err != tt.want {
+				t.Fatalf("RecvLoop = %v, want %v", err, tt.want)
+			}
+			if got := stats.GetBytesRcvd(); got != tt.rcvd {
+				t.Errorf("received %d bytes, want %d", got, tt.rcvd)
+			}
+		})
+	}
+}
+
+// patternReader serves remaining bytes of SendLoop's chunk pattern, then io.EOF
+type patternReader struct {
+	pattern   []byte
+	remaining int
+}
+
+func newPatternReader(chunkSize uint32, total int) *patternReader {
+	pattern := make([]byte, chunkSize)
+	for i := range pattern {
+		pattern[i] = byte(i)
+	}
+	return &patternReader{pattern: pattern, remaining: total}
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && n < r.remaining {
+		n += copy(p[n:min(len(p), r.remaining)], r.pattern)
+	}
+	r.remaining -= n
+	return n, nil
+}
+
+const benchChunkSize = 128 << 10
+
+func benchmarkRecv(b *testing.B, recv func(r io.Reader, stats *protocol.Stats, counting *atomic.Bool) error) {
+	var stats protocol.Stats
+	var counting atomic.Bool
+	counting.Store(true)
+
+	b.SetBytes(benchChunkSize)
+	b.ResetTimer()
+	err := recv(newPatternReader(benchChunkSize, b.N*benchChunkSize), &stats, &counting)
+	if err != io.EOF {
+		b.Fatalf("receive loop ended with %v, want io.EOF", err)
+	}
+	if got := stats.GetBytesRcvd(); got != uint64(b.N*benchChunkSize) {
+		b.Fatalf("received %d bytes, want %d", got, b.N*benchChunkSize)
+	}
+}
+
+func BenchmarkRecvLoop(b *testing.B) {
+	benchmarkRecv(b, func(r io.Reader, stats *protocol.Stats, counting *atomic.Bool) error {
+		return RecvLoop(context.Background(), r, benchChunkSize, stats, counting)
+	})
+}
+
+// RecvLoopUntil additionally scans every byte for the end marker, which is why the default
+// completion doesn't use it
+func BenchmarkRecvLoopUntil(b *testing.B) {
+	markers := PeerMarkers{End: packets.ResultMarker(ulid.Make())}
+	benchmarkRecv(b, func(r io.Reader, stats *protocol.Stats, counting *atomic.Bool) error {
+		return RecvLoopUntil(context.Background(), bufio.NewReaderSize(r, benchChunkSize), stats, counting, markers, nil)
+	})
+}
+
+// benchmarkSend sends b.N chunks over loopback TCP to a peer discarding them, through the writer
+// wrap returns for the connection
+func benchmarkSend(b *testing.B, wrap func(conn net.Conn) io.Writer) {
+	a, peer := tcpPair(b)
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		_, _ = io.Copy(io.Discard, peer)
+	}()
+
+	var stats protocol.Stats
+	var counting atomic.Bool
+	counting.Store(true)
+	w := wrap(a)
+
+	b.SetBytes(benchChunkSize)
+	b.ResetTimer()
+	err := SendLoop(context.Background(), w, benchChunkSize, 0, &stats, &counting, nil, uint64(b.N)*benchChunkSize, nil)
+	if err != nil {
+		b.Fatalf("SendLoop: %v", err)
+	}
+	if bw, ok := w.(*bufio.Writer); ok {
+		if err := bw.Flush(); err != nil {
+			b.Fatalf("Flush: %v", err)
+		}
+	}
+	_ = a.CloseWrite()
+	<-drained
+}
+
+func BenchmarkSendLoopDirect(b *testing.B) {
+	benchmarkSend(b, func(conn net.Conn) io.Writer { return conn })
+}
+
+// the handshake's default-sized bufio.Writer, which each chunk is copied through
+func BenchmarkSendLoopBuffered(b *testing.B) {
+	benchmarkSend(b, func(conn net.Conn) io.Writer { return bufio.NewWriter(conn) })
+}
+
+// runReporter runs a Reporter counting both directions over stats until stop returns, then
+// collects every interval it produced including the final partial one. stop may watch the
+// intervals as they are produced.
+func runReporter(t *testing.T, stats *protocol.Stats, interval time.Duration, stop func(diffs <-chan protocol.StatsDiff)) []protocol.StatsDiff {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	statsCh := make(chan protocol.StatsDiff)
+	var counting atomic.Bool
+	go Reporter(ctx, statsCh, stats, &counting, WarmupPolicy{}, interval, CountBoth)
+
+	observed := make(chan protocol.StatsDiff, 64)
+	var diffs []protocol.StatsDiff
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(observed)
+		for d := range statsCh {
+			diffs = append(diffs, d)
+			select {
+			case observed <- d:
+			default:
+			}
+		}
+	}()
+
+	stop(observed)
+	cancel()
+	<-done
+	return diffs
+}
+
+func TestReporterIntervalsSumToTotal(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		run      time.Duration
+	}{
+		{"stops mid-interval", 20 * time.Millisecond, 70 * time.Millisecond},
+		{"shorter than one interval", time.Second, 30 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats protocol.Stats
+			diffs := runReporter(t, &stats, tt.interval, func(<-chan protocol.StatsDiff) {
+				for end := time.Now().Add(tt.run); time.Now().Before(end); time.Sleep(time.Millisecond) {
+					stats.AddBytesSent(1000)
+					stats.AddBytesRcvd(500)
+				}
+			})
+
+			if want := int(tt.run / tt.interval); len(diffs) < want+1 {
+				t.Errorf("got %d intervals, want at least %d", len(diffs), want+1)
+			}
+			var sent, rcvd uint64
+			for _, d := range diffs {
+				sent += d.BytesSent
+				rcvd += d.BytesRcvd
+			}
+			if sent != stats.GetBytesSent() || rcvd != stats.GetBytesRcvd() {
+				t.Errorf("intervals sum to %d sent, %d rcvd; totals are %d sent, %d rcvd", sent, rcvd, stats.GetBytesSent(), stats.GetBytesRcvd())
+			}
+		})
+	}
+}
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name          string
+		current, last uint64
+		interval      time.Duration
+		want          uint64
+	}{
+		{"growth", 3000, 1000, time.Second, 2000},
+		{"unchanged", 1000, 1000, time.Second, 0},
+		{"reset", 10, 1000, time.Second, 0},
+		{"burst over a short interval", maxIntervalBurst, 0, time.Microsecond, maxIntervalBurst},
+		{"implausible", 1 << 60, 0, time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := counterDelta("bytes_sent", tt.current, tt.last, tt.interval); got != tt.want {
+				t.Errorf("counterDelta(%d, %d, %s) = %d, want %d", tt.current, tt.last, tt.interval, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReporterCounterReset(t *testing.T) {
+	var stats protocol.Stats
+	diffs := runReporter(t, &stats, 50*time.Millisecond, func(observed <-chan protocol.StatsDiff) {
+		stats.AddBytesSent(1000)
+		<-observed
+
+		// the interval spanning the reset is skipped rather than wrapping around
+		stats.Reset()
+		<-observed
+
+		stats.AddBytesSent(500)
+	})
+
+	if len(diffs) < 3 {
+		t.Fatalf("got %d intervals, want at least 3", len(diffs))
+	}
+	want := []uint64{1000, 0}
+	for i, d := range diffs[:2] {
+		if d.BytesSent != want[i] {
+			t.Errorf("interval %d sent %d, want %d", i, d.BytesSent, want[i])
+		}
+	}
+	var after uint64
+	for _, d := range diffs[2:] {
+		after += d.BytesSent
+	}
+	if after != 500 {
+		t.Errorf("intervals after the reset sent %d, want 500", after)
+	}
+}
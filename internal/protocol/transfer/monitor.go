@@ -0,0 +1,51 @@
+package transfer
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+)
+
+// Counters selects which byte counters the reporter tracks. A test whose direction precludes one
+// of them skips it entirely, rather than reporting an interval of zeros every tick.
+type Counters uint8
+
+const (
+	CountSent Counters = 1 << iota
+	CountRcvd
+
+	CountBoth = CountSent | CountRcvd
+)
+
+// Monitor owns the stats and counting state shared by one or more concurrent streams
+type Monitor struct {
+	stats    *protocol.Stats
+	counting atomic.Bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+func NewMonitor(stats *protocol.Stats) *Monitor {
+	return &Monitor{stats: stats}
+}
+
+// Start launches the Dispatch/Reporter pair, which runs until Stop is called. It is detached from
+// the context's cancellation so the final partial interval includes bytes counted while the
+// streams wind down; Stop must be called once they have.
+func (m *Monitor) Start(ctx context.Context, warmup WarmupPolicy, interval time.Duration, counters Counters, sinks []StatsSink) {
+	ctx, m.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+		Dispatch(ctx, make(chan protocol.StatsDiff), m.stats, &m.counting, warmup, interval, counters, sinks)
+	}()
+}
+
+// Stop ends monitoring and waits for the final interval to reach the sinks
+func (m *Monitor) Stop() {
+	m.cancel()
+	<-m.done
+}
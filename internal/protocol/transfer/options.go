@@ -0,0 +1,152 @@
+package transfer
+
+import (
+	"bufio"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+)
+
+// WriteMode selects how the send loop writes chunks during the data phase
+type WriteMode uint8
+
+const (
+	WriteDirect   WriteMode = 0 // write chunks straight to the connection, counting bytes the kernel accepted
+	WriteBuffered WriteMode = 1 // write chunks through the handshake's bufio.Writer, adding a copy per chunk
+)
+
+const (
+	DEFAULT_GRACE           = 250 * time.Millisecond
+	DEFAULT_DRAIN_TIMEOUT   = 100 * time.Millisecond
+	DEFAULT_REPORT_INTERVAL = 1 * time.Second
+)
+
+const (
+	minReportIntervals = 2                      // intervals a short test is split into at least
+	minReportInterval  = 125 * time.Millisecond // the interval is never shrunk below this
+)
+
+// Options tunes how a transfer detects and winds down its completion.
+//
+// When the first loop stops, the remaining loops are given up to DrainTimeout each to exit.
+// The stop is then classified: a loop ending with an error (or EOF from the peer) while more
+// than Grace remains before the deadline is reported as premature. A larger Grace tolerates
+// peers whose clocks or link latency cause them to finish slightly early; a smaller one flags
+// disconnects more eagerly.
+//
+// When the peer has promised a byte count (a fixed-byte test), its half-close or end marker marks
+// the end of the transfer instead: the peer's warmup volume is unknown here, so the count alone
+// cannot. Ending with the promised bytes measured (see PromiseKept) is a clean finish regardless
+// of the time left, and ending short of them is premature, so the timing heuristic is not used.
+type Options struct {
+	Grace         time.Duration // how early a stream may end before it is flagged as premature
+	DrainTimeout  time.Duration // how long to wait for each remaining loop after the first one stops
+	PrimeBytes    uint64        // if non-zero, begin measuring after this many bytes instead of after the warmup
+	AdaptWarmup   bool          // begin measuring once throughput stabilizes, with the warmup as a cap
+	NoHalfClose   bool          // keep the write side open after the data phase so a trailing packet can follow
+	WriteMode     WriteMode     // how chunks are written during the data phase (direct by default)
+	ChunkMin      uint32        // if non-zero, each write is a random size from this up to the chunk size
+	Limiter       *Limiter      // paces the send loop if non-nil (may be shared between streams)
+	BytesTarget   uint64        // if non-zero, the sender stops once this many bytes have been counted
+	BytesPromised uint64        // if non-zero, the peer sends this many measured bytes then finishes (see below)
+	Sinks         []StatsSink   // receive interval stats and the final report (console logging if empty)
+	EndMarker     []byte        // if set, receiving stops at this marker from the peer (explicit completion)
+	Pauser        *Pauser       // if set, sending stops while paused and paused time extends the deadline
+	PauseLocal    bool          // the Pauser is driven locally and announced to the peer, rather than by the peer
+	PauseMarker   []byte        // packet announcing a pause, written to or recognized from the peer
+	ResumeMarker  []byte        // packet announcing a resume, written to or recognized from the peer
+	Interval      time.Duration // how often interval stats are reported (derived from the duration if zero)
+	CPUs          []int         // if set, pin each transfer loop's thread to one of these CPUs (Linux only)
+	Counters      Counters      // byte counters the reporter tracks (derived from the loops run if zero)
+	StrayMarker   []byte        // datagram the receiver discards uncounted, such as a repeated registration (datagram transfers)
+	ShutdownGrace time.Duration // on shutdown, keep transferring until the current interval completes, for at most this long
+}
+
+// OptionsFromTimeout derives completion timings from an endpoint's network timeout so
+// high-latency deployments (with larger timeouts) don't spuriously flag premature disconnects
+func OptionsFromTimeout(timeout time.Duration) Options {
+	return Options{
+		Grace:        timeout / 4,
+		DrainTimeout: timeout / 10,
+	}
+}
+
+// PromiseKept reports whether the measured bytes received make up the peer's promised byte count.
+// The two ends begin measuring apart by up to the link latency, so the bytes arriving at the
+// measured rate within the grace may have fallen into this side's warmup instead.
+func (o Options) PromiseKept(stats *protocol.Stats, end time.Time) bool {
+	rcvd := stats.GetBytesRcvd()
+	if rcvd >= o.BytesPromised {
+		return true
+	}
+	measured := stats.MeasuredDuration(end)
+	if measured <= 0 {
+		return false
+	}
+	slack := float64(rcvd) * o.getGrace().Seconds() / measured.Seconds()
+	return float64(rcvd)+slack >= float64(o.BytesPromised)
+}
+
+func (o Options) getGrace() time.Duration {
+	if o.Grace <= 0 {
+		return DEFAULT_GRACE
+	}
+	return o.Grace
+}
+
+func (o Options) getDrainTimeout() time.Duration {
+	if o.DrainTimeout <= 0 {
+		return DEFAULT_DRAIN_TIMEOUT
+	}
+	return o.DrainTimeout
+}
+
+// getInterval returns the report interval, DefaultInterval unless set
+func (o Options) getInterval(duration time.Duration) time.Duration {
+	if o.Interval > 0 {
+		return o.Interval
+	}
+	return DefaultInterval(duration)
+}
+
+// DefaultInterval returns the report interval of a test of the given duration: DEFAULT_REPORT_INTERVAL,
+// halved until the test spans at least minReportIntervals of them, so short tests still show how
+// throughput developed rather than a single interval matching the summary.
+func DefaultInterval(duration time.Duration) time.Duration {
+	interval := DEFAULT_REPORT_INTERVAL
+	for interval > minReportInterval && duration < interval*minReportIntervals {
+		interval /= 2
+	}
+	return max(interval, minReportInterval)
+}
+
+func (o Options) warmupPolicy(warmup time.Duration) WarmupPolicy {
+	return WarmupPolicy{
+		Duration:   warmup,
+		PrimeBytes: o.PrimeBytes,
+		Adaptive:   o.AdaptWarmup,
+	}
+}
+
+// getCounters returns the counters to track. Unless set, a send loop implies the sent counter and a
+// receive loop the received one; a reader carrying only the peer's control packets must set them.
+func (o Options) getCounters(r *bufio.Reader, w *bufio.Writer) Counters {
+	if o.Counters != 0 {
+		return o.Counters
+	}
+	var counters Counters
+	if w != nil {
+		counters |= CountSent
+	}
+	if r != nil {
+		counters |= CountRcvd
+	}
+	return counters
+}
+
+func (o Options) getSinks() []StatsSink {
+	if len(o.Sinks) == 0 {
+		return []StatsSink{ConsoleSink{}}
+	}
+	return o.Sinks
+}
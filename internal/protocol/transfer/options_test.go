@@ -0,0 +1,48 @@
+package transfer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+)
+
+func TestDefaultInterval(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		want     time.Duration
+	}{
+		{10 * time.Second, time.Second},
+		{2 * time.Second, time.Second},
+		{2*time.Second - time.Millisecond, 500 * time.Millisecond},
+		{time.Second, 500 * time.Millisecond},
+		{500 * time.Millisecond, 250 * time.Millisecond},
+		{300 * time.Millisecond, minReportInterval},
+		{0, minReportInterval},
+	}
+	for _, tt := range tests {
+		if got := DefaultInterval(tt.duration); got != tt.want {
+			t.Errorf("DefaultInterval(%s) = %s, want %s", tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestReporterSubSecondInterval(t *testing.T) {
+	const duration = 300 * time.Millisecond
+	interval := DefaultInterval(duration)
+
+	var stats protocol.Stats
+	diffs := runReporter(t, &stats, interval, func(<-chan protocol.StatsDiff) {
+		time.Sleep(duration)
+	})
+
+	// a short test is still split into several intervals, all but the last a full one
+	if len(diffs) < minReportIntervals {
+		t.Fatalf("got %d intervals, want at least %d", len(diffs), minReportIntervals)
+	}
+	for i, d := range diffs[:len(diffs)-1] {
+		if d.Duration < interval*9/10 || d.Duration > interval*2 {
+			t.Errorf("interval %d lasted %s, want about %s", i, d.Duration, interval)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package transfer
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/rs/zerolog/log"
+)
+
+// pauseSpan is a completed pause
+type pauseSpan struct {
+	start, end time.Time
+}
+
+// Pauser tracks whether a transfer is paused and for how long. While paused the send loop stops
+// between chunks, and the paused time extends the transfer's deadline so the test still measures
+// its full duration. It is safe for concurrent use; the zero value is not, use NewPauser.
+type Pauser struct {
+	mu      sync.Mutex
+	limit   time.Duration // total paused time allowed (0 is unlimited)
+	paused  bool
+	since   time.Time     // when the current pause began
+	spans   []pauseSpan   // completed pauses
+	expiry  *time.Timer   // resumes the current pause once the limit is used up
+	changed chan struct{} // closed and replaced on every state change
+}
+
+// NewPauser creates a running Pauser. A non-zero limit caps the total paused time: a pause that
+// exhausts it is resumed automatically, and later pauses are refused.
+func NewPauser(limit time.Duration) *Pauser {
+	return &Pauser{
+		limit:   limit,
+		changed: make(chan struct{}),
+	}
+}
+
+// Pause pauses the transfer, returning false if it was already paused or the limit is used up
+func (p *Pauser) Pause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.paused {
+		return false
+	}
+
+	var remaining time.Duration
+	if p.limit > 0 {
+		remaining = p.limit - p.total(time.Now())
+		if remaining <= 0 {
+			return false
+		}
+	}
+
+	p.paused = true
+	p.since = time.Now()
+	if remaining > 0 {
+		p.expiry = time.AfterFunc(remaining, p.expire)
+	}
+	p.notify()
+	return true
+}
+
+// Resume resumes the transfer, returning false if it was not paused
+func (p *Pauser) Resume() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.resume()
+}
+
+// Toggle pauses a running transfer or resumes a paused one, returning whether it is now paused
+func (p *Pauser) Toggle() bool {
+	p.mu.Lock()
+	paused := p.paused
+	p.mu.Unlock()
+
+	if paused {
+		p.Resume()
+	} else {
+		p.Pause()
+	}
+	return p.Paused()
+}
+
+func (p *Pauser) expire() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resume() {
+		log.Warn().Str("limit", p.limit.String()).Msg("Pause limit reached, resuming")
+	}
+}
+
+func (p *Pauser) resume() bool {
+	if !p.paused {
+		return false
+	}
+	if p.expiry != nil {
+		p.expiry.Stop()
+		p.expiry = nil
+	}
+
+	end := time.Now()
+	if p.limit > 0 {
+		// a late expiry must not count beyond the limit
+		if limitEnd := p.since.Add(p.limit - p.total(p.since)); limitEnd.Before(end) {
+			end = limitEnd
+		}
+	}
+	p.spans = append(p.spans, pauseSpan{start: p.since, end: end})
+	p.paused = false
+	p.notify()
+	return true
+}
+
+func (p *Pauser) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// total returns the paused time up to now, including any pause in progress
+func (p *Pauser) total(now time.Time) time.Duration {
+	var d time.Duration
+	for _, span := range p.spans {
+		d += span.end.Sub(span.start)
+	}
+	if p.paused {
+		d += now.Sub(p.since)
+	}
+	return d
+}
+
+// Paused reports whether the transfer is currently paused
+func (p *Pauser) Paused() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// Total returns the time spent paused, including any pause in progress
+func (p *Pauser) Total() time.Duration {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total(time.Now())
+}
+
+// PausedSince returns the time spent paused after t, including any pause in progress
+func (p *Pauser) PausedSince(t time.Time) time.Duration {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	spans := p.spans
+	if p.paused {
+		spans = append(spans[:len(spans):len(spans)], pauseSpan{start: p.since, end: now})
+	}
+
+	var d time.Duration
+	for _, span := range spans {
+		start := span.start
+		if start.Before(t) {
+			start = t
+		}
+		if span.end.After(start) {
+			d += span.end.Sub(start)
+		}
+	}
+	return d
+}
+
+// Changed returns a channel closed on the next pause or resume
+func (p *Pauser) Changed() <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.changed
+}
+
+// Wait blocks while the transfer is paused, returning an error if the context ends first
+func (p *Pauser) Wait(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		paused, changed := p.paused, p.changed
+		p.mu.Unlock()
+
+		if !paused {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// withActiveTimeout is like context.WithTimeout, except that with a non-nil Pauser only time spent
+// unpaused counts towards the timeout
+func withActiveTimeout(ctx context.Context, timeout time.Duration, p *Pauser) (context.Context, context.CancelFunc) {
+	if p == nil {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	start := time.Now()
+	base := p.Total()
+
+	go func() {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+
+			// the deadline can't pass while paused, so wait for the resume before checking again
+			changed := p.Changed()
+			if p.Paused() {
+				select {
+				case <-ctx.Done():
+					return
+				case <-changed:
+				}
+			}
+
+			active := time.Since(start) - (p.Total() - base)
+			if active >= timeout {
+				cancel()
+				return
+			}
+			timer.Reset(timeout - active)
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// syncWriter serializes writes from the send loop and the pause announcer so a Pause or Resume
+// packet is never interleaved with a chunk
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(b)
+}
+
+// announcePauses writes the pause or resume marker to the peer whenever the Pauser changes state,
+// until the context is done. Markers written while counting are recorded as overhead.
+func announcePauses(ctx context.Context, w io.Writer, p *Pauser, pause, resume []byte, stats *protocol.Stats, counting *atomic.Bool) {
+	announced := false
+	for {
+		changed := p.Changed()
+		if paused := p.Paused(); paused != announced {
+			marker := resume
+			if paused {
+				marker = pause
+			}
+			n, err := w.Write(marker)
+			if counting.Load() {
+				stats.AddOverheadSent(uint64(n))
+			}
+			if err != nil {
+				log.Debug().Err(err).Msg("Failed to announce pause state to the peer")
+				return
+			}
+			announced = paused
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package transfer
+
+import (
+	"context"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+)
+
+// intervalSlack is how far ahead of a reporting interval's end a graceful shutdown stops the
+// transfer, so the interval is reported whole as the final one rather than followed by a sliver
+const intervalSlack = 5 * time.Millisecond
+
+// untilIntervalEnd returns how long until the current reporting interval would end, or zero if
+// measurement hasn't started and there is no interval to complete
+func untilIntervalEnd(stats *protocol.Stats, interval time.Duration) time.Duration {
+	start := stats.GetCountStart()
+	if start.IsZero() || interval <= 0 {
+		return 0
+	}
+	return max(0, interval-time.Since(start)%interval-intervalSlack)
+}
+
+// WithShutdownGrace returns a context that outlives the cancellation of ctx until the current
+// reporting interval of stats completes, or for at most grace, so a transfer interrupted by a
+// shutdown still ends on a whole interval. Options.ShutdownGrace applies it to the caller's
+// context; a caller whose context is also cancelled for other reasons can apply it to the
+// shutdown signal alone.
+func WithShutdownGrace(ctx context.Context, grace, interval time.Duration, stats *protocol.Stats) (context.Context, context.CancelFunc) {
+	graceCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	// a timer still pending when the transfer ends only cancels it again
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(min(grace, untilIntervalEnd(stats, interval)), cancel)
+	})
+	return graceCtx, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,130 @@
+package transfer
+
+import (
+	"io"
+	"slices"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/report"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// StatsSink receives a test's throughput as it progresses and its report once it completes.
+// Implementations are called from a single goroutine per test and should not block for long, as
+// intervals are not buffered.
+type StatsSink interface {
+	Interval(diff protocol.StatsDiff) // called once per reporting interval during measurement
+	Final(rpt *report.Report)         // called once with the completed test's report
+}
+
+// ConsoleSink logs interval throughput, the default when no sinks are configured. The endpoints
+// log their own summary, so Final does nothing.
+type ConsoleSink struct{}
+
+func (ConsoleSink) Interval(diff protocol.StatsDiff) {
+	evt := log.Info()
+	if diff.BytesSent > 0 {
+		evt = evt.Str("sent", utils.DisplayBitsPerTime(diff.BytesSent, diff.Duration))
+	}
+	if diff.BytesRcvd > 0 {
+		evt = evt.Str("rcvd", utils.DisplayBitsPerTime(diff.BytesRcvd, diff.Duration))
+	}
+	evt.Msg("Throughput stats")
+}
+
+func (ConsoleSink) Final(rpt *report.Report) {}
+
+// SparklineSink collects each interval's throughput and logs it as a sparkline per direction
+// once the test completes, showing its shape (ramp-up, stalls, steady state) at a glance. It may
+// be reused across consecutive tests, but not concurrent ones.
+type SparklineSink struct {
+	sent, rcvd []float64 // bits per second of each interval
+	durations  []time.Duration
+}
+
+func (s *SparklineSink) Interval(diff protocol.StatsDiff) {
+	if diff.Duration <= 0 {
+		return
+	}
+	s.durations = append(s.durations, diff.Duration)
+	seconds := diff.Duration.Seconds()
+	s.sent = append(s.sent, float64(diff.BytesSent)*8/seconds)
+	s.rcvd = append(s.rcvd, float64(diff.BytesRcvd)*8/seconds)
+}
+
+func (s *SparklineSink) Final(rpt *report.Report) {
+	defer func() {
+		s.sent, s.rcvd, s.durations = nil, nil, nil
+	}()
+
+	// a short final partial interval is too noisy to show, and would look like a stall
+	if n := len(s.durations); n > 1 && s.durations[n-1] < s.durations[0]/2 {
+		s.sent, s.rcvd = s.sent[:n-1], s.rcvd[:n-1]
+	}
+
+	for _, dir := range []struct {
+		name  string
+		rates []float64
+		total uint64
+	}{
+		{"sent", s.sent, rpt.BytesSent},
+		{"rcvd", s.rcvd, rpt.BytesRcvd},
+	} {
+		if dir.total == 0 || len(dir.rates) == 0 {
+			continue
+		}
+		log.Info().
+			Str("direction", dir.name).
+			Str("peak", utils.DisplayBitsPerTime(uint64(slices.Max(dir.rates)/8), time.Second)).
+			Msgf("Throughput %s", utils.Sparkline(dir.rates))
+	}
+}
+
+// IntervalRecorder keeps each interval of a test, for the samples in its report. It may not be
+// reused across tests.
+type IntervalRecorder struct {
+	intervals []report.Interval
+	elapsed   time.Duration
+}
+
+func (r *IntervalRecorder) Interval(diff protocol.StatsDiff) {
+	r.intervals = append(r.intervals, report.Interval{
+		Start:     r.elapsed,
+		Duration:  diff.Duration,
+		BytesSent: diff.BytesSent,
+		BytesRcvd: diff.BytesRcvd,
+	})
+	r.elapsed += diff.Duration
+}
+
+func (r *IntervalRecorder) Final(rpt *report.Report) {}
+
+// Intervals returns the intervals recorded so far
+func (r *IntervalRecorder) Intervals() []report.Interval {
+	return r.intervals
+}
+
+// EncodeSink writes each completed test's report to W in the chosen format, such as stdout for a
+// collector consuming the output. It ignores intervals.
+type EncodeSink struct {
+	W      io.Writer
+	Format report.Format
+}
+
+func (EncodeSink) Interval(diff protocol.StatsDiff) {}
+
+func (s EncodeSink) Final(rpt *report.Report) {
+	err := report.Encode(s.W, rpt, s.Format)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to output report")
+	}
+}
+
+// Final hands the completed test's report to each configured sink
+func (o Options) Final(rpt *report.Report) {
+	for _, sink := range o.getSinks() {
+		sink.Final(rpt)
+	}
+}
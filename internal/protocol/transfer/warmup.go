@@ -0,0 +1,116 @@
+package transfer
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// primePollInterval is how often the reporter checks whether the priming bytes have been transferred
+const primePollInterval = 10 * time.Millisecond
+
+const (
+	adaptiveSampleInterval = 250 * time.Millisecond // how often warmup throughput is sampled
+	adaptiveSteadySamples  = 2                      // consecutive steady samples that end the warmup
+	adaptiveTolerance      = 0.10                   // relative change between samples still considered steady
+)
+
+// WarmupPolicy decides when measurement begins. By default it begins after Duration. With a
+// non-zero PrimeBytes it begins once that many bytes have been transferred, and with Adaptive once
+// the throughput stops ramping up (e.g. TCP slow start has finished); in both cases Duration (if
+// non-zero) caps how long the warmup may take.
+type WarmupPolicy struct {
+	Duration   time.Duration // fixed warmup, or the longest a primed or adaptive warmup may take
+	PrimeBytes uint64        // if non-zero, begin measuring after this many bytes
+	Adaptive   bool          // begin measuring once throughput stabilizes (ignored when priming)
+}
+
+// wait blocks until measurement should begin, returning false if the context ends first
+func (p WarmupPolicy) wait(ctx context.Context, stats *protocol.Stats) bool {
+	switch {
+	case p.PrimeBytes > 0:
+		return p.waitPrimed(ctx, stats)
+	case p.Adaptive && p.Duration > 0:
+		return p.waitSteady(ctx, stats)
+	}
+
+	if p.Duration > 0 {
+		log.Info().Msgf("Warming up for %s", p.Duration)
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(p.Duration):
+		return true
+	}
+}
+
+func (p WarmupPolicy) waitPrimed(ctx context.Context, stats *protocol.Stats) bool {
+	log.Info().Msgf("Priming with %s before measuring", utils.DisplayBytes(p.PrimeBytes))
+
+	var capCh <-chan time.Time
+	if p.Duration > 0 {
+		capCh = time.After(p.Duration)
+	}
+
+	poll := time.NewTicker(primePollInterval)
+	defer poll.Stop()
+
+	for stats.GetBytesWarmup() < p.PrimeBytes {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-capCh:
+			log.Warn().Msgf("Priming did not complete within %s, measuring anyway", p.Duration)
+			return true
+		case <-poll.C:
+		}
+	}
+	return true
+}
+
+// waitSteady samples the warmup throughput and returns once it has changed by no more than the
+// tolerance for several samples in a row, or once the warmup cap is reached
+func (p WarmupPolicy) waitSteady(ctx context.Context, stats *protocol.Stats) bool {
+	log.Info().Msgf("Warming up until throughput stabilizes, for at most %s", p.Duration)
+
+	capCh := time.After(p.Duration)
+	tick := time.NewTicker(adaptiveSampleInterval)
+	defer tick.Stop()
+
+	// samples are evenly spaced, so bytes per sample stand in for the rate
+	last := stats.GetBytesWarmup()
+	var lastRate float64
+	steady := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-capCh:
+			log.Warn().Msgf("Throughput did not stabilize within %s, measuring anyway", p.Duration)
+			return true
+		case <-tick.C:
+		}
+
+		bytes := stats.GetBytesWarmup()
+		rate := float64(bytes - last)
+		last = bytes
+
+		if lastRate > 0 && rate > 0 && math.Abs(rate-lastRate)/lastRate <= adaptiveTolerance {
+			steady++
+		} else {
+			steady = 0
+		}
+		lastRate = rate
+
+		if steady >= adaptiveSteadySamples {
+			log.Debug().Str("rate", utils.DisplayBitsPerTime(uint64(rate), adaptiveSampleInterval)).Msg("Throughput stabilized")
+			return true
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Package proxyproto parses the PROXY protocol header (versions 1 and 2) that TCP load balancers
+// such as HAProxy and AWS NLB prepend to a connection to convey the original client address.
+//
+// A server expecting the header must require it on every connection: accepting connections
+// without one would let any client that reaches the server directly spoof its address.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoProxyHeader      = errors.New("connection did not start with a PROXY protocol header")
+	ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+)
+
+const (
+	v1Prefix    = "PROXY "
+	v1MaxLength = 107 // longest v1 line, including the trailing CRLF
+)
+
+// v2Signature opens every v2 header
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	v2HeaderSize = 16 // signature, version/command, family/protocol and address length
+
+	v2CmdLocal = 0x0 // health check from the proxy itself; no addresses are conveyed
+	v2CmdProxy = 0x1 // relayed connection
+
+	v2FamTCP4 = 0x11
+	v2FamTCP6 = 0x21
+)
+
+// Header holds the addresses conveyed by a PROXY protocol header
+type Header struct {
+	Version     int          // 1 or 2
+	Source      *net.TCPAddr // original client address, nil if the proxy did not convey one
+	Destination *net.TCPAddr // address the client connected to, nil if the proxy did not convey one
+}
+
+// Read consumes a PROXY protocol header of either version from the start of r, leaving the
+// connection's own data unread
+func Read(r *bufio.Reader) (*Header, error) {
+	prefix, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PROXY header: %w", err)
+	}
+
+	switch {
+	case string(prefix) == v1Prefix:
+		return readV1(r)
+	case bytes.Equal(prefix, v2Signature[:len(prefix)]):
+		return readV2(r)
+	default:
+		return nil, ErrNoProxyHeader
+	}
+}
+
+// readV1 parses the human-readable header, e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PROXY header: %w", err)
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			return parseV1(string(line[:len(line)-2]))
+		}
+	}
+	return nil, fmt.Errorf("%w: v1 header exceeds %d bytes", ErrInvalidProxyHeader, v1MaxLength)
+}
+
+func parseV1(line string) (*Header, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidProxyHeader, line)
+	}
+
+	header := &Header{Version: 1}
+	switch fields[1] {
+	case "UNKNOWN":
+		// the proxy could not determine the addresses; the rest of the line is ignored
+		return header, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("%w: unsupported protocol %q", ErrInvalidProxyHeader, fields[1])
+	}
+
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidProxyHeader, line)
+	}
+
+	src, err := parseV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+
+	header.Source = src
+	header.Destination = dst
+	return header, nil
+}
+
+func parseV1Addr(host, port string, v4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.To4() != nil) != v4 {
+		return nil, fmt.Errorf("%w: invalid address %q", ErrInvalidProxyHeader, host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrInvalidProxyHeader, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 parses the binary header
+func readV2(r *bufio.Reader) (*Header, error) {
+	buf := make([]byte, v2HeaderSize)
+	if err := readFull(r, buf); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(buf[:len(v2Signature)], v2Signature) {
+		return nil, ErrNoProxyHeader
+	}
+
+	verCmd, fam := buf[12], buf[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidProxyHeader, verCmd>>4)
+	}
+
+	// the addresses and any TLVs follow; TLVs are not used, but must be consumed
+	payload := make([]byte, binary.BigEndian.Uint16(buf[14:16]))
+	if err := readFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	header := &Header{Version: 2}
+	switch verCmd & 0x0f {
+	case v2CmdLocal:
+		return header, nil
+	case v2CmdProxy:
+	default:
+		return nil, fmt.Errorf("%w: unsupported command %d", ErrInvalidProxyHeader, verCmd&0x0f)
+	}
+
+	var ipLen int
+	switch fam {
+	case v2FamTCP4:
+		ipLen = net.IPv4len
+	case v2FamTCP6:
+		ipLen = net.IPv6len
+	default:
+		// other families (UDP, unix sockets) carry no usable TCP address
+		return header, nil
+	}
+
+	if len(payload) < 2*ipLen+4 {
+		return nil, fmt.Errorf("%w: address block too short", ErrInvalidProxyHeader)
+	}
+	header.Source = &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(payload[:ipLen])),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	header.Destination = &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(payload[ipLen : 2*ipLen])),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+	return header, nil
+}
+
+func readFull(r *bufio.Reader, buf []byte) error {
+	_, err := io.ReadFull(r, buf)
+	if err != nil {
+		return fmt.Errorf("failed to read PROXY header: %w", err)
+	}
+	return nil
+}
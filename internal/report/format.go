@@ -0,0 +1,48 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Format is an encoding for reports written to a stream
+type Format uint8
+
+const (
+	FormatJSON    Format = iota // one JSON object per line
+	FormatMsgpack               // concatenated MessagePack maps, for collectors ingesting many reports
+)
+
+// ParseFormat parses "json" or "msgpack" into a report format
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "json":
+		return FormatJSON, nil
+	case "msgpack":
+		return FormatMsgpack, nil
+	default:
+		return FormatJSON, fmt.Errorf("invalid report format %q (expected json or msgpack)", s)
+	}
+}
+
+// Encode writes a report to w in the given format
+func Encode(w io.Writer, r *Report, format Format) error {
+	var data []byte
+	switch format {
+	case FormatMsgpack:
+		data = r.MarshalMsgpack()
+	default:
+		line, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("failed to marshal report: %w", err)
+		}
+		data = append(line, '\n')
+	}
+
+	_, err := w.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
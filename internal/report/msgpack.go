@@ -0,0 +1,424 @@
+package report
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// The MessagePack encoding of a Report mirrors its JSON encoding: a map with the same keys, with
+// empty optional fields omitted. Durations and byte counts are integers, bitrates are floats, and
+// Start is a MessagePack timestamp (extension type -1), so collectors can decode reports with any
+// MessagePack library.
+
+var ErrInvalidMsgpack = errors.New("invalid msgpack data")
+
+// msgpackTimestamp is the extension type reserved for timestamps
+const msgpackTimestamp = -1
+
+// MarshalMsgpack encodes the report as a single MessagePack map. Encoded reports are self
+// delimiting, so a stream of them can be concatenated without separators.
+func (r *Report) MarshalMsgpack() []byte {
+	var m msgpackMap
+	m.key("session_id").str(r.SessionID)
+	if r.Label != "" {
+		m.key("label").str(r.Label)
+	}
+	m.key("server").str(r.Server)
+	m.key("direction").str(r.Direction)
+	m.key("chunk_size").uint(uint64(r.ChunkSize))
+	if r.ChunkMin != 0 {
+		m.key("chunk_min").uint(uint64(r.ChunkMin))
+	}
+	m.key("start").time(r.Start)
+	m.key("connect_ns").int(int64(r.Connect))
+	m.key("handshake_ns").int(int64(r.Handshake))
+	if r.RTT != 0 {
+		m.key("rtt_ns").int(int64(r.RTT))
+	}
+	if r.Warmup != 0 {
+		m.key("warmup_ns").int(int64(r.Warmup))
+	}
+	m.key("duration_ns").int(int64(r.Duration))
+	if r.Paused != 0 {
+		m.key("paused_ns").int(int64(r.Paused))
+	}
+	m.key("bytes_sent").uint(r.BytesSent)
+	m.key("bytes_rcvd").uint(r.BytesRcvd)
+	m.key("bytes_tail").uint(r.BytesTail)
+	if r.Writes != 0 {
+		m.key("writes").uint(r.Writes)
+	}
+	if r.Reads != 0 {
+		m.key("reads").uint(r.Reads)
+	}
+	m.key("overhead").uint(r.Overhead)
+	m.key("avg_sent_bps").float(r.AvgSentBps())
+	m.key("avg_rcvd_bps").float(r.AvgRcvdBps())
+	if r.Remote != nil {
+		var remote msgpackMap
+		remote.key("bytes_sent").uint(r.Remote.BytesSent)
+		remote.key("bytes_rcvd").uint(r.Remote.BytesRcvd)
+		if r.Remote.Duration != 0 {
+			remote.key("duration_ns").int(int64(r.Remote.Duration))
+		}
+		if r.Remote.Warmup != 0 {
+			remote.key("warmup_ns").int(int64(r.Remote.Warmup))
+		}
+		m.key("remote").mapOf(&remote)
+	}
+	if r.TCP != nil {
+		var tcp msgpackMap
+		tcp.key("segments_out").uint(r.TCP.SegmentsOut)
+		tcp.key("retransmits").uint(r.TCP.Retransmits)
+		m.key("tcp").mapOf(&tcp)
+	}
+	if r.Ping != nil {
+		var ping msgpackMap
+		ping.key("count").int(int64(r.Ping.Count))
+		ping.key("min_ns").int(int64(r.Ping.Min))
+		ping.key("avg_ns").int(int64(r.Ping.Avg))
+		ping.key("max_ns").int(int64(r.Ping.Max))
+		m.key("ping").mapOf(&ping)
+	}
+	if r.FlushFailed {
+		m.key("flush_failed").bool(true)
+	}
+	if len(r.Intervals) > 0 {
+		intervals := m.key("intervals")
+		intervals.arrayLen(len(r.Intervals))
+		for _, i := range r.Intervals {
+			var interval msgpackMap
+			interval.key("start_ns").int(int64(i.Start))
+			interval.key("duration_ns").int(int64(i.Duration))
+			interval.key("bytes_sent").uint(i.BytesSent)
+			interval.key("bytes_rcvd").uint(i.BytesRcvd)
+			interval.key("sent_bps").float(i.SentBps())
+			interval.key("rcvd_bps").float(i.RcvdBps())
+			intervals.mapOf(&interval)
+		}
+	}
+	if len(r.Streams) > 0 {
+		streams := m.key("streams")
+		streams.arrayLen(len(r.Streams))
+		for _, stream := range r.Streams {
+			streams.buf = append(streams.buf, stream.MarshalMsgpack()...)
+		}
+	}
+	if len(r.Dropped) > 0 {
+		dropped := m.key("dropped_streams")
+		dropped.arrayLen(len(r.Dropped))
+		for _, i := range r.Dropped {
+			dropped.int(int64(i))
+		}
+	}
+
+	var e msgpackEncoder
+	e.mapOf(&m)
+	return e.buf
+}
+
+// msgpackEncoder appends MessagePack values to a buffer, always in their most compact form
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) str(s string) {
+	switch n := len(s); {
+	case n < 32:
+		e.buf = append(e.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xda), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdb), uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *msgpackEncoder) uint(v uint64) {
+	switch {
+	case v < 128:
+		e.buf = append(e.buf, byte(v))
+	case v <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xce), uint32(v))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcf), v)
+	}
+}
+
+func (e *msgpackEncoder) int(v int64) {
+	switch {
+	case v >= 0:
+		e.uint(uint64(v))
+	case v >= -32:
+		e.buf = append(e.buf, byte(v))
+	case v >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xd2), uint32(v))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xd3), uint64(v))
+	}
+}
+
+func (e *msgpackEncoder) bool(v bool) {
+	if v {
+		e.buf = append(e.buf, 0xc3)
+	} else {
+		e.buf = append(e.buf, 0xc2)
+	}
+}
+
+func (e *msgpackEncoder) float(v float64) {
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcb), math.Float64bits(v))
+}
+
+// time encodes a timestamp in the 96-bit form, which covers any time.Time
+func (e *msgpackEncoder) time(t time.Time) {
+	e.buf = append(e.buf, 0xc7, 12, 0xff) // ext 8 of 12 bytes, type -1
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(t.Nanosecond()))
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(t.Unix()))
+}
+
+// arrayLen starts an array of n elements, which the caller appends
+func (e *msgpackEncoder) arrayLen(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xdc), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdd), uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) mapOf(m *msgpackMap) {
+	switch n := m.n; {
+	case n < 16:
+		e.buf = append(e.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xde), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdf), uint32(n))
+	}
+	e.buf = append(e.buf, m.body.buf...)
+}
+
+// msgpackMap builds a map whose size is only known once its optional entries are added
+type msgpackMap struct {
+	n    int
+	body msgpackEncoder
+}
+
+// key adds an entry's key, returning the encoder for its value
+func (m *msgpackMap) key(k string) *msgpackEncoder {
+	m.n++
+	m.body.str(k)
+	return &m.body
+}
+
+// DecodeMsgpack decodes the first MessagePack value in data, returning it and the remaining bytes.
+// Maps decode to map[string]any, arrays to []any and timestamps to time.Time, so the value can be
+// printed as JSON for inspection. Only the types a Report can contain (and arrays and floats) are
+// supported.
+func DecodeMsgpack(data []byte) (any, []byte, error) {
+	d := msgpackDecoder{buf: data}
+	v, err := d.value()
+	if err != nil {
+		return nil, data, err
+	}
+	return v, d.buf, nil
+}
+
+type msgpackDecoder struct {
+	buf []byte
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if len(d.buf) < n {
+		return nil, fmt.Errorf("%w: truncated value", ErrInvalidMsgpack)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+// length reads a big-endian length or integer of size bytes
+func (d *msgpackDecoder) length(size int) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+func (d *msgpackDecoder) value() (any, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c := b[0]; {
+	case c <= 0x7f:
+		return uint64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(uint64(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.arrayOf(uint64(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.strOf(uint64(c & 0x1f))
+	}
+
+	switch c := b[0]; c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		v, err := d.length(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := d.length(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return d.length(1 << (c - 0xcc))
+	case 0xd0:
+		v, err := d.length(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := d.length(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := d.length(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := d.length(8)
+		return int64(v), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.strOf(n)
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(n)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(n)
+	case 0xd6:
+		return d.ext(4)
+	case 0xd7:
+		return d.ext(8)
+	case 0xc7:
+		n, err := d.length(1)
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(int(n))
+	default:
+		return nil, fmt.Errorf("%w: unsupported type 0x%02x", ErrInvalidMsgpack, c)
+	}
+}
+
+func (d *msgpackDecoder) strOf(n uint64) (string, error) {
+	if n > uint64(len(d.buf)) {
+		return "", fmt.Errorf("%w: truncated string", ErrInvalidMsgpack)
+	}
+	b, err := d.next(int(n))
+	return string(b), err
+}
+
+func (d *msgpackDecoder) arrayOf(n uint64) ([]any, error) {
+	// every element takes at least a byte, which bounds the allocation for corrupt lengths
+	if n > uint64(len(d.buf)) {
+		return nil, fmt.Errorf("%w: truncated array", ErrInvalidMsgpack)
+	}
+	arr := make([]any, 0, n)
+	for range n {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+	}
+	return arr, nil
+}
+
+func (d *msgpackDecoder) mapOf(n uint64) (map[string]any, error) {
+	if n > uint64(len(d.buf)) {
+		return nil, fmt.Errorf("%w: truncated map", ErrInvalidMsgpack)
+	}
+	m := make(map[string]any, n)
+	for range n {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: non-string map key", ErrInvalidMsgpack)
+		}
+		m[key], err = d.value()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ext decodes an extension value of n bytes, of which only timestamps are supported
+func (d *msgpackDecoder) ext(n int) (any, error) {
+	typ, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(typ[0]) != msgpackTimestamp {
+		return nil, fmt.Errorf("%w: unsupported extension type %d", ErrInvalidMsgpack, int8(typ[0]))
+	}
+
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), 0).UTC(), nil
+	case 8:
+		v := binary.BigEndian.Uint64(b)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)).UTC(), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(b[4:])), int64(binary.BigEndian.Uint32(b))).UTC(), nil
+	default:
+		return nil, fmt.Errorf("%w: invalid timestamp length %d", ErrInvalidMsgpack, n)
+	}
+}
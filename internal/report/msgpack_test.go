@@ -0,0 +1,133 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fullReport returns a report with every field set, including a per-stream report, with values
+// spanning the integer encodings
+func fullReport() *Report {
+	stream := &Report{
+		SessionID: "01JABCDEFGHJKMNPQRSTVWXYZ0",
+		Server:    "10.0.0.1:1234",
+		Direction: "upload",
+		ChunkSize: 128 << 10,
+		Start:     time.Date(2026, 1, 2, 3, 4, 5, 6789, time.UTC),
+		Duration:  10 * time.Second,
+		BytesSent: 1 << 20,
+	}
+	return &Report{
+		SessionID: "01JABCDEFGHJKMNPQRSTVWXYZ1",
+		Label:     "full",
+		Server:    "[::1]:1234",
+		Direction: "bidi",
+		ChunkSize: 128 << 10,
+		ChunkMin:  100,
+		Start:     time.Date(2026, 1, 2, 3, 4, 5, 123456789, time.UTC),
+		Connect:   250 * time.Microsecond,
+		Handshake: 2 * time.Millisecond,
+		RTT:       200,
+		Warmup:    time.Second,
+		Duration:  10 * time.Second,
+		Paused:    1500 * time.Millisecond,
+		BytesSent: 5_000_000_000,
+		BytesRcvd: 70_000,
+		BytesTail: 300,
+		Writes:    38_147,
+		Reads:     12,
+		Overhead:  42,
+		Remote:    &RemoteResult{BytesSent: 70_000, BytesRcvd: 5_000_000_000, Duration: 10 * time.Second, Warmup: time.Second},
+		TCP:       &TCPStats{SegmentsOut: 3_500_000, Retransmits: 17},
+		Ping:      &PingStats{Count: 5, Min: time.Millisecond, Avg: 2 * time.Millisecond, Max: 4 * time.Millisecond},
+
+		FlushFailed: true,
+
+		Intervals: []Interval{
+			{Start: 0, Duration: time.Second, BytesSent: 500_000_000, BytesRcvd: 7_000},
+			{Start: time.Second, Duration: 333 * time.Millisecond, BytesSent: 1, BytesRcvd: 0},
+		},
+		Streams: []*Report{stream, stream},
+		Dropped: []int{1},
+	}
+}
+
+// generic decodes JSON into maps, slices and json.Numbers so encodings can be compared as values
+func generic(t *testing.T, data []byte) any {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	return v
+}
+
+func TestMsgpackMatchesJSON(t *testing.T) {
+	r := fullReport()
+
+	// a field left zero would be omitted from both encodings, hiding a missing msgpack key
+	v := reflect.ValueOf(*r)
+	for i := range v.NumField() {
+		if v.Field(i).IsZero() {
+			t.Fatalf("fullReport leaves %s unset", v.Type().Field(i).Name)
+		}
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	want := generic(t, data)
+
+	decoded, rest, err := DecodeMsgpack(r.MarshalMsgpack())
+	if err != nil {
+		t.Fatalf("DecodeMsgpack: %v", err)
+	}
+	if len(rest) != 0 {
+		t.Fatalf("%d bytes left after the report", len(rest))
+	}
+	// timestamps decode to time.Time, which re-encodes as the same RFC 3339 string
+	data, err = json.Marshal(decoded)
+	if err != nil {
+		t.Fatalf("json.Marshal of decoded msgpack: %v", err)
+	}
+	got := generic(t, data)
+
+	wantMap, gotMap := want.(map[string]any), got.(map[string]any)
+	for key, w := range wantMap {
+		if g, ok := gotMap[key]; !ok {
+			t.Errorf("msgpack lacks %q", key)
+		} else if !reflect.DeepEqual(g, w) {
+			t.Errorf("%q: msgpack %v, JSON %v", key, g, w)
+		}
+	}
+	for key := range gotMap {
+		if _, ok := wantMap[key]; !ok {
+			t.Errorf("msgpack has %q, which JSON lacks", key)
+		}
+	}
+}
+
+func TestMsgpackConcatenated(t *testing.T) {
+	a, b := fullReport(), fullReport().Streams[0]
+	data := append(a.MarshalMsgpack(), b.MarshalMsgpack()...)
+
+	for _, r := range []*Report{a, b} {
+		v, rest, err := DecodeMsgpack(data)
+		if err != nil {
+			t.Fatalf("DecodeMsgpack: %v", err)
+		}
+		if got := v.(map[string]any)["session_id"]; got != r.SessionID {
+			t.Errorf("decoded session %v, want %s", got, r.SessionID)
+		}
+		data = rest
+	}
+	if len(data) != 0 {
+		t.Errorf("%d bytes left after both reports", len(data))
+	}
+}
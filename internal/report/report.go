@@ -0,0 +1,160 @@
+package report
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Report summarizes the outcome of a single completed test. Byte counts are goodput, payload only;
+// Overhead holds the protocol bytes carried with it during the measured window.
+type Report struct {
+	SessionID string        `json:"session_id"`
+	Label     string        `json:"label,omitempty"` // user-supplied annotation of the run
+	Server    string        `json:"server"`
+	Direction string        `json:"direction"`
+	ChunkSize uint32        `json:"chunk_size"`
+	ChunkMin  uint32        `json:"chunk_min,omitempty"` // smallest write when write sizes were randomized up to ChunkSize
+	Start     time.Time     `json:"start"`
+	Connect   time.Duration `json:"connect_ns"`          // time to establish the TCP connection
+	Handshake time.Duration `json:"handshake_ns"`        // time from sending the Hello to receiving the Ack
+	RTT       time.Duration `json:"rtt_ns,omitempty"`    // round trip from sending the Hello to the server's first response
+	Warmup    time.Duration `json:"warmup_ns,omitempty"` // time from the start of the data phase until measurement began
+	Duration  time.Duration `json:"duration_ns"`         // measured duration, excluding warmup and pauses
+	Paused    time.Duration `json:"paused_ns,omitempty"` // time the test was paused during measurement
+	BytesSent uint64        `json:"bytes_sent"`
+	BytesRcvd uint64        `json:"bytes_rcvd"`
+	BytesTail uint64        `json:"bytes_tail"`       // received after the measured window closed, excluded from BytesRcvd
+	Writes    uint64        `json:"writes,omitempty"` // write operations during the measured window
+	Reads     uint64        `json:"reads,omitempty"`  // read operations during the measured window
+	Overhead  uint64        `json:"overhead"`         // in-band protocol bytes moved alongside the payload, in both directions
+	Remote    *RemoteResult `json:"remote,omitempty"` // server's view, if it sent a result
+	TCP       *TCPStats     `json:"tcp,omitempty"`    // client's kernel counters, where the platform exposes them
+	Ping      *PingStats    `json:"ping,omitempty"`   // round trips timed before the data phase, if requested
+
+	FlushFailed bool `json:"flush_failed,omitempty"` // the final flush failed, so BytesSent includes bytes never sent
+
+	Intervals []Interval `json:"intervals,omitempty"`       // throughput over each reporting interval of the measurement
+	Streams   []*Report  `json:"streams,omitempty"`         // each connection's own report, for a test over parallel streams
+	Dropped   []int      `json:"dropped_streams,omitempty"` // streams dropped for stalling, kept in Streams but left out of the totals
+}
+
+// Interval is the throughput over one reporting interval of a test. The last one is usually partial.
+type Interval struct {
+	Start     time.Duration `json:"start_ns"` // offset from the start of measurement
+	Duration  time.Duration `json:"duration_ns"`
+	BytesSent uint64        `json:"bytes_sent"`
+	BytesRcvd uint64        `json:"bytes_rcvd"`
+}
+
+// SentBps returns the send rate in bits per second over the interval
+func (i Interval) SentBps() float64 {
+	return bitsPerSecond(i.BytesSent, i.Duration)
+}
+
+// RcvdBps returns the receive rate in bits per second over the interval
+func (i Interval) RcvdBps() float64 {
+	return bitsPerSecond(i.BytesRcvd, i.Duration)
+}
+
+// MarshalJSON encodes the interval with its bitrates, which are derived rather than stored
+func (i Interval) MarshalJSON() ([]byte, error) {
+	type plain Interval
+	return json.Marshal(struct {
+		plain
+		SentBps float64 `json:"sent_bps"`
+		RcvdBps float64 `json:"rcvd_bps"`
+	}{plain(i), i.SentBps(), i.RcvdBps()})
+}
+
+// MarshalJSON encodes the report with its average bitrates, which are derived rather than stored
+func (r *Report) MarshalJSON() ([]byte, error) {
+	type plain Report
+	return json.Marshal(struct {
+		*plain
+		AvgSentBps float64 `json:"avg_sent_bps"`
+		AvgRcvdBps float64 `json:"avg_rcvd_bps"`
+	}{(*plain)(r), r.AvgSentBps(), r.AvgRcvdBps()})
+}
+
+// TCPStats holds the client's kernel TCP counters accumulated during the data phase. Retransmissions
+// are a proxy for loss on the path the client sends over, so they are only meaningful when it sends.
+type TCPStats struct {
+	SegmentsOut uint64 `json:"segments_out"`
+	Retransmits uint64 `json:"retransmits"`
+}
+
+// RetransmitRate returns the fraction of sent segments that were retransmissions
+func (t *TCPStats) RetransmitRate() float64 {
+	if t == nil || t.SegmentsOut == 0 {
+		return 0
+	}
+	return float64(t.Retransmits) / float64(t.SegmentsOut)
+}
+
+// PingStats summarizes the round trips of the pings sent on the control connection before the data
+// phase, a latency baseline for the path taken by the test
+type PingStats struct {
+	Count int           `json:"count"`
+	Min   time.Duration `json:"min_ns"`
+	Avg   time.Duration `json:"avg_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+// NewPingStats summarizes the round trips of a ping phase, or returns nil if there were none
+func NewPingStats(rtts []time.Duration) *PingStats {
+	if len(rtts) == 0 {
+		return nil
+	}
+
+	stats := &PingStats{Count: len(rtts), Min: rtts[0], Max: rtts[0]}
+	var total time.Duration
+	for _, rtt := range rtts {
+		stats.Min = min(stats.Min, rtt)
+		stats.Max = max(stats.Max, rtt)
+		total += rtt
+	}
+	stats.Avg = total / time.Duration(len(rtts))
+	return stats
+}
+
+// RemoteResult holds the byte counts reported by the server at the end of a test
+type RemoteResult struct {
+	BytesSent uint64        `json:"bytes_sent"`
+	BytesRcvd uint64        `json:"bytes_rcvd"`
+	Duration  time.Duration `json:"duration_ns,omitempty"` // server's measured duration, if it reported one
+	Warmup    time.Duration `json:"warmup_ns,omitempty"`   // server's warmup before measuring, if it reported one
+}
+
+// WritesPerSec returns the rate of write operations over the measured duration
+func (r *Report) WritesPerSec() float64 {
+	return opsPerSecond(r.Writes, r.Duration)
+}
+
+// ReadsPerSec returns the rate of read operations over the measured duration
+func (r *Report) ReadsPerSec() float64 {
+	return opsPerSecond(r.Reads, r.Duration)
+}
+
+func opsPerSecond(ops uint64, duration time.Duration) float64 {
+	if duration <= 0 {
+		return 0
+	}
+	return float64(ops) / duration.Seconds()
+}
+
+// AvgSentBps returns the average send rate in bits per second over the measured duration
+func (r *Report) AvgSentBps() float64 {
+	return bitsPerSecond(r.BytesSent, r.Duration)
+}
+
+// AvgRcvdBps returns the average receive rate in bits per second over the measured duration
+func (r *Report) AvgRcvdBps() float64 {
+	return bitsPerSecond(r.BytesRcvd, r.Duration)
+}
+
+func bitsPerSecond(bytes uint64, duration time.Duration) float64 {
+	if duration <= 0 {
+		return 0
+	}
+	return float64(bytes) * 8 / duration.Seconds()
+}
@@ -0,0 +1,62 @@
+package report
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var (
+	ErrNoRemoteResult = errors.New("server did not report its byte counts")
+	ErrBytesMismatch  = errors.New("byte counts differ between client and server")
+)
+
+// VerifyBytes checks that, in each direction data flowed, the bytes one end sent and the other
+// received differ by at most tolerance, a fraction of the bytes sent. TCP is lossless, so a larger
+// difference points at truncation or a counting bug, although the two ends measure over windows
+// offset by the link latency, so a time-bound test needs some tolerance to pass.
+func (r *Report) VerifyBytes(tolerance float64) error {
+	if r.Remote == nil {
+		return ErrNoRemoteResult
+	}
+
+	var mismatches []string
+	check := func(direction, sender, receiver string, sent, rcvd uint64) {
+		if sent == 0 && rcvd == 0 {
+			return
+		}
+		// nothing sent but something received is a mismatch of any size
+		off := 1.0
+		if sent > 0 {
+			off = math.Abs(float64(sent)-float64(rcvd)) / float64(sent)
+		}
+		if off <= tolerance {
+			return
+		}
+		mismatches = append(mismatches, fmt.Sprintf("%s: %s sent %d bytes, %s received %d (%+d, %.3f%% off, tolerance %.3f%%)",
+			direction, sender, sent, receiver, rcvd, int64(rcvd-sent), off*100, tolerance*100))
+	}
+	check("upload", "client", "server", r.BytesSent, r.Remote.BytesRcvd)
+	check("download", "server", "client", r.Remote.BytesSent, r.BytesRcvd)
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%w: %s", ErrBytesMismatch, strings.Join(mismatches, "; "))
+	}
+	return nil
+}
+
+// DataLoss returns the fraction of the bytes measured as sent that the receiving end did not
+// measure, from the server's result, and false if there is none. Over TCP it is only the offset
+// between the two ends' windows; over UDP it is mostly datagrams lost on the way.
+func (r *Report) DataLoss() (float64, bool) {
+	if r.Remote == nil {
+		return 0, false
+	}
+	sent := r.BytesSent + r.Remote.BytesSent
+	rcvd := r.BytesRcvd + r.Remote.BytesRcvd
+	if sent == 0 || rcvd >= sent {
+		return 0, true
+	}
+	return float64(sent-rcvd) / float64(sent), true
+}
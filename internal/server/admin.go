@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// AdminHandler serves the operator API over HTTP:
+//
+//	GET    /sessions       list the tests in progress as JSON
+//	DELETE /sessions/{id}  cancel the test with this session ID
+//
+// The API is unauthenticated, so only expose it on a trusted interface such as localhost.
+func (s *ServerTCP) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", s.handleListSessions)
+	mux.HandleFunc("DELETE /sessions/{id}", s.handleCancelSession)
+	return mux
+}
+
+func (s *ServerTCP) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(s.ActiveSessions())
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to write session list")
+	}
+}
+
+func (s *ServerTCP) handleCancelSession(w http.ResponseWriter, r *http.Request) {
+	id, err := ulid.ParseStrict(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	err = s.CancelSession(id)
+	if errors.Is(err, ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/report"
+	"github.com/rs/zerolog/log"
+)
+
+// coordinatorQueueSize bounds the updates waiting to be pushed; further updates are dropped
+const coordinatorQueueSize = 256
+
+// IntervalUpdate is the JSON body POSTed to the coordinator for each interval of each test
+type IntervalUpdate struct {
+	Server    string        `json:"server"`      // address the server listens on
+	SessionID string        `json:"session_id"`  // test the interval belongs to
+	Time      time.Time     `json:"time"`        // when the interval ended
+	Interval  time.Duration `json:"interval_ns"` // length of the interval
+	BytesSent uint64        `json:"bytes_sent"`  // bytes the server sent during the interval
+	BytesRcvd uint64        `json:"bytes_rcvd"`  // bytes the server received during the interval
+}
+
+// coordinator pushes interval updates to a central endpoint. Pushing is best-effort: updates are
+// queued without blocking the test and dropped if the queue is full or the request fails.
+type coordinator struct {
+	url    string
+	server string
+	client *http.Client
+	queue  chan IntervalUpdate
+}
+
+func newCoordinator(url, server string, timeout time.Duration) *coordinator {
+	return &coordinator{
+		url:    url,
+		server: server,
+		client: &http.Client{Timeout: timeout},
+		queue:  make(chan IntervalUpdate, coordinatorQueueSize),
+	}
+}
+
+// run delivers queued updates until the context is done
+func (c *coordinator) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-c.queue:
+			err := c.post(ctx, update)
+			if err != nil {
+				log.Debug().Err(err).Str("session_id", update.SessionID).Msg("Failed to push interval to coordinator")
+			}
+		}
+	}
+}
+
+func (c *coordinator) post(ctx context.Context, update IntervalUpdate) error {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("failed to marshal interval update: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create coordinator request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to coordinator: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("coordinator responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// push queues an update, dropping it if the coordinator has fallen behind
+func (c *coordinator) push(update IntervalUpdate) {
+	select {
+	case c.queue <- update:
+	default:
+		log.Debug().Str("session_id", update.SessionID).Msg("Coordinator queue full, dropping interval")
+	}
+}
+
+// coordinatorSink feeds one test's intervals to the coordinator
+type coordinatorSink struct {
+	coord     *coordinator
+	sessionID string
+}
+
+func (s coordinatorSink) Interval(diff protocol.StatsDiff) {
+	s.coord.push(IntervalUpdate{
+		Server:    s.coord.server,
+		SessionID: s.sessionID,
+		Time:      time.Now(),
+		Interval:  diff.Duration,
+		BytesSent: diff.BytesSent,
+		BytesRcvd: diff.BytesRcvd,
+	})
+}
+
+func (s coordinatorSink) Final(rpt *report.Report) {}
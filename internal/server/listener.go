@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = 1 * time.Second
+)
+
+// ListenerError is returned by Run when the listener fails for good while the server is not shutting
+// down, e.g. because it was closed from elsewhere. The server can no longer accept tests, so a
+// supervising program would typically restart it. Run returns nil on a clean shutdown.
+type ListenerError struct {
+	Addr string // address the listener was bound to
+	Err  error  // the accept error that ended the server
+}
+
+func (e *ListenerError) Error() string {
+	return fmt.Sprintf("listener on %s failed: %v", e.Addr, e.Err)
+}
+
+func (e *ListenerError) Unwrap() error {
+	return e.Err
+}
+
+// isFatalAcceptError reports whether an accept error means the listener is unusable. Other errors,
+// such as running out of file descriptors or a connection aborted before it was accepted, pass.
+func isFatalAcceptError(err error) bool {
+	return errors.Is(err, net.ErrClosed)
+}
+
+// acceptBackoff doubles the wait between failing accepts, so a persistent error such as file
+// descriptor exhaustion doesn't spin the accept loop
+type acceptBackoff struct {
+	delay time.Duration
+}
+
+func (b *acceptBackoff) next() time.Duration {
+	b.delay = min(max(b.delay*2, minAcceptBackoff), maxAcceptBackoff)
+	return b.delay
+}
+
+func (b *acceptBackoff) reset() {
+	b.delay = 0
+}
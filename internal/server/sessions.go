@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/oklog/ulid/v2"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrSessionNotFound = errors.New("no active session with this ID")
+	ErrSessionRunning  = errors.New("a session with this ID is already running")
+	errNoSlot          = errors.New("no free test slot")
+)
+
+// SessionInfo is a snapshot of a test in progress. Byte counts cover the measured window so far,
+// from the server's side, and are zero during warmup. A test over parallel streams is one session,
+// its byte counts summed over its connections.
+type SessionInfo struct {
+	SessionID  string    `json:"session_id"`
+	RemoteAddr string    `json:"remote_addr"` // client address, as conveyed by a PROXY header if enabled
+	Direction  string    `json:"direction"`
+	Start      time.Time `json:"start"` // when the server accepted the test
+	BytesSent  uint64    `json:"bytes_sent"`
+	BytesRcvd  uint64    `json:"bytes_rcvd"`
+	Streams    int       `json:"streams,omitempty"` // parallel connections, if more than one
+}
+
+// session is a test in progress, registered for its duration so operators can find and cancel it.
+// Each of a test's connections joins it as a stream, and it holds one test slot for all of them.
+type session struct {
+	remote    net.Addr
+	direction protocol.FloDir
+	start     time.Time
+	streams   []*sessionStream // by stream index, nil until that connection joins
+	active    int              // streams joined and not yet left
+}
+
+// sessionStream is one connection of a test
+type sessionStream struct {
+	cancel   context.CancelFunc // ends the connection's handler context
+	stats    *protocol.Stats    // live counters shared with the transfer
+	duration time.Duration      // measured duration, set by the handler before it leaves
+}
+
+func newSession(remote net.Addr, direction protocol.FloDir, streams uint8) *session {
+	return &session{
+		remote:    remote,
+		direction: direction,
+		start:     time.Now(),
+		streams:   make([]*sessionStream, streams),
+	}
+}
+
+func (sess *session) info(id ulid.ULID) SessionInfo {
+	info := SessionInfo{
+		SessionID:  id.String(),
+		RemoteAddr: sess.remote.String(),
+		Direction:  protocol.DirectionToString(sess.direction),
+		Start:      sess.start,
+	}
+	if len(sess.streams) > 1 {
+		info.Streams = len(sess.streams)
+	}
+	for _, stream := range sess.streams {
+		if stream != nil {
+			info.BytesSent += stream.stats.GetBytesSent()
+			info.BytesRcvd += stream.stats.GetBytesRcvd()
+		}
+	}
+	return info
+}
+
+// sessionRegistry tracks the tests in progress by session ID. It is safe for concurrent use.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	sessions map[ulid.ULID]*session
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[ulid.ULID]*session)}
+}
+
+// join adds a connection to its test's session as stream index. The test's first connection
+// registers sess, taking a test slot with acquire or failing with errNoSlot; later ones join the
+// session already registered. A stream that is already running (a replayed hello) or that doesn't
+// match the session it claims to belong to is refused.
+func (r *sessionRegistry) join(id ulid.ULID, index uint8, sess *session, stream *sessionStream, acquire func() bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if running, ok := r.sessions[id]; ok {
+		if len(running.streams) != len(sess.streams) || running.direction != sess.direction || running.streams[index] != nil {
+			return ErrSessionRunning
+		}
+		sess = running
+	} else {
+		if !acquire() {
+			return errNoSlot
+		}
+		r.sessions[id] = sess
+	}
+	sess.streams[index] = stream
+	sess.active++
+	return nil
+}
+
+// leave removes a connection from its session, returning the session if it was the last one
+// running, in which case the session is deregistered and its slot is the caller's to release
+func (r *sessionRegistry) leave(id ulid.ULID) *session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sess, ok := r.sessions[id]
+	if !ok {
+		return nil
+	}
+	sess.active--
+	if sess.active > 0 {
+		return nil
+	}
+	delete(r.sessions, id)
+	return sess
+}
+
+// cancel ends every stream of a session, reporting whether it was found
+func (r *sessionRegistry) cancel(id ulid.ULID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sess, ok := r.sessions[id]
+	if !ok {
+		return false
+	}
+	for _, stream := range sess.streams {
+		if stream != nil {
+			stream.cancel()
+		}
+	}
+	return true
+}
+
+// snapshot returns the sessions in progress, oldest first
+func (r *sessionRegistry) snapshot() []SessionInfo {
+	r.mu.Lock()
+	infos := make([]SessionInfo, 0, len(r.sessions))
+	for id, sess := range r.sessions {
+		infos = append(infos, sess.info(id))
+	}
+	r.mu.Unlock()
+
+	slices.SortFunc(infos, func(a, b SessionInfo) int {
+		return a.Start.Compare(b.Start)
+	})
+	return infos
+}
+
+// ActiveSessions returns a snapshot of the tests in progress, oldest first
+func (s *ServerTCP) ActiveSessions() []SessionInfo {
+	return s.sessions.snapshot()
+}
+
+// CancelSession ends a test in progress as if the server were shutting down: its transfer stops
+// and its summary is still logged. It returns ErrSessionNotFound if no such test is running.
+func (s *ServerTCP) CancelSession(id ulid.ULID) error {
+	if !s.sessions.cancel(id) {
+		return ErrSessionNotFound
+	}
+	log.Warn().Str("session_id", id.String()).Msg("Cancelling test at operator request")
+	return nil
+}
+
+// leaveSession removes a connection from its test's session. The last one to leave releases the
+// test's slot and, for a test over parallel streams, logs the summary of all of them.
+func (s *ServerTCP) leaveSession(id ulid.ULID) {
+	sess := s.sessions.leave(id)
+	if sess == nil {
+		return
+	}
+	s.slotRelease()
+	if len(sess.streams) > 1 {
+		s.logStreamsSummary(id, sess)
+	}
+}
+
+// logStreamsSummary logs the totals of a test over parallel streams alongside each stream's. The
+// streams measure over nearly the same window, so the aggregate bitrate is their combined bytes
+// over the longest of their measured durations.
+func (s *ServerTCP) logStreamsSummary(id ulid.ULID, sess *session) {
+	var joined int
+	var duration time.Duration
+	var bytesSent, bytesRcvd uint64
+	var totalSent, avgSent, totalRcvd, avgRcvd []string
+	for _, stream := range sess.streams {
+		if stream == nil {
+			continue
+		}
+		joined++
+		duration = max(duration, stream.duration)
+		sent, rcvd := stream.stats.GetBytesSent(), stream.stats.GetBytesRcvd()
+		bytesSent += sent
+		bytesRcvd += rcvd
+		totalSent = append(totalSent, utils.DisplayBytes(sent))
+		avgSent = append(avgSent, utils.DisplayBitsPerTime(sent, stream.duration))
+		totalRcvd = append(totalRcvd, utils.DisplayBytes(rcvd))
+		avgRcvd = append(avgRcvd, utils.DisplayBitsPerTime(rcvd, stream.duration))
+	}
+
+	evt := log.WithLevel(s.summaryLevel).Str("session_id", id.String()).
+		Str("remote_addr", sess.remote.String()).
+		Str("direction", protocol.DirectionToString(sess.direction)).
+		Int("streams", joined).
+		Str("duration_measured", utils.DisplayTime(duration))
+	if joined < len(sess.streams) {
+		evt = evt.Int("streams_requested", len(sess.streams))
+	}
+	if bytesSent > 0 {
+		evt = evt.Str("total_sent", utils.DisplayBytes(bytesSent)).
+			Str("avg_sent", utils.DisplayBitsPerTime(bytesSent, duration)).
+			Strs("stream_total_sent", totalSent).
+			Strs("stream_avg_sent", avgSent)
+	}
+	if bytesRcvd > 0 {
+		evt = evt.Str("total_rcvd", utils.DisplayBytes(bytesRcvd)).
+			Str("avg_rcvd", utils.DisplayBitsPerTime(bytesRcvd, duration)).
+			Strs("stream_total_rcvd", totalRcvd).
+			Strs("stream_avg_rcvd", avgRcvd)
+	}
+	evt.Msg("Parallel streams complete")
+}
@@ -3,59 +3,158 @@ package server
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/goodieshq/goflo/internal/capture"
 	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/handshake"
 	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
 	"github.com/goodieshq/goflo/internal/protocol/transfer"
+	"github.com/goodieshq/goflo/internal/proxyproto"
 	"github.com/goodieshq/goflo/internal/utils"
 	"github.com/oklog/ulid/v2"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 type ServerTCP struct {
-	host        string
-	port        uint16
-	psk         []byte
-	authEnabled bool
-	timeout     time.Duration
-	slots       chan struct{}
+	host         string
+	port         uint16
+	psk          []byte
+	authEnabled  bool
+	timeout      time.Duration
+	authTimeout  time.Duration
+	stallTimeout time.Duration
+	startGrace   time.Duration
+	maxHelloAge  time.Duration
+	clockSkew    time.Duration
+	maxPause     time.Duration
+	proxyProto   bool
+	cpus         []int
+	writeMode    transfer.WriteMode
+	summaryLevel zerolog.Level
+	coord        *coordinator
+	slots        chan struct{}
+	sessions     *sessionRegistry
+	limiter      *transfer.Limiter
+	capture      *capture.Writer
+	tlsConfig    *tls.Config
+	shutGrace    time.Duration
+	handlers     sync.WaitGroup
 }
 
+// Unlimited disables the concurrent test cap when used as MaxConcurrentTests. Every incoming test
+// runs, each holding a connection, goroutines and buffers for its duration, so only use it to
+// load-test the server itself on a trusted network.
+const Unlimited uint32 = ^uint32(0)
+
 type ServerOpts struct {
 	Host               string
 	Port               uint16
 	PSK                []byte
 	Timeout            time.Duration
-	MaxConcurrentTests uint32
+	AuthTimeout        time.Duration      // wait for the client's answer to the auth challenge (Timeout if 0)
+	MaxConcurrentTests uint32             // tests allowed to run at once (Unlimited disables the cap)
+	StallTimeout       time.Duration      // abort a test with no data progress for this long (0 disables)
+	StartGrace         time.Duration      // abort a test whose data phase moves no bytes for this long (DEFAULT_START_GRACE if 0)
+	MaxHelloAge        time.Duration      // reject hellos whose session ID timestamp is older than this (0 disables)
+	ClockSkew          time.Duration      // tolerated clock difference when checking the hello age
+	WriteMode          transfer.WriteMode // how chunks are written during the data phase
+	SummaryLevel       *zerolog.Level     // level of the per-test completion summary (Info if nil)
+	CoordinatorURL     string             // if set, POST each test's interval stats here as JSON (best-effort)
+	MaxPause           time.Duration      // total time a client may keep a test paused (DEFAULT_MAX_PAUSE if 0)
+	ProxyProtocol      bool               // require a PROXY protocol v1/v2 header on every connection (behind a load balancer)
+	CPUs               []int              // pin each test's transfer loops to these CPUs (Linux only, unpinned if empty)
+	MaxBitrate         uint64             // cap the combined send rate of all tests in bits per second (0 is unlimited)
+	Capture            *capture.Writer    // record the packets of every handshake to this capture (optional)
+	TLSConfig          *tls.Config        // require TLS on every connection, with this configuration (plaintext if nil)
+	ShutdownGrace      time.Duration      // on shutdown, let running tests finish their current interval, for at most this long
 }
 
+// DEFAULT_MAX_PAUSE bounds how long a paused test holds its slot when ServerOpts.MaxPause is unset
+const DEFAULT_MAX_PAUSE = 5 * time.Minute
+
+// DEFAULT_START_GRACE bounds how long a test holds its slot without starting its data phase when
+// ServerOpts.StartGrace is unset
+const DEFAULT_START_GRACE = 10 * time.Second
+
 func NewServerTCP(opts ServerOpts) *ServerTCP {
 	if opts.Timeout == 0 {
 		opts.Timeout = 3 * time.Second
 	}
+	if opts.AuthTimeout <= 0 {
+		opts.AuthTimeout = opts.Timeout
+	}
+	if opts.MaxPause <= 0 {
+		opts.MaxPause = DEFAULT_MAX_PAUSE
+	}
+	if opts.StartGrace <= 0 {
+		opts.StartGrace = DEFAULT_START_GRACE
+	}
 	if opts.MaxConcurrentTests <= 0 {
 		opts.MaxConcurrentTests = 1
 	}
 
-	slots := make(chan struct{}, opts.MaxConcurrentTests)
-	for i := uint32(0); i < opts.MaxConcurrentTests; i++ {
-		slots <- struct{}{}
+	summaryLevel := utils.DefaultIfNil(opts.SummaryLevel, zerolog.InfoLevel)
+
+	var coord *coordinator
+	if opts.CoordinatorURL != "" {
+		coord = newCoordinator(opts.CoordinatorURL, net.JoinHostPort(opts.Host, fmt.Sprintf("%d", opts.Port)), opts.Timeout)
+	}
+
+	// a nil semaphore admits every test
+	var slots chan struct{}
+	if opts.MaxConcurrentTests != Unlimited {
+		slots = make(chan struct{}, opts.MaxConcurrentTests)
+		for i := uint32(0); i < opts.MaxConcurrentTests; i++ {
+			slots <- struct{}{}
+		}
+	}
+
+	sessions := newSessionRegistry()
+
+	// one limiter shared by every test's send loop keeps their sum under the cap
+	var limiter *transfer.Limiter
+	if opts.MaxBitrate > 0 {
+		limiter = transfer.NewSharedLimiter(opts.MaxBitrate)
 	}
 
 	return &ServerTCP{
-		host:        opts.Host,         // server listening host
-		port:        opts.Port,         // server listening port
-		psk:         opts.PSK,          // pre-shared key for HMAC authentication
-		authEnabled: len(opts.PSK) > 0, // enable auth if PSK is provided
-		timeout:     opts.Timeout,      // read/write timeout
-		slots:       slots,             // semaphore for max concurrent tests
+		host:         opts.Host,          // server listening host
+		port:         opts.Port,          // server listening port
+		psk:          opts.PSK,           // pre-shared key for HMAC authentication
+		authEnabled:  len(opts.PSK) > 0,  // enable auth if PSK is provided
+		timeout:      opts.Timeout,       // read/write timeout
+		authTimeout:  opts.AuthTimeout,   // wait for the auth answer, covering the client's computation
+		stallTimeout: opts.StallTimeout,  // inactivity budget for the data phase
+		startGrace:   opts.StartGrace,    // time allowed for the data phase to start moving bytes
+		maxHelloAge:  opts.MaxHelloAge,   // replay window for hello packets
+		clockSkew:    opts.ClockSkew,     // clock skew tolerance for the replay window
+		maxPause:     opts.MaxPause,      // total paused time allowed per test
+		proxyProto:   opts.ProxyProtocol, // expect a PROXY protocol header before the FLO header
+		cpus:         opts.CPUs,          // CPUs the transfer loops are pinned to
+		writeMode:    opts.WriteMode,     // data phase write mode
+		summaryLevel: summaryLevel,       // per-test summary log level
+		coord:        coord,              // live interval push to a coordinator (optional)
+		slots:        slots,              // semaphore for max concurrent tests
+		sessions:     sessions,           // tests in progress, by session ID
+		limiter:      limiter,            // aggregate send rate cap (optional)
+		capture:      opts.Capture,       // handshake packet capture (optional)
+		tlsConfig:    opts.TLSConfig,     // TLS configuration, required of clients if set
+		shutGrace:    opts.ShutdownGrace, // time running tests get to finish their interval on shutdown
 	}
 }
 
 func (s *ServerTCP) slotAcquire() bool {
+	if s.slots == nil {
+		return true
+	}
 	select {
 	case <-s.slots:
 		return true
@@ -65,27 +164,15 @@ func (s *ServerTCP) slotAcquire() bool {
 }
 
 func (s *ServerTCP) slotRelease() {
-	s.slots <- struct{}{}
-}
-
-// recvHeader reads and unmarshals a packet header from the connection
-func (s *ServerTCP) recvHeader(conn net.Conn, r *bufio.Reader) (*protocol.Header, []byte, error) {
-	conn.SetReadDeadline(time.Now().Add(s.timeout))
-
-	bufHeader, err := utils.ReadExact(r, protocol.HeaderSize)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read packet header: %w", err)
-	}
-
-	header, err := protocol.UnmarshalHeader(bufHeader)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to unmarshal packet header: %w", err)
+	if s.slots == nil {
+		return
 	}
-
-	return header, bufHeader, nil
+	s.slots <- struct{}{}
 }
 
-// Run starts the TCP server and listens for incoming connections
+// Run starts the TCP server and listens for incoming connections until the context ends, returning
+// nil. If the listener fails for good before then, it returns a *ListenerError. With a shutdown
+// grace, Run waits for the running tests to wind down and log their summaries before returning.
 func (s *ServerTCP) Run(ctx context.Context) error {
 	address := fmt.Sprintf("%s:%d", s.host, s.port)
 	listener, err := net.Listen("tcp", address)
@@ -94,23 +181,42 @@ func (s *ServerTCP) Run(ctx context.Context) error {
 	}
 
 	defer listener.Close()
+	defer s.waitHandlers()
+
+	if s.coord != nil {
+		go s.coord.run(ctx)
+	}
 	go func() {
 		// Shutdown server listener on context cancellation
 		<-ctx.Done()
 		listener.Close()
 	}()
 
+	var backoff acceptBackoff
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			if ctx.Err() != nil {
 				return nil // server is shutting down
 			}
-			fmt.Printf("failed to accept connection: %v\n", err)
+			if isFatalAcceptError(err) {
+				return &ListenerError{Addr: address, Err: err}
+			}
+
+			delay := backoff.next()
+			log.Warn().Err(err).Str("backoff", delay.String()).Msg("Failed to accept connection")
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(delay):
+			}
 			continue
 		}
+		backoff.reset()
 		log.Debug().Str("remote_addr", conn.RemoteAddr().String()).Msg("Accepted new connection")
+		s.handlers.Add(1)
 		go func() {
+			defer s.handlers.Done()
 			err := s.handle(ctx, conn)
 			if err != nil {
 				log.Error().Err(err).Msg("Connection handler error")
@@ -119,25 +225,67 @@ func (s *ServerTCP) Run(ctx context.Context) error {
 	}
 }
 
+// waitHandlers waits for the connection handlers after a shutdown, if the server grants running
+// tests a grace to finish, bounded by the grace plus the timeout their final writes may take
+func (s *ServerTCP) waitHandlers() {
+	if s.shutGrace <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(s.shutGrace + s.timeout):
+		log.Warn().Msg("Running tests did not finish within the shutdown grace")
+	}
+}
+
 // handle processes an individual client connection
 func (s *ServerTCP) handle(ctx context.Context, conn net.Conn) error {
 	defer conn.Close()
 
-	// Set up buffered reader and writer
+	// Set up buffered reader, the writer waits until the connection is final
 	r := bufio.NewReader(conn)
+
+	// behind a load balancer the connection comes from the balancer, and the client's real
+	// address arrives in the PROXY header ahead of any FLO data
+	remote := conn.RemoteAddr()
+	if s.proxyProto {
+		_ = conn.SetReadDeadline(time.Now().Add(s.timeout))
+		hdr, err := proxyproto.Read(r)
+		_ = conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			return fmt.Errorf("failed to read PROXY protocol header: %w", err)
+		}
+		if hdr.Source != nil {
+			remote = hdr.Source
+		}
+		log.Debug().
+			Str("proxy_addr", conn.RemoteAddr().String()).
+			Str("remote_addr", remote.String()).
+			Int("version", hdr.Version).
+			Msg("Parsed PROXY protocol header")
+	}
+
+	// a TLS client opens with its handshake rather than a FLO header
+	conn, r, err := s.startTLS(ctx, conn, r)
+	if err != nil {
+		return err
+	}
 	w := bufio.NewWriter(conn)
 	defer w.Flush()
 
-	log.Debug().Msg("Set connection deadline")
-
 	// Read and parse packet header
-	header, headerBuf, err := s.recvHeader(conn, r)
+	stream := handshake.NewConnStream(conn, r, w)
+	stream.SetCapture(s.capture)
+	header, headerBuf, err := handshake.RecvHeader(stream, s.timeout)
 	if err != nil {
 		return fmt.Errorf("failed to read packet header: %w", err)
 	}
-	log.Debug().Msgf("Received header")
-
-	log.Debug().
+	log.Trace().
 		Uint8("version", uint8(header.Version)).
 		Str("type", packets.PacketTypeToString(header.Type)).
 		Msgf("Parsed header")
@@ -145,187 +293,266 @@ func (s *ServerTCP) handle(ctx context.Context, conn net.Conn) error {
 	// handle based on protocol version
 	switch header.Version {
 	case protocol.FloVersion1:
-		return s.handleV1(ctx, conn, r, w, headerBuf, header)
+		return s.handleV1(ctx, conn, remote, stream, r, w, headerBuf, header)
 	default:
-		return protocol.ErrUnsupportedVersion
+		// discard the rest of the unparseable hello so closing doesn't reset the connection
+		// before the client reads the ack
+		_, _ = r.Discard(r.Buffered())
+		err := handshake.RejectVersion(stream, s.timeout)
+		if err != nil {
+			return fmt.Errorf("failed to send invalid version ack: %w", err)
+		}
+		return fmt.Errorf("%w: %d", protocol.ErrUnsupportedVersion, header.Version)
 	}
 }
 
-// sendAckV1 creates and sends an Ack packet to the client
-func (s *ServerTCP) sendAckV1(conn net.Conn, w *bufio.Writer, sessionID ulid.ULID, auth packets.FloAuth, code packets.FloAckCode) error {
-	conn.SetWriteDeadline(time.Now().Add(s.timeout))
-
-	// create and send ack packet
-	pktAck, err := packets.NewAck(sessionID, auth, code)
-	if err != nil {
-		return fmt.Errorf("failed to create ack packet: %w", err)
+// checkHelloAge rejects hellos whose session ID was generated outside the allowed window. A
+// legitimate client generates the ULID moments before connecting, so an old timestamp
+// indicates a replayed hello; timestamps in the future beyond the skew are rejected as well.
+func (s *ServerTCP) checkHelloAge(pktHello *packets.PktHello) error {
+	if s.maxHelloAge <= 0 {
+		return nil
 	}
 
-	_, err = packets.SendPacket(w, pktAck)
-	if err != nil {
-		return fmt.Errorf("failed to send ack packet: %w", err)
+	age := time.Since(ulid.Time(pktHello.SessionID.Time()))
+	if age > s.maxHelloAge+s.clockSkew || age < -s.clockSkew {
+		return fmt.Errorf("%w: hello is %s old", protocol.ErrInvalidSessionID, age.Round(time.Millisecond))
 	}
-
 	return nil
 }
 
-// sendChallengeV1 creates and sends a Challenge packet to the client
-func (s *ServerTCP) sendChallengeV1(conn net.Conn, w *bufio.Writer, sessionID ulid.ULID, nonceServer [16]byte) (*packets.PktChallenge, error) {
-	conn.SetWriteDeadline(time.Now().Add(s.timeout))
-
-	pktChallenge, err := packets.NewChallenge(sessionID, packets.AuthHMAC, nonceServer)
+// validateHelloV1 applies the server's policy to a parsed hello before authentication. The hello's
+// security must match whether it arrived over TLS, which a server with TLS configured requires.
+func (s *ServerTCP) validateHelloV1(pktHello *packets.PktHello, secure bool) error {
+	// reject stale (possibly replayed) hellos
+	err := s.checkHelloAge(pktHello)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create challenge packet: %w", err)
+		return err
 	}
 
-	_, err = packets.SendPacket(w, pktChallenge)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send challenge packet: %w", err)
+	// reject transports this server doesn't implement, and direction/transport combinations that
+	// cannot be tested
+	if pktHello.Transport != packets.TransportTCP && pktHello.Transport != packets.TransportUDP {
+		return protocol.ErrUnsupportedTransport
 	}
 
-	log.Debug().Str("session_id", pktChallenge.SessionID.String()).Msg("Challenge packet sent")
-	return pktChallenge, nil
-}
-
-// recvAnswerV1 reads and unmarshals an Answer packet from the client
-func (s *ServerTCP) recvAnswerV1(conn net.Conn, r *bufio.Reader, bufHeader []byte) (*packets.PktAnswer, []byte, error) {
-	conn.SetReadDeadline(time.Now().Add(s.timeout))
-
-	bufAnswer, err := utils.ReadExact(r, packets.PktAnswerSize-protocol.HeaderSize)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read answer packet: %w", err)
+	switch {
+	case !secure && s.tlsConfig != nil:
+		return protocol.ErrTLSRequired
+	case secure != (pktHello.Security == packets.SecurityTLS):
+		return protocol.ErrUnsupportedSecurity
+	case secure && pktHello.Transport != packets.TransportTCP:
+		// datagrams would bypass the TLS connection
+		return protocol.ErrUnsupportedSecurity
 	}
-	bufAnswer = append(bufHeader, bufAnswer...)
-
-	pktAnswer, err := packets.UnmarshalAnswer(bufAnswer)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to unmarshal answer packet: %w", err)
-	}
-
-	return pktAnswer, bufAnswer, nil
+	return packets.ValidateTransportDirection(pktHello.Transport, pktHello.Direction)
 }
 
-// handleAuthV1 performs the authentication handshake with the client
-func (s *ServerTCP) handleAuthV1(conn net.Conn, r *bufio.Reader, w *bufio.Writer, bufHello []byte, pktHello *packets.PktHello) (bool, error) {
-	// generate server nonce
-	nonceServer, err := utils.NewNonce()
-	if err != nil {
-		return false, fmt.Errorf("failed to generate server nonce: %w", err)
-	}
-
-	pktChallenge, err := s.sendChallengeV1(conn, w, pktHello.SessionID, nonceServer)
-	if err != nil {
-		return false, fmt.Errorf("failed to send challenge packet: %w", err)
-	}
-
-	header, bufHeader, err := s.recvHeader(conn, r)
-	if err != nil {
-		return false, fmt.Errorf("failed to read answer packet header: %w", err)
-	}
-
-	if header.Version != protocol.FloVersion1 {
-		return false, fmt.Errorf("unsupported protocol version in answer packet: %d", header.Version)
-	}
-
-	if header.Type != packets.TypeAnswer {
-		return false, protocol.ErrIncorrectType
-	}
-
-	pktAnswer, _, err := s.recvAnswerV1(conn, r, bufHeader)
-	if err != nil {
-		return false, fmt.Errorf("failed to receive answer packet: %w", err)
-	}
-
-	// verify the expected auth hash
-	verified := packets.VerifyAuthHash(bufHello, nonceServer, s.psk, pktAnswer.AuthHash)
-	if !verified {
-		log.Warn().Str("session_id", pktChallenge.SessionID.String()).Msg("Authentication failed: invalid auth hash")
-	} else {
-		log.Info().Str("session_id", pktChallenge.SessionID.String()).Msg("Client authenticated successfully")
+// sendResultV1 sends the server's view of the test, in the form of Result the negotiated flags select
+func sendResultV1(rw io.ReadWriter, timeout time.Duration, sessionID ulid.ULID, flags packets.FloFlags, stats *protocol.Stats, duration, warmup time.Duration) error {
+	var pktResult *packets.PktResult
+	var err error
+	switch packets.ResultSize(flags) {
+	case packets.PktResultWindowSize:
+		pktResult, err = packets.NewResultWithWindow(sessionID, stats.GetBytesSent(), stats.GetBytesRcvd(), duration, warmup)
+	case packets.PktResultExtendedSize:
+		pktResult, err = packets.NewResultWithDuration(sessionID, stats.GetBytesSent(), stats.GetBytesRcvd(), duration)
+	default:
+		pktResult, err = packets.NewResult(sessionID, stats.GetBytesSent(), stats.GetBytesRcvd())
 	}
-
-	return verified, nil
-}
-
-// recvHelloV1 reads and unmarshals a Hello packet from the client
-func (s *ServerTCP) recvHelloV1(conn net.Conn, r *bufio.Reader, bufHeader []byte) (*packets.PktHello, []byte, error) {
-	conn.SetReadDeadline(time.Now().Add(s.timeout))
-
-	bufHello, err := utils.ReadExact(r, packets.PktHelloSize-protocol.HeaderSize)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to read hello packet: %w", err)
+		return fmt.Errorf("failed to create result packet: %w", err)
 	}
-	bufHello = append(bufHeader, bufHello...)
 
-	pktHello, err := packets.UnmarshalHello(bufHello)
+	_, err = handshake.Send(rw, timeout, pktResult)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to unmarshal hello packet: %w", err)
+		return fmt.Errorf("failed to send result packet: %w", err)
 	}
-
-	return pktHello, bufHello, nil
+	return nil
 }
 
 // handleV1 processes a FLO v1 connection
-func (s *ServerTCP) handleV1(ctx context.Context, conn net.Conn, r *bufio.Reader, w *bufio.Writer, bufHeader []byte, header *protocol.Header) error {
+func (s *ServerTCP) handleV1(ctx context.Context, conn net.Conn, remote net.Addr, stream *handshake.ConnStream, r *bufio.Reader, w *bufio.Writer, bufHeader []byte, header *protocol.Header) error {
 	// Handle FLO v1 connection
 	if header.Type != packets.TypeHello {
 		return protocol.ErrIncorrectType
 	}
 
-	// read the rest of the hello packet and re-assemble
-	pktHello, bufHello, err := s.recvHelloV1(conn, r, bufHeader)
+	_, secure := conn.(*tls.Conn)
+	pktHello, auth, err := handshake.Server(stream, bufHeader, handshake.ServerConfig{
+		PSK:      s.psk,
+		Timeout:  s.timeout,
+		Validate: func(pktHello *packets.PktHello) error { return s.validateHelloV1(pktHello, secure) },
+		AuthWait: s.authTimeout,
+	})
+	if err != nil {
+		return err
+	}
 
-	// perform authentication if it is enabled on the server
-	auth := packets.AuthNone
-	if s.authEnabled {
-		auth = packets.AuthHMAC
-		authenticated, err := s.handleAuthV1(conn, r, w, bufHello, pktHello)
-		if err != nil {
-			return fmt.Errorf("authentication failed: %w", err)
-		}
+	var stats protocol.Stats
 
-		if !authenticated {
-			err := s.sendAckV1(conn, w, pktHello.SessionID, auth, packets.AckAuthFailed)
-			if err != nil {
-				return fmt.Errorf("failed to send auth failed ack: %w", err)
-			}
-			return protocol.ErrAuthFailed
+	// a shutdown lets the test finish its current interval, but a stall or an operator's cancel
+	// still stops it at once
+	if s.shutGrace > 0 {
+		var cancelGrace context.CancelFunc
+		interval := transfer.DefaultInterval(time.Duration(pktHello.DurationMS) * time.Millisecond)
+		ctx, cancelGrace = transfer.WithShutdownGrace(ctx, s.shutGrace, interval, &stats)
+		defer cancelGrace()
+	}
+
+	// the handler's context is cancelled when the test ends, stalls, or an operator cancels it
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the test's first connection takes its slot, and any parallel streams join it
+	sessStream := &sessionStream{cancel: cancel, stats: &stats}
+
+	// a client whose hello carries no flags predates the ack's extension block and reads only
+	// its base layout, so it gets no slot counts and cannot run a datagram test
+	extendedAck := pktHello.Flags != 0
+	err = s.sessions.join(pktHello.SessionID, pktHello.StreamIndex, newSession(remote, pktHello.Direction, pktHello.Streams), sessStream, s.slotAcquire)
+	if errors.Is(err, errNoSlot) {
+		var slotsTotal, slotsFree uint32
+		if extendedAck {
+			slotsTotal, slotsFree = uint32(cap(s.slots)), uint32(len(s.slots))
 		}
-	}
-
-	if s.slotAcquire() == false {
-		err := s.sendAckV1(conn, w, pktHello.SessionID, auth, packets.AckBusy)
+		err := handshake.SendBusyAck(stream, s.timeout, pktHello.SessionID, auth, slotsTotal, slotsFree)
 		if err != nil {
 			return fmt.Errorf("failed to send busy ack: %w", err)
 		}
 		return fmt.Errorf("server is busy: max concurrent tests reached")
 	}
-	defer s.slotRelease()
-
-	err = s.sendAckV1(conn, w, pktHello.SessionID, auth, packets.AckOK)
+	if err != nil {
+		errAck := handshake.SendAck(stream, s.timeout, pktHello.SessionID, auth, packets.AckBadSession, 0)
+		if errAck != nil {
+			return fmt.Errorf("failed to send bad session ack: %w", errAck)
+		}
+		return fmt.Errorf("%w: %w", protocol.ErrInvalidSessionID, err)
+	}
+	defer s.leaveSession(pktHello.SessionID)
+
+	// accept the optional features this server implements. Completion and pausing are carried
+	// in-band on the data stream, so a datagram test gets only the result and pings, exchanged on
+	// its control connection. Each extension of the result requires the one before it.
+	datagrams := pktHello.Transport == packets.TransportUDP
+	flags := pktHello.Flags & (packets.FlagExplicitEnd | packets.FlagPause | packets.FlagResult | packets.FlagResultDuration | packets.FlagResultWindow | packets.FlagPing)
+	if datagrams {
+		flags &= packets.FlagResult | packets.FlagResultDuration | packets.FlagResultWindow | packets.FlagPing
+	}
+	if flags&packets.FlagResult == 0 {
+		flags &^= packets.FlagResultDuration
+	}
+	if flags&packets.FlagResultDuration == 0 {
+		flags &^= packets.FlagResultWindow
+	}
+	explicitEnd := flags&packets.FlagExplicitEnd != 0
+	pausable := flags&packets.FlagPause != 0
+	sendResult := flags&packets.FlagResult != 0
+
+	// a datagram test's data phase runs on its own socket, whose port the ack tells the client
+	var data *net.UDPConn
+	if datagrams {
+		if !extendedAck {
+			err = fmt.Errorf("%w: datagram test from a client that cannot receive the data port", protocol.ErrUnsupportedTransport)
+		} else {
+			data, err = listenData(conn)
+		}
+		if err != nil {
+			errAck := handshake.SendAck(stream, s.timeout, pktHello.SessionID, auth, packets.AckBadTransport, 0)
+			if errAck != nil {
+				return fmt.Errorf("failed to send bad transport ack: %w", errAck)
+			}
+			return err
+		}
+		defer data.Close()
+		err = handshake.SendDataAck(stream, s.timeout, pktHello.SessionID, auth, flags, uint16(data.LocalAddr().(*net.UDPAddr).Port))
+	} else {
+		err = handshake.SendAck(stream, s.timeout, pktHello.SessionID, auth, packets.AckOK, flags)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to send ok ack: %w", err)
 	}
 
+	// the client times its round trips before the data phase begins
+	if flags&packets.FlagPing != 0 {
+		n, err := handshake.PingServer(stream, s.timeout, pktHello.SessionID)
+		if err != nil {
+			return fmt.Errorf("ping phase failed: %w", err)
+		}
+		log.Debug().Str("session_id", pktHello.SessionID.String()).Int("pings", n).Msg("Answered client pings")
+	}
+
 	duration := time.Duration(pktHello.DurationMS) * time.Millisecond
 	warmup := time.Duration(pktHello.WarmupMS) * time.Millisecond
 
-	var stats protocol.Stats
-	var t = time.Now()
+	var pauser *transfer.Pauser
+	if pausable {
+		pauser = transfer.NewPauser(s.maxPause)
+	}
+
+	// abort tests that stop making progress so they don't hold a slot indefinitely
+	if s.stallTimeout > 0 {
+		go s.watchStall(ctx, cancel, pktHello.SessionID, &stats, warmup, pauser)
+	}
+	go s.watchStart(ctx, cancel, pktHello.SessionID, &stats, pauser)
+
+	opts := transfer.OptionsFromTimeout(s.timeout)
+	opts.PrimeBytes = pktHello.PrimeBytes
+	opts.WriteMode = s.writeMode
+	// the sender applies the byte target, so this server does when the client downloads over a
+	// stream, whose end tells the client the target was reached
+	if pktHello.Direction == protocol.DirectionDownload && !datagrams {
+		opts.BytesTarget = pktHello.BytesTarget
+	} else {
+		opts.BytesPromised = pktHello.BytesTarget
+	}
+	opts.CPUs = s.cpus
+	opts.Limiter = s.limiter
+	if pktHello.RateBps > 0 {
+		// the client's rate applies to this test alone, within the cap shared by all tests
+		opts.Limiter = transfer.NewLimiter(pktHello.RateBps, pktHello.ChunkSize).Within(s.limiter)
+	}
+	opts.NoHalfClose = explicitEnd || sendResult // the End or Result follows the data on the connection
+	if explicitEnd {
+		opts.EndMarker = packets.EndMarker(packets.TypeEnd, pktHello.SessionID)
+	}
+	if pausable {
+		opts.Pauser = pauser
+		opts.PauseMarker = packets.PauseMarker(pktHello.SessionID)
+		opts.ResumeMarker = packets.ResumeMarker(pktHello.SessionID)
+	}
+	if s.coord != nil {
+		opts.Sinks = []transfer.StatsSink{
+			transfer.ConsoleSink{},
+			coordinatorSink{coord: s.coord, sessionID: pktHello.SessionID.String()},
+		}
+	}
 
-	switch pktHello.Direction {
-	case protocol.DirectionBidi:
-		err = transfer.TransferData(ctx, conn, r, w, pktHello.ChunkSize, duration, warmup, &stats)
+	start := time.Now()
+	switch {
+	case datagrams:
+		err = s.transferDatagrams(ctx, stream, data, remote, pktHello, &stats, opts)
+		if err != nil {
+			return err
+		}
+	case pktHello.Direction == protocol.DirectionBidi:
+		err = transfer.TransferData(ctx, conn, r, w, pktHello.ChunkSize, duration, warmup, &stats, opts)
 		if err != nil {
 			return fmt.Errorf("data transfer failed: %w", err)
 		}
-	case protocol.DirectionUpload:
-		err = transfer.TransferData(ctx, conn, r, nil, pktHello.ChunkSize, duration, warmup, &stats)
+	case pktHello.Direction == protocol.DirectionUpload:
+		err = transfer.TransferData(ctx, conn, r, nil, pktHello.ChunkSize, duration, warmup, &stats, opts)
 		if err != nil {
 			return fmt.Errorf("data receive failed: %w", err)
 		}
-	case protocol.DirectionDownload:
-		err = transfer.TransferData(ctx, conn, nil, w, pktHello.ChunkSize, duration, warmup, &stats)
+	case pktHello.Direction == protocol.DirectionDownload:
+		// a pausable client sends its Pause and Resume packets on the otherwise idle direction
+		var control *bufio.Reader
+		if pausable {
+			control = r
+		}
+		opts.Counters = transfer.CountSent
+		err = transfer.TransferData(ctx, conn, control, w, pktHello.ChunkSize, duration, warmup, &stats, opts)
 		if err != nil {
 			return fmt.Errorf("data send failed: %w", err)
 		}
@@ -333,23 +560,102 @@ func (s *ServerTCP) handleV1(ctx context.Context, conn net.Conn, r *bufio.Reader
 		return fmt.Errorf("invalid direction: %d", pktHello.Direction)
 	}
 
-	_ = w.Flush()
+	// the transfer flushes its own writes, but check in case anything is left behind
+	if err := w.Flush(); err != nil {
+		stats.MarkFlushFailed()
+	}
 
-	durationReal := time.Since(t) - warmup
-	if durationReal < 0 {
-		durationReal = 0
+	paused := pauser.PausedSince(stats.GetCountStart())
+	durationReal := max(0, stats.MeasuredDuration(time.Now())-paused)
+	var warmupReal time.Duration
+	if countStart := stats.GetCountStart(); !countStart.IsZero() {
+		warmupReal = countStart.Sub(start)
 	}
 
+	// a cancelled transfer may leave its loops still using the connection, and there is nobody
+	// waiting for the trailing packets anyway
+	interrupted := ctx.Err() != nil
+
+	if explicitEnd && !interrupted {
+		direction := pktHello.Direction
+		_, err = handshake.Complete(stream, s.timeout, pktHello.SessionID, direction != protocol.DirectionUpload, direction != protocol.DirectionDownload)
+		if err != nil {
+			log.Warn().Err(err).Str("session_id", pktHello.SessionID.String()).Msg("Explicit completion with the client failed")
+		}
+	}
+
+	// the result follows the client's half-close, so a client still receiving never reads it as
+	// data, and no late data of the client's is left unread to reset the connection. Data of this
+	// server's still in flight precedes it, which the client skips by finding the result's marker.
+	// A download's byte target is the exception: the client receives until the result, which marks
+	// the end of this server's data, so it follows the data directly and the half-close comes after.
+	if sendResult && !interrupted {
+		drain := !explicitEnd && !datagrams
+		endsData := opts.BytesTarget > 0
+		if drain && !endsData {
+			stats.AddBytesTail(transfer.DrainTail(conn, r, s.timeout))
+		}
+
+		err = sendResultV1(stream, s.timeout, pktHello.SessionID, flags, &stats, durationReal, warmupReal)
+		if err != nil {
+			log.Warn().Err(err).Str("session_id", pktHello.SessionID.String()).Msg("Failed to send result to the client")
+		} else if drain && endsData {
+			stats.AddBytesTail(transfer.DrainTail(conn, r, s.timeout))
+		}
+	}
+
+	// the measured duration includes setup overhead and early termination, so report it
+	// alongside the client's requested duration; bitrates are always computed from the measured one
+	sessStream.duration = durationReal
+
 	sessionIdStr := pktHello.SessionID.String()
-	evt := log.Info().Str("session_id", sessionIdStr)
-	evt = evt.Str("duration", utils.DisplayTime(durationReal))
+	evt := log.WithLevel(s.summaryLevel).Str("session_id", sessionIdStr).Str("remote_addr", remote.String())
+	if pktHello.Streams > 1 {
+		evt = evt.Uint8("stream", pktHello.StreamIndex)
+	}
+	evt = evt.Str("transport", packets.TransportToString(pktHello.Transport)).
+		Str("security", packets.SecurityToString(pktHello.Security)).
+		Str("direction", protocol.DirectionToString(pktHello.Direction))
+	evt = evt.Str("duration_requested", utils.DisplayTime(duration)).
+		Str("duration_measured", utils.DisplayTime(durationReal)).
+		Str("chunk_size", utils.DisplayBytes(uint64(pktHello.ChunkSize)))
+	if pktHello.RateBps > 0 {
+		evt = evt.Str("rate_requested", utils.DisplayBitsPerTime(pktHello.RateBps/8, time.Second))
+	}
+	if paused > 0 {
+		evt = evt.Str("paused", utils.DisplayTime(paused))
+	}
 	if stats.GetBytesSent() > 0 {
 		evt = evt.Str("total_sent", utils.DisplayBytes(stats.GetBytesSent())).
 			Str("avg_sent", utils.DisplayBitsPerTime(stats.GetBytesSent(), durationReal))
+		if overhead := stats.GetOverheadSent(); overhead > 0 {
+			evt = evt.Str("wire_sent", utils.DisplayBitsPerTime(stats.GetBytesSent()+overhead, durationReal))
+		}
 	}
 	if stats.GetBytesRcvd() > 0 {
 		evt = evt.Str("total_rcvd", utils.DisplayBytes(stats.GetBytesRcvd())).
 			Str("avg_rcvd", utils.DisplayBitsPerTime(stats.GetBytesRcvd(), durationReal))
+		if overhead := stats.GetOverheadRcvd(); overhead > 0 {
+			evt = evt.Str("wire_rcvd", utils.DisplayBitsPerTime(stats.GetBytesRcvd()+overhead, durationReal))
+		}
+	}
+	if stats.FlushFailed() {
+		evt = evt.Str("note", "final flush failed")
+	}
+	if stats.GetWrites() > 0 {
+		evt = evt.Str("writes_per_sec", utils.DisplayOpsPerTime(stats.GetWrites(), durationReal))
+	}
+	if stats.GetReads() > 0 {
+		evt = evt.Str("reads_per_sec", utils.DisplayOpsPerTime(stats.GetReads(), durationReal))
+	}
+	// each write and read of a datagram test is one datagram, so their counts show the loss
+	if datagrams {
+		if stats.GetWrites() > 0 {
+			evt = evt.Uint64("datagrams_sent", stats.GetWrites())
+		}
+		if stats.GetReads() > 0 {
+			evt = evt.Uint64("datagrams_rcvd", stats.GetReads())
+		}
 	}
 	evt.Msg("Client data transfer complete")
 
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/handshake"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/oklog/ulid/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// recordTypeHandshake is the first byte a TLS client sends, the record type of its ClientHello. A
+// FLO header starts with its magic instead, so peeking one byte tells the two apart.
+const recordTypeHandshake = 0x16
+
+// bufferedConn reads a connection through a buffered reader already holding its first bytes
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// startTLS upgrades the connection to TLS if the client opened with a TLS handshake, returning the
+// connection and buffered reader to use from then on. A client starting in plaintext is left as
+// is; whether that is acceptable is decided when its hello is validated.
+func (s *ServerTCP) startTLS(ctx context.Context, conn net.Conn, r *bufio.Reader) (net.Conn, *bufio.Reader, error) {
+	_ = conn.SetReadDeadline(time.Now().Add(s.timeout))
+	first, err := r.Peek(1)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read from connection: %w", err)
+	}
+	if first[0] != recordTypeHandshake {
+		return conn, r, nil
+	}
+
+	if s.tlsConfig == nil {
+		return nil, nil, s.rejectTLS(conn, r)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	tlsConn := tls.Server(&bufferedConn{Conn: conn, r: r}, s.tlsConfig)
+	err = tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed TLS handshake: %w", err)
+	}
+
+	state := tlsConn.ConnectionState()
+	log.Debug().
+		Str("version", tls.VersionName(state.Version)).
+		Str("cipher_suite", tls.CipherSuiteName(state.CipherSuite)).
+		Msg("TLS handshake complete")
+	return tlsConn, bufio.NewReader(tlsConn), nil
+}
+
+// rejectTLS answers a TLS handshake when the server has no TLS configuration. The client can't
+// parse a FLO packet as TLS, but it recognizes the plaintext ack in its failed handshake.
+func (s *ServerTCP) rejectTLS(conn net.Conn, r *bufio.Reader) error {
+	// discard the ClientHello so closing doesn't reset the connection before the client reads the ack
+	_, _ = r.Discard(r.Buffered())
+
+	stream := handshake.NewConnStream(conn, r, bufio.NewWriter(conn))
+	err := handshake.SendAck(stream, s.timeout, ulid.ULID{}, packets.AuthNone, packets.AckBadSecurity, 0)
+	if err != nil {
+		return fmt.Errorf("failed to send bad security ack: %w", err)
+	}
+	return fmt.Errorf("%w: client requested TLS but none is configured", protocol.ErrUnsupportedSecurity)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/handshake"
+	"github.com/goodieshq/goflo/internal/protocol/packets/v1"
+	"github.com/goodieshq/goflo/internal/protocol/transfer"
+	"github.com/rs/zerolog/log"
+)
+
+// listenData opens the data socket of a datagram test on an ephemeral port, at the local address
+// the client reached the control connection on so it is known to be routable
+func listenData(conn net.Conn) (*net.UDPConn, error) {
+	var ip net.IP
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+	data, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data socket: %w", err)
+	}
+	return data, nil
+}
+
+// transferDatagrams runs the data phase of a datagram test on the data socket, once the client at
+// remote has registered its address on it. The handshake connection stays open as the control
+// channel.
+func (s *ServerTCP) transferDatagrams(ctx context.Context, stream *handshake.ConnStream, data *net.UDPConn, remote net.Addr, pktHello *packets.PktHello, stats *protocol.Stats, opts transfer.Options) error {
+	tcpAddr, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("%w: client address %s is not an IP address", protocol.ErrUnsupportedTransport, remote)
+	}
+	addr, err := handshake.RegisterServer(stream, data, s.timeout, pktHello.SessionID, tcpAddr.IP)
+	if err != nil {
+		return err
+	}
+
+	duration := time.Duration(pktHello.DurationMS) * time.Millisecond
+	warmup := time.Duration(pktHello.WarmupMS) * time.Millisecond
+	send := pktHello.Direction == protocol.DirectionDownload
+
+	// registrations repeated while the confirmation was in flight may still arrive
+	opts.StrayMarker = packets.RegisterMarker(pktHello.SessionID)
+	err = transfer.TransferDatagrams(ctx, newPeerConn(data, addr), send, pktHello.ChunkSize, duration, warmup, stats, opts)
+	if err != nil {
+		return fmt.Errorf("datagram transfer failed: %w", err)
+	}
+	return nil
+}
+
+// maxWriteErrors is how many consecutive datagrams peerConn drops on send errors before failing the
+// transfer. One that can't be sent is lost like any other, but every send to a peer that is gone
+// fails. A refusal from the peer fails the transfer at once, as the kernel rate limits them.
+const maxWriteErrors = 8
+
+// peerConn is the server's data socket narrowed to one client, so the transfer loops can use it
+// like a connected socket. Datagrams from any other source are dropped.
+type peerConn struct {
+	*net.UDPConn
+	peer      *net.UDPAddr
+	connected bool // the socket is connected to peer, so the peer's refusals fail sends
+	writeErrs int  // consecutive failed sends
+}
+
+// newPeerConn narrows data to peer, connecting the socket where the platform allows so a client
+// that stops receiving ends the transfer rather than being sent to until its deadline
+func newPeerConn(data *net.UDPConn, peer *net.UDPAddr) *peerConn {
+	c := &peerConn{UDPConn: data, peer: peer}
+	err := connectUDP(data, peer)
+	if err != nil {
+		log.Debug().Err(err).Str("peer", peer.String()).Msg("Data socket left unconnected, refusals from the client will go unnoticed")
+	} else {
+		c.connected = true
+	}
+	return c
+}
+
+func (c *peerConn) Read(p []byte) (int, error) {
+	for {
+		n, addr, err := c.ReadFromUDP(p)
+		if err != nil || (addr.IP.Equal(c.peer.IP) && addr.Port == c.peer.Port) {
+			return n, err
+		}
+	}
+}
+
+func (c *peerConn) Write(p []byte) (int, error) {
+	var n int
+	var err error
+	if c.connected {
+		n, err = c.UDPConn.Write(p)
+	} else {
+		n, err = c.WriteToUDP(p, c.peer)
+	}
+	if err == nil {
+		c.writeErrs = 0
+		return n, nil
+	}
+
+	c.writeErrs++
+	if c.writeErrs < maxWriteErrors && !errors.Is(err, syscall.ECONNREFUSED) {
+		return 0, nil
+	}
+	return 0, fmt.Errorf("failed to send datagram: %w", err)
+}
+
+func (c *peerConn) RemoteAddr() net.Addr {
+	return c.peer
+}
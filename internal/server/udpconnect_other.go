@@ -0,0 +1,13 @@
+//go:build !unix
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+// connectUDP is unavailable on this platform
+func connectUDP(conn *net.UDPConn, peer *net.UDPAddr) error {
+	return errors.ErrUnsupported
+}
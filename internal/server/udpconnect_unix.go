@@ -0,0 +1,54 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// connectUDP connects the socket to peer in the kernel, behind the net package's back, so the
+// kernel reports the peer's ICMP refusals as errors on the socket
+func connectUDP(conn *net.UDPConn, peer *net.UDPAddr) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("failed to access data socket: %w", err)
+	}
+
+	var errConnect error
+	err = raw.Control(func(fd uintptr) {
+		local, err := syscall.Getsockname(int(fd))
+		if err != nil {
+			errConnect = err
+			return
+		}
+
+		var sa syscall.Sockaddr
+		if _, ok := local.(*syscall.SockaddrInet6); ok {
+			sa6 := &syscall.SockaddrInet6{Port: peer.Port}
+			copy(sa6.Addr[:], peer.IP.To16())
+			if ifi, err := net.InterfaceByName(peer.Zone); err == nil {
+				sa6.ZoneId = uint32(ifi.Index)
+			}
+			sa = sa6
+		} else {
+			ip4 := peer.IP.To4()
+			if ip4 == nil {
+				errConnect = fmt.Errorf("IPv6 peer %s on an IPv4 socket", peer)
+				return
+			}
+			sa4 := &syscall.SockaddrInet4{Port: peer.Port}
+			copy(sa4.Addr[:], ip4)
+			sa = sa4
+		}
+		errConnect = syscall.Connect(int(fd), sa)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to access data socket: %w", err)
+	}
+	if errConnect != nil {
+		return fmt.Errorf("failed to connect data socket: %w", errConnect)
+	}
+	return nil
+}
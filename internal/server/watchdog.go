@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/goodieshq/goflo/internal/protocol"
+	"github.com/goodieshq/goflo/internal/protocol/transfer"
+	"github.com/goodieshq/goflo/internal/utils"
+	"github.com/oklog/ulid/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// watchStall cancels a test when no data progress is observed for the configured stall timeout.
+// Time the client keeps the test paused (if it may) is not a stall.
+func (s *ServerTCP) watchStall(ctx context.Context, cancel context.CancelFunc, sessionID ulid.ULID, stats *protocol.Stats, warmup time.Duration, pauser *transfer.Pauser) {
+	// stats are not counted during warmup, so only start watching afterwards
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(warmup):
+	}
+
+	interval := s.stallTimeout / 5
+	if interval <= 0 || interval > time.Second {
+		interval = time.Second
+	}
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	lastTotal := stats.GetBytesSent() + stats.GetBytesRcvd()
+	lastProgress := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			total := stats.GetBytesSent() + stats.GetBytesRcvd()
+			if total != lastTotal || pauser.Paused() {
+				lastTotal = total
+				lastProgress = time.Now()
+				continue
+			}
+
+			stalled := time.Since(lastProgress)
+			if stalled >= s.stallTimeout {
+				log.Warn().
+					Str("session_id", sessionID.String()).
+					Str("stalled_for", utils.DisplayTime(stalled)).
+					Msg("Aborting test: no data progress")
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// watchStart cancels a test whose data phase moves no bytes at all within the start grace, so a
+// client that stalls right after the handshake doesn't hold its slot until the stall watchdog
+// (which waits out the warmup first) or the test's timeout catches it
+func (s *ServerTCP) watchStart(ctx context.Context, cancel context.CancelFunc, sessionID ulid.ULID, stats *protocol.Stats, pauser *transfer.Pauser) {
+	timer := time.NewTimer(s.startGrace)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	if stats.GetBytesWarmup()+stats.GetBytesSent()+stats.GetBytesRcvd() > 0 || pauser.Paused() {
+		return
+	}
+
+	log.Warn().
+		Str("session_id", sessionID.String()).
+		Str("grace", utils.DisplayTime(s.startGrace)).
+		Msg("Reclaiming slot: data phase did not start")
+	cancel()
+}
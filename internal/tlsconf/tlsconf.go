@@ -0,0 +1,152 @@
+// Package tlsconf builds the TLS configurations used by the client and server.
+//
+// Mutual TLS and PSK authentication are independent layers and may be combined: the TLS handshake
+// (verifying the client certificate when the server has a client CA configured) completes first,
+// and the FLO handshake then still performs its HMAC challenge if the server has a PSK. Configure
+// only one of them to use it alone.
+package tlsconf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInvalidPin  = errors.New("invalid certificate pin")
+	ErrPinMismatch = errors.New("server certificate does not match pin")
+)
+
+// ClientOpts configures how the client verifies the server's certificate
+type ClientOpts struct {
+	ServerName string // name to verify the server certificate against (defaults to the dialed host)
+	CAFile     string // PEM bundle of CAs to trust instead of the system pool
+	Pin        string // hex SHA-256 of the server's leaf certificate, checked in addition to the CA chain
+	CertFile   string // PEM client certificate presented for mutual TLS (optional)
+	KeyFile    string // PEM private key for CertFile
+}
+
+// ServerOpts configures the server's certificate and, optionally, client certificate verification
+type ServerOpts struct {
+	CertFile     string // PEM server certificate
+	KeyFile      string // PEM private key for CertFile
+	ClientCAFile string // PEM bundle of CAs client certificates must chain to; enables mutual TLS
+}
+
+// Fingerprint returns the hex SHA-256 of a DER encoded certificate, the format expected by pins
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParsePin decodes a hex SHA-256 pin. Colon separators (as printed by openssl) and either case
+// are accepted.
+func ParsePin(pin string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidPin, err)
+	}
+	if len(b) != sha256.Size {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidPin, sha256.Size, len(b))
+	}
+	return b, nil
+}
+
+// PinVerifier returns a tls.Config.VerifyPeerCertificate callback rejecting any server whose
+// leaf certificate does not hash to the given pin
+func PinVerifier(pin string) (func([][]byte, [][]*x509.Certificate) error, error) {
+	want, err := ParsePin(pin)
+	if err != nil {
+		return nil, err
+	}
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("%w: no certificate presented", ErrPinMismatch)
+		}
+		got := sha256.Sum256(rawCerts[0])
+		if !bytes.Equal(got[:], want) {
+			return fmt.Errorf("%w: got %s", ErrPinMismatch, hex.EncodeToString(got[:]))
+		}
+		return nil
+	}, nil
+}
+
+// loadCertPool reads a PEM bundle into a new certificate pool
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("failed to parse CA file %s: no certificates found", path)
+	}
+	return pool, nil
+}
+
+// NewClientConfig builds the client TLS configuration. The pin is enforced on top of normal chain
+// verification, so a pinned self-signed server must also be trusted through CAFile.
+func NewClientConfig(opts ClientOpts) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName: opts.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if opts.CAFile != "" {
+		pool, err := loadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.Pin != "" {
+		verify, err := PinVerifier(opts.Pin)
+		if err != nil {
+			return nil, err
+		}
+		cfg.VerifyPeerCertificate = verify
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// NewServerConfig builds the server TLS configuration. When a client CA is configured, clients
+// must present a certificate chaining to it or the TLS handshake fails.
+func NewServerConfig(opts ServerOpts) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		ClientAuth:   tls.NoClientCert,
+	}
+
+	if opts.ClientCAFile != "" {
+		pool, err := loadCertPool(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,139 @@
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selfSigned writes a self-signed certificate for localhost and its key to a temporary directory,
+// returning their paths and the certificate's DER encoding
+func selfSigned(t *testing.T) (certFile, keyFile string, der []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile, der
+}
+
+// tlsHandshake runs a TLS handshake between the two configurations over loopback TCP, returning
+// the client's error. net.Pipe won't do, as a rejecting client writes its alert while the server
+// is still writing its flight.
+func tlsHandshake(t *testing.T, clientCfg, serverCfg *tls.Config) error {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = tls.Server(conn, serverCfg).Handshake()
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	err = tls.Client(conn, clientCfg).Handshake()
+	conn.Close()
+	<-done
+	return err
+}
+
+func TestPinVerifier(t *testing.T) {
+	certFile, keyFile, der := selfSigned(t)
+	serverCfg, err := NewServerConfig(ServerOpts{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("NewServerConfig: %v", err)
+	}
+
+	_, _, other := selfSigned(t)
+	tests := []struct {
+		name string
+		pin  string
+		want error
+	}{
+		{"match", Fingerprint(der), nil},
+		{"match with colons and upper case", colonPin(Fingerprint(der)), nil},
+		{"mismatch", Fingerprint(other), ErrPinMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientCfg, err := NewClientConfig(ClientOpts{ServerName: "localhost", CAFile: certFile, Pin: tt.pin})
+			if err != nil {
+				t.Fatalf("NewClientConfig: %v", err)
+			}
+			err = tlsHandshake(t, clientCfg, serverCfg)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("handshake error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePinInvalid(t *testing.T) {
+	for _, pin := range []string{"", "zz", strings.Repeat("ab", 31), strings.Repeat("ab", 33)} {
+		if _, err := ParsePin(pin); !errors.Is(err, ErrInvalidPin) {
+			t.Errorf("ParsePin(%q) error = %v, want %v", pin, err, ErrInvalidPin)
+		}
+	}
+}
+
+// colonPin formats a hex pin the way openssl prints fingerprints
+func colonPin(pin string) string {
+	var parts []string
+	for i := 0; i < len(pin); i += 2 {
+		parts = append(parts, strings.ToUpper(pin[i:i+2]))
+	}
+	return strings.Join(parts, ":")
+}
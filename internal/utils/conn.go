@@ -1,9 +1,32 @@
 package utils
 
-import "io"
+import (
+	"fmt"
+	"io"
+	"time"
+)
 
 func ReadExact(r io.Reader, n int) ([]byte, error) {
 	buf := make([]byte, n)
 	_, err := io.ReadFull(r, buf)
 	return buf, err
 }
+
+// readDeadliner is implemented by connections, and streams wrapping them, that can bound reads
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// ReadExactTimeout reads exactly n bytes like ReadExact, and if r can bound its reads and the
+// timeout is positive, fails unless they arrive within the timeout. Setting the deadline here
+// means no framed read can be left unbounded by a forgotten SetReadDeadline. The deadline stays
+// in place afterwards, as the next framed read sets its own.
+func ReadExactTimeout(r io.Reader, n int, timeout time.Duration) ([]byte, error) {
+	if d, ok := r.(readDeadliner); ok && timeout > 0 {
+		err := d.SetReadDeadline(time.Now().Add(timeout))
+		if err != nil {
+			return nil, fmt.Errorf("failed to set read deadline: %w", err)
+		}
+	}
+	return ReadExact(r, n)
+}
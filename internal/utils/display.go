@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"math"
 	"time"
 )
 
@@ -14,25 +15,37 @@ const (
 	gib = 1024 * 1024 * 1024
 )
 
+// DisplayPrecision is the number of decimal places used by the Display* helpers that don't take
+// an explicit precision. It is meant to be set once at startup (e.g. from a command line flag).
+var DisplayPrecision = 2
+
 func DisplayTime(duration time.Duration) string {
+	return DisplayTimePrec(duration, DisplayPrecision)
+}
+
+func DisplayTimePrec(duration time.Duration, precision int) string {
 	ns := duration.Nanoseconds()
 	switch {
 	case ns >= int64(time.Hour):
-		return fmt.Sprintf("%.2f h", duration.Hours())
+		return fmt.Sprintf("%.*f h", precision, duration.Hours())
 	case ns >= int64(time.Minute):
-		return fmt.Sprintf("%.2f m", duration.Minutes())
+		return fmt.Sprintf("%.*f m", precision, duration.Minutes())
 	case ns >= int64(time.Second):
-		return fmt.Sprintf("%.2f s", duration.Seconds())
+		return fmt.Sprintf("%.*f s", precision, duration.Seconds())
 	case ns >= int64(time.Millisecond):
-		return fmt.Sprintf("%.2f ms", float64(ns)/1e6)
+		return fmt.Sprintf("%.*f ms", precision, float64(ns)/1e6)
 	case ns >= int64(time.Microsecond):
-		return fmt.Sprintf("%.2f µs", float64(ns)/1e3)
+		return fmt.Sprintf("%.*f µs", precision, float64(ns)/1e3)
 	default:
 		return fmt.Sprintf("%d ns", ns)
 	}
 }
 
 func DisplayBitsPerTime(bytes uint64, duration time.Duration) string {
+	return DisplayBitsPerTimePrec(bytes, duration, DisplayPrecision)
+}
+
+func DisplayBitsPerTimePrec(bytes uint64, duration time.Duration, precision int) string {
 	if duration <= 0 {
 		return "0 bps"
 	}
@@ -40,25 +53,80 @@ func DisplayBitsPerTime(bytes uint64, duration time.Duration) string {
 
 	switch {
 	case bps >= 1e9:
-		return fmt.Sprintf("%.2f Gbps", bps/gb)
+		return fmt.Sprintf("%.*f Gbps", precision, bps/gb)
 	case bps >= 1e6:
-		return fmt.Sprintf("%.2f Mbps", bps/mb)
+		return fmt.Sprintf("%.*f Mbps", precision, bps/mb)
 	case bps >= 1e3:
-		return fmt.Sprintf("%.2f Kbps", bps/kb)
+		return fmt.Sprintf("%.*f Kbps", precision, bps/kb)
 	default:
-		return fmt.Sprintf("%.2f bps", bps)
+		return fmt.Sprintf("%.*f bps", precision, bps)
+	}
+}
+
+func DisplayOpsPerTime(ops uint64, duration time.Duration) string {
+	return DisplayOpsPerTimePrec(ops, duration, DisplayPrecision)
+}
+
+func DisplayOpsPerTimePrec(ops uint64, duration time.Duration, precision int) string {
+	if duration <= 0 {
+		return "0 /s"
+	}
+	rate := float64(ops) / duration.Seconds()
+
+	switch {
+	case rate >= 1e6:
+		return fmt.Sprintf("%.*f M/s", precision, rate/mb)
+	case rate >= 1e3:
+		return fmt.Sprintf("%.*f K/s", precision, rate/kb)
+	default:
+		return fmt.Sprintf("%.*f /s", precision, rate)
 	}
 }
 
 func DisplayBytes(bytes uint64) string {
+	return DisplayBytesPrec(bytes, DisplayPrecision)
+}
+
+func DisplayBytesPrec(bytes uint64, precision int) string {
 	switch {
 	case bytes >= gb:
-		return fmt.Sprintf("%.2f GB", float64(bytes)/gb)
+		return fmt.Sprintf("%.*f GB", precision, float64(bytes)/gb)
 	case bytes >= mb:
-		return fmt.Sprintf("%.2f MB", float64(bytes)/mb)
+		return fmt.Sprintf("%.*f MB", precision, float64(bytes)/mb)
 	case bytes >= kb:
-		return fmt.Sprintf("%.2f KB", float64(bytes)/kb)
+		return fmt.Sprintf("%.*f KB", precision, float64(bytes)/kb)
 	default:
 		return fmt.Sprintf("%d B", bytes)
 	}
 }
+
+// DisplayPercent formats a fraction (0.5 for half) as a percentage
+func DisplayPercent(fraction float64) string {
+	return DisplayPercentPrec(fraction, DisplayPrecision)
+}
+
+func DisplayPercentPrec(fraction float64, precision int) string {
+	return fmt.Sprintf("%.*f%%", precision, fraction*100)
+}
+
+// sparkBars are the block characters of a sparkline, from lowest to highest
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// Sparkline renders the values as a line of block characters scaled from zero to the largest
+// value, so a stall shows as the lowest bar whatever the steady state was
+func Sparkline(values []float64) string {
+	peak := 0.0
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+
+	line := make([]rune, len(values))
+	for i, v := range values {
+		level := 0
+		if peak > 0 && v > 0 {
+			level = int(math.Round(v / peak * float64(len(sparkBars)-1)))
+		}
+		line[i] = sparkBars[level]
+	}
+	return string(line)
+}
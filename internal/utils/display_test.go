@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// The helpers without an explicit precision all follow DisplayPrecision
+func TestDisplayPrecision(t *testing.T) {
+	defer func(prev int) { DisplayPrecision = prev }(DisplayPrecision)
+
+	tests := []struct {
+		precision int
+		want      [5]string
+	}{
+		{0, [5]string{"2 s", "12 Mbps", "2 K/s", "2 MB", "0%"}},
+		{3, [5]string{"1.500 s", "12.000 Mbps", "1.500 K/s", "1.500 MB", "0.125%"}},
+	}
+	for _, tt := range tests {
+		DisplayPrecision = tt.precision
+		got := [5]string{
+			DisplayTime(1500 * time.Millisecond),
+			DisplayBitsPerTime(1_500_000, time.Second),
+			DisplayOpsPerTime(1500, time.Second),
+			DisplayBytes(1_500_000),
+			DisplayPercent(0.00125),
+		}
+		if got != tt.want {
+			t.Errorf("precision %d: got %q, want %q", tt.precision, got, tt.want)
+		}
+	}
+}
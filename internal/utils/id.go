@@ -2,6 +2,9 @@ package utils
 
 import (
 	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/oklog/ulid/v2"
 )
@@ -24,3 +27,38 @@ func NewNonce() ([16]byte, error) {
 	}
 	return nonce, nil
 }
+
+// entropyCheckTimeout bounds how long CheckEntropy waits for the entropy source
+const entropyCheckTimeout = 5 * time.Second
+
+var ErrEntropyUnavailable = errors.New("entropy source unavailable")
+
+// CheckEntropy verifies that session IDs and nonces can be generated, so a missing or blocking
+// entropy source is reported at startup rather than on the first connection
+func CheckEntropy() error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := NewULID()
+		if err != nil {
+			errCh <- fmt.Errorf("%w: failed to generate session ID: %w", ErrEntropyUnavailable, err)
+			return
+		}
+		nonce, err := NewNonce()
+		if err != nil {
+			errCh <- fmt.Errorf("%w: failed to generate nonce: %w", ErrEntropyUnavailable, err)
+			return
+		}
+		if nonce == [16]byte{} {
+			errCh <- fmt.Errorf("%w: generated an all-zero nonce", ErrEntropyUnavailable)
+			return
+		}
+		errCh <- nil
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(entropyCheckTimeout):
+		return fmt.Errorf("%w: blocked for more than %s", ErrEntropyUnavailable, entropyCheckTimeout)
+	}
+}